@@ -0,0 +1,218 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/open-telemetry/opamp-go/client/internal"
+	"github.com/open-telemetry/opamp-go/client/types"
+	sharedinternal "github.com/open-telemetry/opamp-go/internal"
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+const (
+	defaultFallbackAfterAttempts = 3
+	defaultUpgradeRetryInterval  = 5 * time.Minute
+)
+
+// autoClient is an OpAMP Client implementation that selects the transport
+// automatically. It uses WebSocket transport when possible and falls back to plain
+// HTTP transport when the WebSocket upgrade is refused or repeatedly fails, see
+// types.AutoTransportSettings. Both transports share the client state and the
+// pending NextMessage, so nothing is lost when the transport is switched.
+type autoClient struct {
+	// The WebSocket client. Its ClientCommon is used by both transports.
+	ws *wsClient
+
+	// The sender that forwards ScheduleSend calls to the sender of the transport
+	// in use.
+	sender *internal.SwitchableSender
+
+	// The sender that performs HTTP request/response loop when using plain HTTP.
+	httpSender *internal.HTTPSender
+
+	fallbackAfterAttempts int
+	upgradeRetryInterval  time.Duration
+}
+
+// NewAuto creates a client that connects using WebSocket transport and falls back
+// to plain HTTP transport if WebSocket is not available.
+func NewAuto(logger types.Logger) *autoClient {
+	internalLogger := sharedinternal.NewLogger(logger)
+	wsSender := internal.NewSender(internalLogger)
+	httpSender := internal.NewHTTPSender(internalLogger)
+	sender := internal.NewSwitchableSender(wsSender, httpSender)
+
+	c := &autoClient{
+		ws:         newWebSocket(internalLogger, wsSender, sender),
+		sender:     sender,
+		httpSender: httpSender,
+	}
+	c.ws.abortConnect = c.shouldFallBack
+	return c
+}
+
+func (c *autoClient) Start(ctx context.Context, settings types.StartSettings) error {
+	if err := c.ws.prepareStart(ctx, settings); err != nil {
+		return err
+	}
+
+	c.fallbackAfterAttempts = settings.AutoTransport.FallbackAfterAttempts
+	if c.fallbackAfterAttempts <= 0 {
+		c.fallbackAfterAttempts = defaultFallbackAfterAttempts
+	}
+	c.upgradeRetryInterval = settings.AutoTransport.UpgradeRetryInterval
+	if c.upgradeRetryInterval <= 0 {
+		c.upgradeRetryInterval = defaultUpgradeRetryInterval
+	}
+
+	// Prepare the plain HTTP transport in case we need to fall back to it.
+	c.httpSender.SetBackoffPolicy(settings.BackoffPolicy)
+	c.httpSender.SetLongPollTimeout(settings.LongPollTimeout)
+	c.httpSender.SetNetworkSettings(c.ws.common.Network)
+
+	c.ws.common.StartConnectAndRun(c.runUntilStopped)
+	c.ws.common.Logger.Debug("Starting OpAMP auto transport client...")
+
+	return nil
+}
+
+func (c *autoClient) Stop(ctx context.Context) error {
+	return c.ws.Stop(ctx)
+}
+
+func (c *autoClient) AgentDescription() *protobufs.AgentDescription {
+	return c.ws.AgentDescription()
+}
+
+func (c *autoClient) ActiveEndpoint() types.Endpoint {
+	return c.ws.ActiveEndpoint()
+}
+
+func (c *autoClient) SetAgentDescription(descr *protobufs.AgentDescription) error {
+	return c.ws.SetAgentDescription(descr)
+}
+
+func (c *autoClient) UpdateEffectiveConfig(ctx context.Context) error {
+	return c.ws.UpdateEffectiveConfig(ctx)
+}
+
+func (c *autoClient) SetRemoteConfigStatus(status *protobufs.RemoteConfigStatus) error {
+	return c.ws.SetRemoteConfigStatus(status)
+}
+
+func (c *autoClient) SetPackageStatuses(statuses *protobufs.PackageStatuses) error {
+	return c.ws.SetPackageStatuses(statuses)
+}
+
+// shouldFallBack is called after every failed WebSocket connection attempt and
+// returns true if the client must fall back to plain HTTP transport.
+func (c *autoClient) shouldFallBack(resp *http.Response, failedAttempts int) bool {
+	if isUpgradeRefused(resp) {
+		c.ws.common.Logger.Debug("WebSocket upgrade is refused.", types.F("status", resp.Status))
+		return true
+	}
+	return failedAttempts >= c.fallbackAfterAttempts
+}
+
+// isUpgradeRefused returns true if the response to the WebSocket handshake
+// indicates that the Server does not support WebSocket upgrade: a successful
+// response other than 101 Switching Protocols, or one of the statuses that are
+// returned by Servers that reject the upgrade request itself. Other statuses,
+// such as rejected credentials or a wrong path, are not specific to WebSocket
+// and are handled as ordinary connection failures.
+func isUpgradeRefused(resp *http.Response) bool {
+	if resp == nil {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusBadRequest, http.StatusMethodNotAllowed, http.StatusUpgradeRequired:
+		return true
+	}
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+func (c *autoClient) runUntilStopped(ctx context.Context) {
+	// Iterates until we detect that the client is stopping or gave up connecting.
+	for {
+		if c.ws.common.IsStopping() {
+			return
+		}
+
+		err := c.ws.runOneCycle(ctx)
+		if errors.Is(err, types.ErrMaxAttemptsReached) {
+			return
+		}
+		if !errors.Is(err, errConnectAborted) {
+			continue
+		}
+
+		// Can't connect using WebSocket. Use plain HTTP until upgrading to
+		// WebSocket becomes possible.
+		conn, err := c.runHTTP(ctx)
+		if err != nil {
+			return
+		}
+
+		c.ws.common.Logger.Info("Upgraded to WebSocket transport.")
+		c.ws.setConnected(conn)
+		c.sender.SetActive(c.ws.sender)
+		c.ws.runConnected(ctx)
+	}
+}
+
+// runHTTP uses plain HTTP transport and periodically tries to upgrade to WebSocket.
+// Returns the established WebSocket connection once the upgrade succeeds. Returns
+// an error if the client is stopped or gave up sending the requests.
+func (c *autoClient) runHTTP(ctx context.Context) (*websocket.Conn, error) {
+	common := &c.ws.common
+	common.Logger.Info("Falling back to plain HTTP transport.")
+
+	// Prepare the first status report and make the HTTP sender send it.
+	if err := common.PrepareFirstMessage(ctx); err != nil {
+		common.Logger.Error("Cannot prepare the first message", types.F("error", err))
+	}
+	c.sender.SetActive(c.httpSender)
+
+	httpDone := make(chan error, 1)
+	go func() {
+		httpDone <- c.httpSender.Run(
+			ctx,
+			common.Endpoints,
+			common.Headers,
+			common.Callbacks,
+			&common.ClientSyncedState,
+			common.PackagesStateProvider,
+		)
+	}()
+
+	ticker := time.NewTicker(c.upgradeRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-httpDone:
+			return nil, err
+
+		case <-ticker.C:
+			conn, _, err := c.ws.connect(ctx)
+			if err != nil {
+				common.Logger.Debug("WebSocket upgrade is still not possible", types.F("error", err))
+				continue
+			}
+
+			// Let the HTTP sender complete the request in progress so that the
+			// message it is sending is not lost.
+			c.httpSender.RequestStop()
+			if err := <-httpDone; err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+			return conn, nil
+		}
+	}
+}
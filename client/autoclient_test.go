@@ -0,0 +1,39 @@
+package client
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsUpgradeRefused(t *testing.T) {
+	tests := []struct {
+		status  int
+		refused bool
+	}{
+		{status: http.StatusOK, refused: true},
+		{status: http.StatusNoContent, refused: true},
+		{status: http.StatusBadRequest, refused: true},
+		{status: http.StatusMethodNotAllowed, refused: true},
+		{status: http.StatusUpgradeRequired, refused: true},
+		{status: http.StatusSwitchingProtocols, refused: false},
+		{status: http.StatusFound, refused: false},
+		{status: http.StatusUnauthorized, refused: false},
+		{status: http.StatusForbidden, refused: false},
+		{status: http.StatusNotFound, refused: false},
+		{status: http.StatusProxyAuthRequired, refused: false},
+		{status: http.StatusRequestTimeout, refused: false},
+		{status: http.StatusTooManyRequests, refused: false},
+		{status: http.StatusInternalServerError, refused: false},
+		{status: http.StatusServiceUnavailable, refused: false},
+	}
+
+	for _, test := range tests {
+		t.Run(http.StatusText(test.status), func(t *testing.T) {
+			assert.Equal(t, test.refused, isUpgradeRefused(&http.Response{StatusCode: test.status}))
+		})
+	}
+
+	assert.False(t, isUpgradeRefused(nil))
+}
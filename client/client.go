@@ -58,6 +58,12 @@ type OpAMPClient interface {
 	// AgentDescription returns the last value successfully set by SetAgentDescription().
 	AgentDescription() *protobufs.AgentDescription
 
+	// ActiveEndpoint returns the Server endpoint that the client is currently
+	// connected to or is trying to connect to. See StartSettings.Endpoints for
+	// how the active endpoint is selected. Returns an empty Endpoint if the client
+	// is not started.
+	ActiveEndpoint() types.Endpoint
+
 	// UpdateEffectiveConfig fetches the current local effective config using
 	// GetEffectiveConfig callback and sends it to the Server.
 	// May be called anytime after Start(), including from OnMessage handler.
@@ -2,7 +2,10 @@ package client
 
 import (
 	"context"
+	"errors"
+	"io"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -10,6 +13,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
 	ulid "github.com/oklog/ulid/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -19,6 +23,9 @@ import (
 	"github.com/open-telemetry/opamp-go/client/types"
 	"github.com/open-telemetry/opamp-go/internal/testhelpers"
 	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/server"
+	servertypes "github.com/open-telemetry/opamp-go/server/types"
+	"github.com/open-telemetry/opamp-go/tracecontext"
 )
 
 const retryAfterHTTPHeader = "Retry-After"
@@ -79,15 +86,24 @@ func prepareSettings(t *testing.T, settings *types.StartSettings, c OpAMPClient)
 	settings.InstanceUid = ulid.MustNew(ulid.Timestamp(time.Now()), entropy).String()
 
 	// Make sure correct URL scheme is used, based on the type of the OpAMP client.
-	u, err := url.Parse(settings.OpAMPServerURL)
+	if settings.OpAMPServerURL != "" {
+		settings.OpAMPServerURL = fixURLScheme(t, settings.OpAMPServerURL, c)
+	}
+	for i := range settings.Endpoints {
+		settings.Endpoints[i].URL = fixURLScheme(t, settings.Endpoints[i].URL, c)
+	}
+}
+
+func fixURLScheme(t *testing.T, rawURL string, c OpAMPClient) string {
+	u, err := url.Parse(rawURL)
 	require.NoError(t, err)
 	switch c.(type) {
 	case *httpClient:
 		u.Scheme = "http"
-	case *wsClient:
+	case *wsClient, *autoClient:
 		u.Scheme = "ws"
 	}
-	settings.OpAMPServerURL = u.String()
+	return u.String()
 }
 
 func prepareClient(t *testing.T, settings *types.StartSettings, c OpAMPClient) {
@@ -136,6 +152,40 @@ func TestOnConnectFail(t *testing.T) {
 	})
 }
 
+func TestConnectMaxAttempts(t *testing.T) {
+	testClients(t, func(t *testing.T, client OpAMPClient) {
+		var attempts, terminalErrs int64
+		var terminalErr atomic.Value
+		settings := createNoServerSettings()
+		settings.BackoffPolicy = types.BackoffPolicy{
+			InitialInterval: time.Millisecond,
+			MaxAttempts:     3,
+		}
+		settings.Callbacks = types.CallbacksStruct{
+			OnConnectFailedFunc: func(err error) {
+				if errors.Is(err, types.ErrMaxAttemptsReached) {
+					atomic.AddInt64(&terminalErrs, 1)
+					terminalErr.Store(err)
+				} else {
+					atomic.AddInt64(&attempts, 1)
+				}
+			},
+		}
+
+		startClient(t, settings, client)
+
+		// Verify that the client gives up after the configured number of attempts
+		// and reports the last failed attempt only once, as the terminal error.
+		eventually(t, func() bool { return terminalErr.Load() != nil })
+		assert.EqualValues(t, 2, atomic.LoadInt64(&attempts))
+		assert.EqualValues(t, 1, atomic.LoadInt64(&terminalErrs))
+		assert.NotNil(t, errors.Unwrap(terminalErr.Load().(error)))
+
+		err := client.Stop(context.Background())
+		assert.NoError(t, err)
+	})
+}
+
 func TestStartStarted(t *testing.T) {
 	testClients(t, func(t *testing.T, client OpAMPClient) {
 		settings := createNoServerSettings()
@@ -294,6 +344,366 @@ func TestConnectWithHeader(t *testing.T) {
 	})
 }
 
+func TestConnectHeaderProviderRefresh(t *testing.T) {
+	testClients(t, func(t *testing.T, client OpAMPClient) {
+		// Start a server that accepts only fresh credentials.
+		srv := internal.StartMockServer(t)
+		var freshReceived int64
+		srv.OnRequest = func(w http.ResponseWriter, r *http.Request) {
+			assert.EqualValues(t, "static", r.Header.Get("X-Static"))
+			if r.Header.Get("Authorization") != "Bearer fresh" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			atomic.StoreInt64(&freshReceived, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		// Start a client with a provider that returns stale credentials unless
+		// forced to refresh.
+		settings := types.StartSettings{
+			OpAMPServerURL: "ws://" + srv.Endpoint,
+			Header:         http.Header{"X-Static": []string{"static"}},
+			HeaderProvider: types.HeaderProviderFunc(
+				func(ctx context.Context, forceRefresh bool) (http.Header, error) {
+					token := "stale"
+					if forceRefresh {
+						token = "fresh"
+					}
+					return http.Header{"Authorization": []string{"Bearer " + token}}, nil
+				},
+			),
+		}
+		startClient(t, settings, client)
+
+		// Verify that the client retries with refreshed credentials.
+		eventually(t, func() bool { return atomic.LoadInt64(&freshReceived) == 1 })
+
+		// Shutdown the Server and the client.
+		srv.Close()
+		_ = client.Stop(context.Background())
+	})
+}
+
+func TestConnectWithCustomDialer(t *testing.T) {
+	testClients(t, func(t *testing.T, client OpAMPClient) {
+		// Start a server.
+		srv := internal.StartMockServer(t)
+		var conn atomic.Value
+		srv.OnConnect = func(r *http.Request) {
+			conn.Store(true)
+		}
+
+		// Start a client with a URL that cannot be resolved and a dialer that
+		// redirects all connections to the Server.
+		var dialed int64
+		settings := types.StartSettings{
+			OpAMPServerURL: "ws://opamp.invalid:4320",
+			NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				atomic.StoreInt64(&dialed, 1)
+				return (&net.Dialer{}).DialContext(ctx, network, srv.Endpoint)
+			},
+		}
+		startClient(t, settings, client)
+
+		// Wait for connection to be established via the custom dialer.
+		eventually(t, func() bool { return conn.Load() != nil })
+		assert.EqualValues(t, 1, atomic.LoadInt64(&dialed))
+
+		// Shutdown the Server and the client.
+		srv.Close()
+		_ = client.Stop(context.Background())
+	})
+}
+
+// startTestProxy starts an HTTP proxy that supports CONNECT method and forwarding
+// of plain HTTP requests. Calls onRequest for every request received by the proxy.
+func startTestProxy(t *testing.T, onRequest func(r *http.Request)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		onRequest(r)
+
+		if r.Method != http.MethodConnect {
+			// Forward a plain HTTP request.
+			r.RequestURI = ""
+			resp, err := http.DefaultTransport.RoundTrip(r)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			defer resp.Body.Close()
+			for k, v := range resp.Header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(resp.StatusCode)
+			_, _ = io.Copy(w, resp.Body)
+			return
+		}
+
+		// Tunnel the connection.
+		destConn, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		srcConn, _, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		go func() {
+			_, _ = io.Copy(destConn, srcConn)
+			_ = destConn.Close()
+		}()
+		go func() {
+			_, _ = io.Copy(srcConn, destConn)
+			_ = srcConn.Close()
+		}()
+	}))
+}
+
+func TestConnectViaProxy(t *testing.T) {
+	testClients(t, func(t *testing.T, client OpAMPClient) {
+		// Start a server.
+		srv := internal.StartMockServer(t)
+		var conn atomic.Value
+		srv.OnConnect = func(r *http.Request) {
+			conn.Store(true)
+		}
+
+		// Start a proxy.
+		var proxyAuth atomic.Value
+		proxy := startTestProxy(t, func(r *http.Request) {
+			proxyAuth.Store(r.Header.Get("Proxy-Authorization"))
+		})
+		defer proxy.Close()
+
+		// Start a client that connects via the proxy.
+		settings := types.StartSettings{
+			OpAMPServerURL: "ws://" + srv.Endpoint,
+			Proxy: &types.ProxySettings{
+				URL:      proxy.URL,
+				Username: "user",
+				Password: "secret",
+			},
+		}
+		startClient(t, settings, client)
+
+		// Wait for connection to be established via the proxy.
+		eventually(t, func() bool { return conn.Load() != nil })
+		assert.EqualValues(t, "Basic dXNlcjpzZWNyZXQ=", proxyAuth.Load())
+
+		// Shutdown the Server and the client.
+		srv.Close()
+		_ = client.Stop(context.Background())
+	})
+}
+
+func TestConnectEndpointFailover(t *testing.T) {
+	testClients(t, func(t *testing.T, client OpAMPClient) {
+		// Start a server.
+		srv := internal.StartMockServer(t)
+		var conn atomic.Value
+		srv.OnConnect = func(r *http.Request) {
+			assert.EqualValues(t, "secondary", r.Header.Get("X-Endpoint"))
+			conn.Store(true)
+		}
+
+		// Start a client with the primary endpoint pointing to a non-existing Server.
+		settings := types.StartSettings{
+			Endpoints: []types.Endpoint{
+				{
+					URL:    "ws://" + testhelpers.GetAvailableLocalAddress(),
+					Header: http.Header{"X-Endpoint": []string{"primary"}},
+				},
+				{
+					URL:    "ws://" + srv.Endpoint,
+					Header: http.Header{"X-Endpoint": []string{"secondary"}},
+				},
+			},
+			BackoffPolicy: types.BackoffPolicy{InitialInterval: time.Millisecond},
+		}
+		startClient(t, settings, client)
+
+		// Wait for connection to be established to the secondary endpoint.
+		eventually(t, func() bool { return conn.Load() != nil })
+		assert.EqualValues(t, settings.Endpoints[1].URL, client.ActiveEndpoint().URL)
+
+		// Shutdown the Server and the client.
+		srv.Close()
+		_ = client.Stop(context.Background())
+	})
+}
+
+func TestAutoTransportFallback(t *testing.T) {
+	// Start a server that refuses WebSocket upgrades.
+	srv := internal.StartMockServer(t)
+	srv.SetRejectWebSocket(true)
+
+	var httpRequests, wsConnections int64
+	srv.OnConnect = func(r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			atomic.AddInt64(&httpRequests, 1)
+		}
+	}
+	srv.OnWSConnect = func(conn *websocket.Conn) {
+		atomic.AddInt64(&wsConnections, 1)
+	}
+	var rcvDescr atomic.Value
+	srv.OnMessage = func(msg *protobufs.AgentToServer) *protobufs.ServerToAgent {
+		if msg.AgentDescription != nil {
+			rcvDescr.Store(msg.AgentDescription)
+		}
+		return nil
+	}
+
+	// Start a client.
+	client := NewAuto(nil)
+	settings := types.StartSettings{
+		OpAMPServerURL: "ws://" + srv.Endpoint,
+		AutoTransport: types.AutoTransportSettings{
+			UpgradeRetryInterval: 100 * time.Millisecond,
+		},
+	}
+	startClient(t, settings, client)
+
+	// The client must fall back to plain HTTP and send the first status report.
+	eventually(t, func() bool { return atomic.LoadInt64(&httpRequests) != 0 })
+	eventually(t, func() bool { return rcvDescr.Load() != nil })
+	assert.EqualValues(t, 0, atomic.LoadInt64(&wsConnections))
+
+	// Allow WebSocket upgrades. The client must upgrade and continue sending
+	// the messages over WebSocket.
+	srv.SetRejectWebSocket(false)
+	eventually(t, func() bool { return atomic.LoadInt64(&wsConnections) != 0 })
+
+	descr := createAgentDescr()
+	descr.NonIdentifyingAttributes = []*protobufs.KeyValue{
+		{
+			Key:   "os.type",
+			Value: &protobufs.AnyValue{Value: &protobufs.AnyValue_StringValue{StringValue: "linux"}},
+		},
+	}
+	assert.NoError(t, client.SetAgentDescription(descr))
+	eventually(t, func() bool {
+		return proto.Equal(descr, rcvDescr.Load().(*protobufs.AgentDescription))
+	})
+
+	// Shutdown the Server and the client.
+	srv.Close()
+	_ = client.Stop(context.Background())
+}
+
+func TestAutoTransportUpgradeHeaderProviderRefresh(t *testing.T) {
+	// Start a server that first refuses WebSocket upgrades and then accepts them
+	// with fresh credentials only.
+	var acceptWebSocket, wsConnections int64
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			w.Header().Set("Content-Type", "application/x-protobuf")
+			return
+		}
+		if atomic.LoadInt64(&acceptWebSocket) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		atomic.AddInt64(&wsConnections, 1)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	// Start a client with a provider that returns stale credentials unless
+	// forced to refresh.
+	var connected int64
+	client := NewAuto(nil)
+	settings := types.StartSettings{
+		OpAMPServerURL: "ws" + srv.URL[len("http"):],
+		HeaderProvider: types.HeaderProviderFunc(
+			func(ctx context.Context, forceRefresh bool) (http.Header, error) {
+				token := "stale"
+				if forceRefresh {
+					token = "fresh"
+				}
+				return http.Header{"Authorization": []string{"Bearer " + token}}, nil
+			},
+		),
+		Callbacks: types.CallbacksStruct{
+			OnConnectFunc: func() { atomic.AddInt64(&connected, 1) },
+		},
+		AutoTransport: types.AutoTransportSettings{
+			UpgradeRetryInterval: 10 * time.Millisecond,
+		},
+	}
+	startClient(t, settings, client)
+
+	// The client must fall back to plain HTTP and then upgrade to WebSocket with
+	// refreshed credentials.
+	eventually(t, func() bool { return atomic.LoadInt64(&connected) != 0 })
+	atomic.StoreInt64(&acceptWebSocket, 1)
+	eventually(t, func() bool { return atomic.LoadInt64(&wsConnections) != 0 })
+
+	_ = client.Stop(context.Background())
+}
+
+func TestConnectServerValidation(t *testing.T) {
+	testClients(t, func(t *testing.T, client OpAMPClient) {
+		// Start a Server that validates the messages and offers a remote config in
+		// response to the messages that pass the validation.
+		remoteConfig := createRemoteConfig()
+		srv := server.New(nil)
+		serverSettings := server.StartSettings{
+			Settings: server.Settings{
+				Callbacks: server.CallbacksStruct{
+					OnMessageFunc: func(
+						conn servertypes.Connection, message *protobufs.AgentToServer,
+					) *protobufs.ServerToAgent {
+						return &protobufs.ServerToAgent{InstanceUid: message.InstanceUid, RemoteConfig: remoteConfig}
+					},
+				},
+				Validation: &server.Validation{},
+			},
+			ListenEndpoint: testhelpers.GetAvailableLocalAddress(),
+		}
+		require.NoError(t, srv.Start(serverSettings))
+		defer srv.Stop(context.Background())
+
+		// Start a client that reports the package statuses, so that it declares
+		// the capabilities.
+		var rcvConfig atomic.Value
+		settings := types.StartSettings{
+			OpAMPServerURL:        "ws://" + serverSettings.ListenEndpoint + "/v1/opamp",
+			PackagesStateProvider: internal.NewInMemPackagesStore(),
+			Callbacks: types.CallbacksStruct{
+				OnMessageFunc: func(ctx context.Context, msg *types.MessageData) {
+					if msg.RemoteConfig != nil {
+						rcvConfig.Store(msg.RemoteConfig)
+					}
+				},
+			},
+		}
+		startClient(t, settings, client)
+
+		// The first status report of the client must pass the validation, so the
+		// client receives the response of OnMessage rather than an error response.
+		eventually(t, func() bool { return rcvConfig.Load() != nil })
+		assert.True(t, proto.Equal(remoteConfig, rcvConfig.Load().(*protobufs.AgentRemoteConfig)))
+		assert.EqualValues(t, 0, srv.Stats().RejectedInvalid)
+
+		_ = client.Stop(context.Background())
+	})
+}
+
 func createRemoteConfig() *protobufs.AgentRemoteConfig {
 	return &protobufs.AgentRemoteConfig{
 		Config: &protobufs.AgentConfigMap{
@@ -1197,3 +1607,146 @@ func TestUpdatePackages(t *testing.T) {
 		})
 	}
 }
+
+func TestInterceptors(t *testing.T) {
+	testClients(t, func(t *testing.T, client OpAMPClient) {
+		// Start a Server that pushes a remote config.
+		srv := internal.StartMockServer(t)
+		var rcvIntercepted int64
+		srv.OnMessage = func(msg *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			if msg.Flags == protobufs.AgentToServer_RequestInstanceUid {
+				atomic.AddInt64(&rcvIntercepted, 1)
+			}
+			return &protobufs.ServerToAgent{
+				InstanceUid:  msg.InstanceUid,
+				RemoteConfig: createRemoteConfig(),
+			}
+		}
+
+		// Start a client with interceptors that modify the sent messages and drop
+		// the received messages.
+		var sentCount, receivedCount, onMessageCount int64
+		settings := types.StartSettings{
+			Callbacks: types.CallbacksStruct{
+				OnMessageFunc: func(ctx context.Context, msg *types.MessageData) {
+					atomic.AddInt64(&onMessageCount, 1)
+				},
+			},
+			Interceptors: []types.Interceptor{
+				{
+					OnSend: func(ctx context.Context, msg *protobufs.AgentToServer) error {
+						atomic.AddInt64(&sentCount, 1)
+						msg.Flags = protobufs.AgentToServer_RequestInstanceUid
+						return nil
+					},
+					OnReceive: func(ctx context.Context, msg *protobufs.ServerToAgent) error {
+						atomic.AddInt64(&receivedCount, 1)
+						return nil
+					},
+				},
+				{
+					OnReceive: func(ctx context.Context, msg *protobufs.ServerToAgent) error {
+						return errors.New("injected fault")
+					},
+				},
+			},
+		}
+		settings.OpAMPServerURL = "ws://" + srv.Endpoint
+		prepareClient(t, &settings, client)
+		assert.NoError(t, client.Start(context.Background(), settings))
+
+		eventually(t, func() bool { return atomic.LoadInt64(&rcvIntercepted) == 1 })
+		eventually(t, func() bool { return atomic.LoadInt64(&receivedCount) == 1 })
+		assert.EqualValues(t, 1, atomic.LoadInt64(&sentCount))
+		assert.EqualValues(t, 0, atomic.LoadInt64(&onMessageCount))
+
+		// Shutdown the Server and the client.
+		srv.Close()
+		err := client.Stop(context.Background())
+		assert.NoError(t, err)
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	testClients(t, func(t *testing.T, client OpAMPClient) {
+		// Start a Server.
+		srv := internal.StartMockServer(t)
+		srv.OnMessage = func(msg *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			return &protobufs.ServerToAgent{InstanceUid: msg.InstanceUid}
+		}
+
+		// Start a client that reports the telemetry to the meter.
+		meter := testhelpers.NewTestMeter()
+		settings := types.StartSettings{Meter: meter}
+		settings.OpAMPServerURL = "ws://" + srv.Endpoint
+		prepareClient(t, &settings, client)
+		assert.NoError(t, client.Start(context.Background(), settings))
+
+		eventually(t, func() bool { return meter.Sum("opamp.client.messages.received") >= 1 })
+		assert.GreaterOrEqual(t, meter.Sum("opamp.client.messages.sent"), 1.0)
+		assert.GreaterOrEqual(t, meter.Sum("opamp.client.connection.attempts"), 1.0)
+		assert.EqualValues(t, 0, meter.Sum("opamp.client.connection.failures"))
+		assert.EqualValues(t, 0, meter.Sum("opamp.client.reconnects"))
+		assert.GreaterOrEqual(t, meter.Count("opamp.client.send.duration"), 1)
+		assert.GreaterOrEqual(t, meter.Count("opamp.client.message.field.size", internal.AttrDirectionSent), 1)
+		assert.GreaterOrEqual(t, meter.Count("opamp.client.message.field.size", internal.AttrDirectionReceived), 1)
+
+		// Shutdown the Server and the client.
+		srv.Close()
+		err := client.Stop(context.Background())
+		assert.NoError(t, err)
+	})
+}
+
+func TestTraceContext(t *testing.T) {
+	testClients(t, func(t *testing.T, client OpAMPClient) {
+		traceContext := tracecontext.TraceContext{
+			TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			TraceState:  "congo=t61rcWkgMzE",
+		}
+
+		// Start a Server that records the received trace context.
+		srv := internal.StartMockServer(t)
+		var received atomic.Value
+		srv.OnConnect = func(r *http.Request) {
+			received.Store(tracecontext.Extract(r.Header))
+		}
+		srv.OnMessage = func(msg *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			return &protobufs.ServerToAgent{InstanceUid: msg.InstanceUid, RemoteConfig: createRemoteConfig()}
+		}
+
+		// Start a client that sends the trace context and starts the spans.
+		type spanKey struct{}
+		var spansStarted, spansEnded, spanCallbacks int64
+		settings := types.StartSettings{
+			TraceContext: func(ctx context.Context) tracecontext.TraceContext {
+				return traceContext
+			},
+			StartSpan: func(ctx context.Context, name string, parent tracecontext.TraceContext) (context.Context, func()) {
+				atomic.AddInt64(&spansStarted, 1)
+				return context.WithValue(ctx, spanKey{}, name), func() { atomic.AddInt64(&spansEnded, 1) }
+			},
+			Callbacks: types.CallbacksStruct{
+				OnMessageFunc: func(ctx context.Context, msg *types.MessageData) {
+					if ctx.Value(spanKey{}) == "opamp.client.message" {
+						atomic.AddInt64(&spanCallbacks, 1)
+					}
+				},
+			},
+		}
+		settings.OpAMPServerURL = "ws://" + srv.Endpoint
+		prepareClient(t, &settings, client)
+		assert.NoError(t, client.Start(context.Background(), settings))
+
+		// The Server receives the trace context and the callbacks receive the
+		// context of the span.
+		eventually(t, func() bool { return atomic.LoadInt64(&spanCallbacks) >= 1 })
+		assert.EqualValues(t, traceContext, received.Load())
+		eventually(t, func() bool { return atomic.LoadInt64(&spansEnded) == atomic.LoadInt64(&spansStarted) })
+
+		// Shutdown the Server and the client.
+		srv.Close()
+		err := client.Stop(context.Background())
+		assert.NoError(t, err)
+	})
+}
@@ -14,20 +14,15 @@ import (
 type httpClient struct {
 	common internal.ClientCommon
 
-	opAMPServerURL string
-
 	// The sender performs HTTP request/response loop.
 	sender *internal.HTTPSender
 }
 
 func NewHTTP(logger types.Logger) *httpClient {
-	if logger == nil {
-		logger = &sharedinternal.NopLogger{}
-	}
-
-	sender := internal.NewHTTPSender(logger)
+	internalLogger := sharedinternal.NewLogger(logger)
+	sender := internal.NewHTTPSender(internalLogger)
 	w := &httpClient{
-		common: internal.NewClientCommon(logger, sender),
+		common: internal.NewClientCommon(internalLogger, sender),
 		sender: sender,
 	}
 	return w
@@ -38,10 +33,10 @@ func (c *httpClient) Start(ctx context.Context, settings types.StartSettings) er
 		return err
 	}
 
-	c.opAMPServerURL = settings.OpAMPServerURL
-
 	// Prepare Server connection settings.
-	c.sender.SetRequestHeader(settings.Header)
+	c.sender.SetBackoffPolicy(settings.BackoffPolicy)
+	c.sender.SetLongPollTimeout(settings.LongPollTimeout)
+	c.sender.SetNetworkSettings(c.common.Network)
 
 	// Prepare the first message to send.
 	err := c.common.PrepareFirstMessage(ctx)
@@ -52,7 +47,7 @@ func (c *httpClient) Start(ctx context.Context, settings types.StartSettings) er
 	c.sender.ScheduleSend()
 
 	c.common.StartConnectAndRun(c.runUntilStopped)
-	c.common.Logger.Debugf("Starting OpAMP HTTP client...")
+	c.common.Logger.Debug("Starting OpAMP HTTP client...")
 
 	return nil
 }
@@ -65,6 +60,10 @@ func (c *httpClient) AgentDescription() *protobufs.AgentDescription {
 	return c.common.AgentDescription()
 }
 
+func (c *httpClient) ActiveEndpoint() types.Endpoint {
+	return c.common.ActiveEndpoint()
+}
+
 func (c *httpClient) SetAgentDescription(descr *protobufs.AgentDescription) error {
 	return c.common.SetAgentDescription(descr)
 }
@@ -85,9 +84,10 @@ func (c *httpClient) runUntilStopped(ctx context.Context) {
 	// Start the HTTP sender. This will make request/responses with retries for
 	// failures and will wait with configured polling interval if there is nothing
 	// to send.
-	c.sender.Run(
+	_ = c.sender.Run(
 		ctx,
-		c.opAMPServerURL,
+		c.common.Endpoints,
+		c.common.Headers,
 		c.common.Callbacks,
 		&c.common.ClientSyncedState,
 		c.common.PackagesStateProvider,
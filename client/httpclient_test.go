@@ -2,14 +2,22 @@ package client
 
 import (
 	"context"
+	"io"
+	"net/http"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/open-telemetry/opamp-go/client/internal"
 	"github.com/open-telemetry/opamp-go/client/types"
+	sharedinternal "github.com/open-telemetry/opamp-go/internal"
+	"github.com/open-telemetry/opamp-go/internal/testhelpers"
 	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/server"
+	servertypes "github.com/open-telemetry/opamp-go/server/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestHTTPPolling(t *testing.T) {
@@ -47,3 +55,170 @@ func TestHTTPPolling(t *testing.T) {
 	err := client.Stop(context.Background())
 	assert.NoError(t, err)
 }
+
+func TestHTTPLongPolling(t *testing.T) {
+	// Start a Server that holds the long-poll requests until there is a remote
+	// config to push.
+	srv := internal.StartMockServer(t)
+	pushConfig := make(chan *protobufs.AgentRemoteConfig)
+	var longPollRequests int64
+	srv.OnRequest = func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		var msg protobufs.AgentToServer
+		require.NoError(t, proto.Unmarshal(body, &msg))
+
+		response := &protobufs.ServerToAgent{InstanceUid: msg.InstanceUid}
+		if sharedinternal.ExtractLongPollTimeoutHeader(r) > 0 {
+			atomic.AddInt64(&longPollRequests, 1)
+			select {
+			case response.RemoteConfig = <-pushConfig:
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		body, err = proto.Marshal(response)
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		_, _ = w.Write(body)
+	}
+
+	// Start a client.
+	var rcvConfig atomic.Value
+	settings := types.StartSettings{
+		OpAMPServerURL:  "http://" + srv.Endpoint,
+		LongPollTimeout: time.Minute,
+		Callbacks: types.CallbacksStruct{
+			OnMessageFunc: func(ctx context.Context, msg *types.MessageData) {
+				if msg.RemoteConfig != nil {
+					rcvConfig.Store(msg.RemoteConfig)
+				}
+			},
+		},
+	}
+	client := NewHTTP(nil)
+	startClient(t, settings, client)
+
+	// Wait until the long-poll request is held by the Server.
+	eventually(t, func() bool { return atomic.LoadInt64(&longPollRequests) == 1 })
+
+	// Push a remote config. The client must receive it without waiting for the
+	// next poll and must issue a new long-poll request.
+	remoteConfig := createRemoteConfig()
+	pushConfig <- remoteConfig
+	eventually(t, func() bool { return rcvConfig.Load() != nil })
+	assert.True(t, proto.Equal(remoteConfig, rcvConfig.Load().(*protobufs.AgentRemoteConfig)))
+	eventually(t, func() bool { return atomic.LoadInt64(&longPollRequests) == 2 })
+
+	// Shutdown the client and the Server.
+	err := client.Stop(context.Background())
+	assert.NoError(t, err)
+	srv.Close()
+}
+
+func TestHTTPLongPollingServer(t *testing.T) {
+	// Start a Server that counts the status reports.
+	var rcvMessages int64
+	srv := server.New(nil)
+	serverSettings := server.StartSettings{
+		Settings: server.Settings{
+			Callbacks: server.CallbacksStruct{
+				OnMessageFunc: func(
+					conn servertypes.Connection, message *protobufs.AgentToServer,
+				) *protobufs.ServerToAgent {
+					atomic.AddInt64(&rcvMessages, 1)
+					return &protobufs.ServerToAgent{InstanceUid: message.InstanceUid}
+				},
+			},
+		},
+		ListenEndpoint: testhelpers.GetAvailableLocalAddress(),
+	}
+	require.NoError(t, srv.Start(serverSettings))
+	defer srv.Stop(context.Background())
+
+	// Start a client.
+	var rcvConfig atomic.Value
+	settings := types.StartSettings{
+		OpAMPServerURL:  "http://" + serverSettings.ListenEndpoint + "/v1/opamp",
+		LongPollTimeout: time.Minute,
+		Callbacks: types.CallbacksStruct{
+			OnMessageFunc: func(ctx context.Context, msg *types.MessageData) {
+				if msg.RemoteConfig != nil {
+					rcvConfig.Store(msg.RemoteConfig)
+				}
+			},
+		},
+	}
+	client := NewHTTP(nil)
+	prepareClient(t, &settings, client)
+	assert.NoError(t, client.Start(context.Background(), settings))
+	eventually(t, func() bool { return atomic.LoadInt64(&rcvMessages) == 1 })
+
+	// The enqueued remote config is delivered in response to the long-poll request.
+	remoteConfig := createRemoteConfig()
+	require.NoError(t, srv.Enqueue(settings.InstanceUid, &protobufs.ServerToAgent{RemoteConfig: remoteConfig}))
+	eventually(t, func() bool { return rcvConfig.Load() != nil })
+	assert.True(t, proto.Equal(remoteConfig, rcvConfig.Load().(*protobufs.AgentRemoteConfig)))
+
+	// The long-poll requests are not status reports, only the first regular
+	// request is passed to OnMessage.
+	assert.EqualValues(t, 1, atomic.LoadInt64(&rcvMessages))
+
+	err := client.Stop(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestHTTPLongPollingGiveUp(t *testing.T) {
+	// Start a Server that fails the long-poll requests and responds to the
+	// regular requests.
+	srv := internal.StartMockServer(t)
+	var longPollRequests, regularRequests int64
+	srv.OnRequest = func(w http.ResponseWriter, r *http.Request) {
+		if sharedinternal.ExtractLongPollTimeoutHeader(r) > 0 {
+			atomic.AddInt64(&longPollRequests, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		atomic.AddInt64(&regularRequests, 1)
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		var msg protobufs.AgentToServer
+		require.NoError(t, proto.Unmarshal(body, &msg))
+		body, err = proto.Marshal(&protobufs.ServerToAgent{InstanceUid: msg.InstanceUid})
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		_, _ = w.Write(body)
+	}
+
+	// Start a client that gives up after 2 attempts.
+	var connectErr atomic.Value
+	settings := types.StartSettings{
+		OpAMPServerURL:  "http://" + srv.Endpoint,
+		LongPollTimeout: time.Minute,
+		BackoffPolicy:   types.BackoffPolicy{InitialInterval: time.Millisecond, MaxAttempts: 2},
+		Callbacks: types.CallbacksStruct{
+			OnConnectFailedFunc: func(err error) {
+				connectErr.Store(err)
+			},
+		},
+	}
+	client := NewHTTP(nil)
+	prepareClient(t, &settings, client)
+	client.sender.SetPollingInterval(time.Millisecond * 10)
+	assert.NoError(t, client.Start(context.Background(), settings))
+
+	// Giving up long-polling is reported, but the client keeps polling.
+	eventually(t, func() bool { return connectErr.Load() != nil })
+	assert.NotErrorIs(t, connectErr.Load().(error), types.ErrMaxAttemptsReached)
+	assert.EqualValues(t, 2, atomic.LoadInt64(&longPollRequests))
+
+	polled := atomic.LoadInt64(&regularRequests)
+	eventually(t, func() bool { return atomic.LoadInt64(&regularRequests) > polled+1 })
+	assert.EqualValues(t, 2, atomic.LoadInt64(&longPollRequests))
+
+	err := client.Stop(context.Background())
+	assert.NoError(t, err)
+	srv.Close()
+}
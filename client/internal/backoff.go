@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+
+	"github.com/open-telemetry/opamp-go/client/types"
+)
+
+// NewBackOff creates a backoff.BackOff that follows the specified policy.
+// NextBackOff() is expected to be called after every failed attempt and returns
+// backoff.Stop when the policy's MaxAttempts is reached.
+// The returned BackOff is not safe for concurrent use.
+func NewBackOff(policy types.BackoffPolicy) backoff.BackOff {
+	expBackoff := backoff.NewExponentialBackOff()
+
+	// Never stop based on elapsed time, only MaxAttempts can stop the backoff.
+	expBackoff.MaxElapsedTime = 0
+
+	if policy.InitialInterval > 0 {
+		expBackoff.InitialInterval = policy.InitialInterval
+	}
+	if policy.MaxInterval > 0 {
+		expBackoff.MaxInterval = policy.MaxInterval
+	}
+	if policy.Multiplier > 0 {
+		expBackoff.Multiplier = policy.Multiplier
+	}
+
+	var b backoff.BackOff = expBackoff
+	if policy.FullJitter {
+		// The jitter is applied by the wrapper, so disable the built-in randomization.
+		expBackoff.RandomizationFactor = 0
+		b = &fullJitterBackOff{
+			BackOff: expBackoff,
+			rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		}
+	}
+	expBackoff.Reset()
+
+	if policy.MaxAttempts > 0 {
+		// NextBackOff is called after each failed attempt, so the call that follows
+		// the last allowed attempt must return Stop.
+		b = backoff.WithMaxRetries(b, uint64(policy.MaxAttempts-1))
+	}
+	return b
+}
+
+// fullJitterBackOff returns a random duration in [0, interval), where interval
+// is calculated by the wrapped BackOff.
+type fullJitterBackOff struct {
+	backoff.BackOff
+	rand *rand.Rand
+}
+
+func (b *fullJitterBackOff) NextBackOff() time.Duration {
+	interval := b.BackOff.NextBackOff()
+	if interval == backoff.Stop || interval <= 0 {
+		return interval
+	}
+	return time.Duration(b.rand.Int63n(int64(interval)))
+}
+
+// MaxAttemptsReachedError returns the error that is reported when the client gives
+// up after the last attempt failed with err. The returned error matches
+// types.ErrMaxAttemptsReached and wraps err, which may be nil.
+func MaxAttemptsReachedError(err error) error {
+	if err == nil {
+		return types.ErrMaxAttemptsReached
+	}
+	return &maxAttemptsReachedError{err: err}
+}
+
+type maxAttemptsReachedError struct {
+	err error
+}
+
+func (e *maxAttemptsReachedError) Error() string {
+	return types.ErrMaxAttemptsReached.Error() + ": " + e.err.Error()
+}
+
+func (e *maxAttemptsReachedError) Is(target error) bool {
+	return target == types.ErrMaxAttemptsReached
+}
+
+func (e *maxAttemptsReachedError) Unwrap() error {
+	return e.err
+}
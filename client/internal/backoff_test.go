@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/open-telemetry/opamp-go/client/types"
+)
+
+func TestBackOffFullJitter(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	b := NewBackOff(
+		types.BackoffPolicy{
+			InitialInterval: interval,
+			MaxInterval:     interval,
+			FullJitter:      true,
+		},
+	)
+
+	var min, max time.Duration = interval, 0
+	for i := 0; i < 1000; i++ {
+		d := b.NextBackOff()
+		assert.GreaterOrEqual(t, d, time.Duration(0))
+		assert.Less(t, d, interval)
+		if d < min {
+			min = d
+		}
+		if d > max {
+			max = d
+		}
+	}
+
+	// The draws must cover the whole range, not just the +/-50% around the interval.
+	assert.Less(t, min, interval/4)
+	assert.Greater(t, max, interval*3/4)
+}
+
+func TestBackOffMaxAttempts(t *testing.T) {
+	b := NewBackOff(types.BackoffPolicy{InitialInterval: time.Millisecond, MaxAttempts: 3})
+
+	assert.NotEqual(t, backoff.Stop, b.NextBackOff())
+	assert.NotEqual(t, backoff.Stop, b.NextBackOff())
+	assert.Equal(t, backoff.Stop, b.NextBackOff())
+
+	b.Reset()
+	assert.NotEqual(t, backoff.Stop, b.NextBackOff())
+}
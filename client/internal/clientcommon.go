@@ -10,6 +10,7 @@ import (
 	"sync"
 
 	"github.com/open-telemetry/opamp-go/client/types"
+	sharedinternal "github.com/open-telemetry/opamp-go/internal"
 	"github.com/open-telemetry/opamp-go/protobufs"
 	"google.golang.org/protobuf/proto"
 )
@@ -25,7 +26,7 @@ var (
 // ClientCommon contains the OpAMP logic that is common between WebSocket and
 // plain HTTP transports.
 type ClientCommon struct {
-	Logger    types.Logger
+	Logger    *sharedinternal.Logger
 	Callbacks types.Callbacks
 
 	// Client state storage. This is needed if the Server asks to report the state.
@@ -34,6 +35,18 @@ type ClientCommon struct {
 	// PackagesStateProvider provides access to the local state of packages.
 	PackagesStateProvider types.PackagesStateProvider
 
+	// The Server endpoints to connect to.
+	Endpoints *Endpoints
+
+	// Builder of the HTTP headers to use for the requests to the Server.
+	Headers *HeaderBuilder
+
+	// Defines how the network connections are established.
+	Network NetworkSettings
+
+	// Instruments that report the telemetry of the client.
+	Metrics *Metrics
+
 	// The transport-specific sender.
 	sender Sender
 
@@ -51,9 +64,9 @@ type ClientCommon struct {
 	stoppedSignal chan struct{}
 }
 
-func NewClientCommon(logger types.Logger, sender Sender) ClientCommon {
+func NewClientCommon(logger *sharedinternal.Logger, sender Sender) ClientCommon {
 	return ClientCommon{
-		Logger: logger, sender: sender, stoppedSignal: make(chan struct{}, 1),
+		Logger: logger, sender: sender, Metrics: NewMetrics(nil), stoppedSignal: make(chan struct{}, 1),
 	}
 }
 
@@ -68,6 +81,19 @@ func (c *ClientCommon) PrepareStart(
 		return ErrAgentDescriptionMissing
 	}
 
+	// Prepare the Server endpoints.
+	endpoints, err := NewEndpoints(settings)
+	if err != nil {
+		return err
+	}
+	c.Endpoints = endpoints
+	c.Headers = NewHeaderBuilder(settings.HeaderProvider, settings.TraceContext)
+
+	// Prepare the network settings.
+	if c.Network, err = NewNetworkSettings(settings); err != nil {
+		return err
+	}
+
 	// Prepare remote config status.
 	if settings.RemoteConfigStatus == nil {
 		// RemoteConfigStatus is not provided. Start with empty.
@@ -107,6 +133,11 @@ func (c *ClientCommon) PrepareStart(
 		c.Callbacks = types.CallbacksStruct{}
 	}
 
+	c.sender.SetInterceptors(settings.Interceptors)
+	c.Metrics = NewMetrics(settings.Meter)
+	c.sender.SetMetrics(c.Metrics)
+	c.sender.SetStartSpan(settings.StartSpan)
+
 	if err := c.sender.SetInstanceUid(settings.InstanceUid); err != nil {
 		return err
 	}
@@ -196,6 +227,17 @@ func (c *ClientCommon) PrepareFirstMessage(ctx context.Context) error {
 	return nil
 }
 
+// ActiveEndpoint returns the Server endpoint that the client is connected to or
+// is trying to connect to.
+func (c *ClientCommon) ActiveEndpoint() types.Endpoint {
+	if c.Endpoints == nil {
+		// Not started yet.
+		return types.Endpoint{}
+	}
+	endpoint, _, _ := c.Endpoints.Active()
+	return endpoint
+}
+
 // AgentDescription returns the current state of the AgentDescription.
 func (c *ClientCommon) AgentDescription() *protobufs.AgentDescription {
 	// Return a cloned copy to allow caller to do whatever they want with the result.
@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/open-telemetry/opamp-go/client/types"
+)
+
+const defaultPrimaryProbeInterval = 5 * time.Minute
+
+// The URL scheme of endpoints that are reachable via a Unix domain socket, e.g.
+// "unix:///run/opamp.sock". The URL path is the path of the socket file. The
+// OpAMP requests are sent to defaultUnixRequestPath, unless a different path is
+// specified in the "path" query parameter, e.g. "unix:///run/opamp.sock?path=/opamp".
+const unixScheme = "unix"
+const defaultUnixRequestPath = "/v1/opamp"
+
+var (
+	errNoEndpoints       = errors.New("OpAMPServerURL or Endpoints must be set")
+	errAmbiguousEndpoint = errors.New("OpAMPServerURL and Endpoints cannot be both set")
+)
+
+// Endpoints keeps the ordered list of the Server endpoints and tracks which of them
+// is active, i.e. which endpoint the client is connected to or is trying to connect to.
+// The first endpoint in the list is the primary.
+//
+// It is safe to call methods of this struct concurrently.
+type Endpoints struct {
+	mutex sync.RWMutex
+
+	// The list of the endpoints. Header and TLSConfig are already merged with the
+	// values from the StartSettings.
+	list []types.Endpoint
+	// Parsed URLs of the endpoints in the list. For Unix domain socket endpoints
+	// these are the URLs of the requests sent over the socket.
+	urls []*url.URL
+	// Paths of the Unix domain sockets of the endpoints in the list. Empty for
+	// endpoints that are not reachable via a Unix domain socket.
+	unixSockets []string
+
+	// Index of the active endpoint in the list and the time when it became active.
+	active      int
+	activeSince time.Time
+
+	primaryProbeInterval time.Duration
+}
+
+// NewEndpoints creates the list of endpoints from the settings. Returns an error
+// if no endpoints are defined or if any of the URLs is invalid.
+func NewEndpoints(settings types.StartSettings) (*Endpoints, error) {
+	endpoints := settings.Endpoints
+	if len(endpoints) == 0 {
+		if settings.OpAMPServerURL == "" {
+			return nil, errNoEndpoints
+		}
+		endpoints = []types.Endpoint{{URL: settings.OpAMPServerURL}}
+	} else if settings.OpAMPServerURL != "" {
+		return nil, errAmbiguousEndpoint
+	}
+
+	e := &Endpoints{
+		list:                 make([]types.Endpoint, len(endpoints)),
+		urls:                 make([]*url.URL, len(endpoints)),
+		unixSockets:          make([]string, len(endpoints)),
+		activeSince:          time.Now(),
+		primaryProbeInterval: settings.PrimaryProbeInterval,
+	}
+	if e.primaryProbeInterval == 0 {
+		e.primaryProbeInterval = defaultPrimaryProbeInterval
+	}
+
+	for i, endpoint := range endpoints {
+		u, err := url.Parse(endpoint.URL)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme == unixScheme {
+			if u.Path == "" {
+				return nil, fmt.Errorf("socket path is not specified in %q", endpoint.URL)
+			}
+			e.unixSockets[i] = u.Path
+
+			requestPath := u.Query().Get("path")
+			if requestPath == "" {
+				requestPath = defaultUnixRequestPath
+			}
+			u = &url.URL{Scheme: "http", Host: "localhost", Path: requestPath}
+		}
+		e.urls[i] = u
+
+		// Endpoint-specific header values are added on top of the common ones.
+		header := http.Header{}
+		for k, v := range settings.Header {
+			header[k] = v
+		}
+		for k, v := range endpoint.Header {
+			header[k] = v
+		}
+		endpoint.Header = header
+
+		if endpoint.TLSConfig == nil {
+			endpoint.TLSConfig = settings.TLSConfig
+		}
+		e.list[i] = endpoint
+	}
+
+	return e, nil
+}
+
+// Active returns the active endpoint, its parsed URL and its index in the list.
+// The returned values must not be modified.
+func (e *Endpoints) Active() (types.Endpoint, *url.URL, int) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.list[e.active], e.urls[e.active], e.active
+}
+
+// All returns all endpoints in the order of preference.
+// The returned values must not be modified.
+func (e *Endpoints) All() []types.Endpoint {
+	return e.list
+}
+
+// UnixSocket returns the path of the Unix domain socket of the endpoint at the
+// specified index or an empty string if the endpoint is not reachable via a
+// Unix domain socket.
+func (e *Endpoints) UnixSocket(index int) string {
+	return e.unixSockets[index]
+}
+
+// At returns the endpoint at the specified index and its parsed URL. The endpoint
+// at index 0 is the primary.
+// The returned values must not be modified.
+func (e *Endpoints) At(index int) (types.Endpoint, *url.URL) {
+	return e.list[index], e.urls[index]
+}
+
+// Rotate makes the next endpoint in the list active. Typically called after
+// connection to the active endpoint fails.
+func (e *Endpoints) Rotate() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if len(e.list) > 1 {
+		e.active = (e.active + 1) % len(e.list)
+		e.activeSince = time.Now()
+	}
+}
+
+// SelectPrimary makes the primary endpoint active.
+func (e *Endpoints) SelectPrimary() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.active != 0 {
+		e.active = 0
+		e.activeSince = time.Now()
+	}
+}
+
+// PrimaryProbeInterval returns how often the primary endpoint should be probed
+// while a non-primary endpoint is active. Returns 0 if probing is disabled.
+func (e *Endpoints) PrimaryProbeInterval() time.Duration {
+	if e.primaryProbeInterval < 0 {
+		return 0
+	}
+	return e.primaryProbeInterval
+}
+
+// IsPrimaryProbeDue returns true if a non-primary endpoint is active for longer
+// than the primary probe interval.
+func (e *Endpoints) IsPrimaryProbeDue() bool {
+	interval := e.PrimaryProbeInterval()
+
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.active != 0 && interval > 0 && time.Since(e.activeSince) >= interval
+}
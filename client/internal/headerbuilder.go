@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/open-telemetry/opamp-go/client/types"
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/tracecontext"
+)
+
+// HeaderBuilder builds the HTTP headers to use for the requests to the Server.
+// The headers are layered in the following order, later layers override the
+// values of earlier layers:
+//  1. static headers of the endpoint (see types.Endpoint),
+//  2. headers returned by the types.HeaderProvider,
+//  3. headers offered by the Server in accepted OpAMPConnectionSettings,
+//  4. the W3C trace context returned by the tracecontext.ProviderFunc.
+//
+// It is safe to call methods of this struct concurrently.
+type HeaderBuilder struct {
+	provider types.HeaderProvider
+
+	// Returns the trace context to send, nil if none.
+	traceContext tracecontext.ProviderFunc
+
+	// Headers offered by the Server in the last accepted OpAMPConnectionSettings.
+	offered      http.Header
+	offeredMutex sync.RWMutex
+}
+
+func NewHeaderBuilder(provider types.HeaderProvider, traceContext tracecontext.ProviderFunc) *HeaderBuilder {
+	return &HeaderBuilder{provider: provider, traceContext: traceContext}
+}
+
+// Build returns the headers to use for a request to the specified endpoint.
+// forceRefresh is passed to the HeaderProvider, see HeaderProvider.Header.
+func (b *HeaderBuilder) Build(
+	ctx context.Context, endpoint types.Endpoint, forceRefresh bool,
+) (http.Header, error) {
+	header := endpoint.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+
+	if b.provider != nil {
+		provided, err := b.provider.Header(ctx, forceRefresh)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get headers from HeaderProvider: %w", err)
+		}
+		overrideHeader(header, provided)
+	}
+
+	b.offeredMutex.RLock()
+	overrideHeader(header, b.offered)
+	b.offeredMutex.RUnlock()
+
+	if b.traceContext != nil {
+		tracecontext.Inject(header, b.traceContext(ctx))
+	}
+
+	return header, nil
+}
+
+// SetOffered remembers the headers offered by the Server. The headers will be
+// used for all requests built after this call. nil headers keep the previously
+// offered headers unchanged.
+func (b *HeaderBuilder) SetOffered(headers *protobufs.Headers) {
+	if headers == nil {
+		return
+	}
+
+	offered := http.Header{}
+	for _, h := range headers.Headers {
+		offered.Add(h.Key, h.Value)
+	}
+
+	b.offeredMutex.Lock()
+	b.offered = offered
+	b.offeredMutex.Unlock()
+}
+
+// overrideHeader replaces the values in dest by the values of the same keys
+// in src.
+func overrideHeader(dest http.Header, src http.Header) {
+	for k, v := range src {
+		dest[http.CanonicalHeaderKey(k)] = v
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -16,6 +17,7 @@ import (
 
 	"github.com/open-telemetry/opamp-go/client/types"
 	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/tracecontext"
 )
 
 const OpAMPPlainHTTPMethod = "POST"
@@ -28,28 +30,44 @@ const defaultPollingIntervalMs = 30 * 1000 // default interval is 30 seconds.
 type HTTPSender struct {
 	SenderCommon
 
-	url               string
-	logger            types.Logger
-	client            *http.Client
+	logger            *internal.Logger
 	callbacks         types.Callbacks
 	pollingIntervalMs int64
 
-	// Headers to send with all requests.
-	requestHeader http.Header
+	// The Server endpoints to send the requests to and the HTTP clients to use
+	// for each endpoint.
+	endpoints *Endpoints
+	clients   []*http.Client
+
+	// Builder of the headers to send with every request.
+	headers *HeaderBuilder
+
+	// Defines how the network connections are established.
+	network NetworkSettings
+
+	// Signals that Run must return, see RequestStop.
+	stopRequested chan struct{}
+
+	// The policy to use when retrying failed requests.
+	backoffPolicy types.BackoffPolicy
+
+	// The timeout of the long-poll requests. 0 if long-polling is disabled.
+	longPollTimeout time.Duration
 
 	// Processor to handle received messages.
 	receiveProcessor receivedProcessor
+
+	// Serializes processing of the responses to the regular and long-poll requests.
+	receiveMutex sync.Mutex
 }
 
-func NewHTTPSender(logger types.Logger) *HTTPSender {
+func NewHTTPSender(logger *internal.Logger) *HTTPSender {
 	h := &HTTPSender{
 		SenderCommon:      NewSenderCommon(),
 		logger:            logger,
-		client:            http.DefaultClient,
 		pollingIntervalMs: defaultPollingIntervalMs,
+		stopRequested:     make(chan struct{}, 1),
 	}
-	// initialize the headers with no additional headers
-	h.SetRequestHeader(nil)
 	return h
 }
 
@@ -58,17 +76,42 @@ func NewHTTPSender(logger types.Logger) *HTTPSender {
 // a new message to send or the polling interval elapses.
 // Should not be called concurrently with itself. Can be called concurrently with
 // modifying NextMessage().
-// Run continues until ctx is cancelled.
+// Run continues until ctx is cancelled, until RequestStop is called or until the
+// backoff policy does not allow retrying a failed request anymore, in which case
+// types.ErrMaxAttemptsReached is returned.
 func (h *HTTPSender) Run(
 	ctx context.Context,
-	url string,
+	endpoints *Endpoints,
+	headers *HeaderBuilder,
 	callbacks types.Callbacks,
 	clientSyncedState *ClientSyncedState,
 	packagesStateProvider types.PackagesStateProvider,
-) {
-	h.url = url
+) error {
+	h.setEndpoints(endpoints)
+	h.headers = headers
 	h.callbacks = callbacks
-	h.receiveProcessor = newReceivedProcessor(h.logger, callbacks, h, clientSyncedState, packagesStateProvider)
+	h.receiveProcessor = newReceivedProcessor(h.logger, callbacks, h, clientSyncedState, packagesStateProvider, headers, h.network.DownloadClient)
+
+	// Discard a stop request that may be left from the previous Run.
+	select {
+	case <-h.stopRequested:
+	default:
+	}
+
+	if h.longPollTimeout > 0 {
+		// Keep a long-poll request outstanding until Run returns.
+		longPollCtx, longPollCancel := context.WithCancel(ctx)
+		var longPollDone sync.WaitGroup
+		longPollDone.Add(1)
+		go func() {
+			defer longPollDone.Done()
+			h.longPoll(longPollCtx)
+		}()
+		defer func() {
+			longPollCancel()
+			longPollDone.Wait()
+		}()
+	}
 
 	for {
 		pollingTimer := time.NewTimer(time.Millisecond * time.Duration(atomic.LoadInt64(&h.pollingIntervalMs)))
@@ -76,7 +119,10 @@ func (h *HTTPSender) Run(
 		case <-h.hasPendingMessage:
 			// Have something to send. Stop the polling timer and send what we have.
 			pollingTimer.Stop()
-			h.makeOneRequestRoundtrip(ctx)
+			if err := h.makeOneRequestRoundtrip(ctx); errors.Is(err, types.ErrMaxAttemptsReached) {
+				// We gave up, the backoff policy does not allow us to try anymore.
+				return err
+			}
 
 		case <-pollingTimer.C:
 			// Polling interval has passed. Force a status update.
@@ -86,90 +132,145 @@ func (h *HTTPSender) Run(
 			h.ScheduleSend()
 			break
 
+		case <-h.stopRequested:
+			pollingTimer.Stop()
+			return nil
+
 		case <-ctx.Done():
-			return
+			pollingTimer.Stop()
+			return ctx.Err()
 		}
 	}
 }
 
-// SetRequestHeader sets additional HTTP headers to send with all future requests.
-// Should not be called concurrently with any other method.
-func (h *HTTPSender) SetRequestHeader(header http.Header) {
-	if header == nil {
-		header = http.Header{}
+// RequestStop makes Run return after the request/response roundtrip that is
+// currently in progress, if any, is completed. Unlike cancelling the context of
+// Run this does not abort the roundtrip, so no message is lost.
+func (h *HTTPSender) RequestStop() {
+	select {
+	case h.stopRequested <- struct{}{}:
+	default:
 	}
-	h.requestHeader = header
-	h.requestHeader.Set(headerContentType, contentTypeProtobuf)
 }
 
-func (h *HTTPSender) makeOneRequestRoundtrip(ctx context.Context) {
+// setEndpoints sets the endpoints to send the requests to and prepares the HTTP
+// clients to use for each endpoint.
+func (h *HTTPSender) setEndpoints(endpoints *Endpoints) {
+	h.endpoints = endpoints
+	h.clients = make([]*http.Client, len(endpoints.All()))
+	for i := range h.clients {
+		h.clients[i] = h.network.HTTPClient(endpoints.All()[i].TLSConfig, endpoints.UnixSocket(i))
+	}
+}
+
+// SetBackoffPolicy sets the policy to use when retrying failed requests.
+// Should not be called concurrently with any other method.
+func (h *HTTPSender) SetBackoffPolicy(policy types.BackoffPolicy) {
+	h.backoffPolicy = policy
+}
+
+// SetLongPollTimeout enables long-polling with the specified timeout if it is
+// positive. Should not be called concurrently with any other method.
+func (h *HTTPSender) SetLongPollTimeout(timeout time.Duration) {
+	h.longPollTimeout = timeout
+}
+
+// SetNetworkSettings sets how the network connections are established.
+// Should not be called concurrently with any other method.
+func (h *HTTPSender) SetNetworkSettings(network NetworkSettings) {
+	h.network = network
+}
+
+func (h *HTTPSender) makeOneRequestRoundtrip(ctx context.Context) error {
 	resp, err := h.sendRequestWithRetries(ctx)
 	if err != nil {
-		return
+		return err
 	}
 	if resp == nil {
 		// No request was sent and nothing to receive.
-		return
+		return nil
 	}
 	h.receiveResponse(ctx, resp)
+	return nil
 }
 
 func (h *HTTPSender) sendRequestWithRetries(ctx context.Context) (*http.Response, error) {
-	req, err := h.prepareRequest(ctx)
+	body, err := h.prepareRequestBody(ctx)
 	if err != nil {
-		if errors.Is(err, context.Canceled) {
-			h.logger.Debugf("Client is stopped, will not try anymore.")
-		} else {
-			h.logger.Errorf("Failed prepare request (%v), will not try anymore.", err)
-		}
+		h.logger.Error("Failed to prepare request, will not try anymore.", types.F("error", err))
 		return nil, err
 	}
-	if req == nil {
+	if body == nil {
 		// Nothing to send.
 		return nil, nil
 	}
 
 	// Repeatedly try requests with a backoff strategy.
-	infiniteBackoff := backoff.NewExponentialBackOff()
-	// Make backoff run forever.
-	infiniteBackoff.MaxElapsedTime = 0
+	retryBackoff := NewBackOff(h.backoffPolicy)
 
 	interval := time.Duration(0)
 
 	for {
 		timer := time.NewTimer(interval)
-		interval = infiniteBackoff.NextBackOff()
 
 		select {
 		case <-timer.C:
 			{
-				resp, err := h.client.Do(req)
+				if h.endpoints.IsPrimaryProbeDue() {
+					// Try returning to the primary endpoint.
+					h.endpoints.SelectPrimary()
+				}
+
+				h.metrics.ConnectAttempts.Add(1, AttrTransportHTTP)
+				start := time.Now()
+				resp, err := h.sendRequest(ctx, body, false)
 				if err == nil {
 					switch resp.StatusCode {
 					case http.StatusOK:
 						// We consider it connected if we receive 200 status from the Server.
+						h.metrics.SendDuration.Record(time.Since(start).Seconds(), AttrTransportHTTP)
+						h.metrics.MessagesSent.Add(1, AttrTransportHTTP)
 						h.callbacks.OnConnect()
 						return resp, nil
 
-					case http.StatusTooManyRequests:
-					case http.StatusServiceUnavailable:
-						interval = recalculateInterval(interval, resp)
+					case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+						_ = resp.Body.Close()
 						err = fmt.Errorf("server response code=%d", resp.StatusCode)
 
 					default:
+						_ = resp.Body.Close()
+						h.metrics.ConnectFailures.Add(1, AttrTransportHTTP)
 						return nil, fmt.Errorf("invalid response from server: %d", resp.StatusCode)
 					}
 				} else if errors.Is(err, context.Canceled) {
-					h.logger.Debugf("Client is stopped, will not try anymore.")
+					h.logger.Debug("Client is stopped, will not try anymore.")
 					return nil, err
 				}
 
-				h.logger.Errorf("Failed to do HTTP request (%v), will retry", err)
+				h.metrics.ConnectFailures.Add(1, AttrTransportHTTP)
+
+				// Try the next endpoint on the next attempt.
+				h.endpoints.Rotate()
+
+				interval = retryBackoff.NextBackOff()
+				if interval == backoff.Stop {
+					h.logger.Error(
+						"Failed to do HTTP request, giving up.",
+						types.F("error", err), types.F("attempts", h.backoffPolicy.MaxAttempts),
+					)
+					err = MaxAttemptsReachedError(err)
+					h.callbacks.OnConnectFailed(err)
+					return nil, err
+				}
 				h.callbacks.OnConnectFailed(err)
+				if resp != nil {
+					interval = recalculateInterval(interval, resp)
+				}
+				h.logger.Warn("Failed to do HTTP request, will retry.", types.F("error", err))
 			}
 
 		case <-ctx.Done():
-			h.logger.Debugf("Client is stopped, will not try anymore.")
+			h.logger.Debug("Client is stopped, will not try anymore.")
 			return nil, ctx.Err()
 		}
 	}
@@ -186,47 +287,169 @@ func recalculateInterval(interval time.Duration, resp *http.Response) time.Durat
 	return interval
 }
 
-func (h *HTTPSender) prepareRequest(ctx context.Context) (*http.Request, error) {
+// prepareRequestBody returns the encoded pending message or nil if there is
+// nothing to send.
+func (h *HTTPSender) prepareRequestBody(ctx context.Context) ([]byte, error) {
 	msgToSend := h.nextMessage.PopPending()
 	if msgToSend == nil || proto.Equal(msgToSend, &protobufs.AgentToServer{}) {
 		// There is no pending message or the message is empty.
 		// Nothing to send.
 		return nil, nil
 	}
+	if err := h.interceptSend(ctx, msgToSend); err != nil {
+		h.logger.Warn("Cannot send", types.F("error", err))
+		return nil, nil
+	}
+	internal.RecordFieldSizes(h.metrics.MessageFieldSize, msgToSend, AttrDirectionSent)
+
+	return proto.Marshal(msgToSend)
+}
+
+// sendRequest sends a request with the specified body to the active endpoint.
+// If the Server rejects the credentials the request is repeated once with
+// refreshed headers.
+func (h *HTTPSender) sendRequest(ctx context.Context, body []byte, longPoll bool) (*http.Response, error) {
+	resp, err := h.sendRequestOnce(ctx, body, longPoll, false)
+	if err == nil && IsCredentialsRejected(resp) {
+		_ = resp.Body.Close()
+		h.logger.Debug("Server rejected the credentials, retrying with refreshed headers.", types.F("status", resp.StatusCode))
+		resp, err = h.sendRequestOnce(ctx, body, longPoll, true)
+	}
+	return resp, err
+}
 
-	data, err := proto.Marshal(msgToSend)
+func (h *HTTPSender) sendRequestOnce(
+	ctx context.Context, body []byte, longPoll bool, forceRefresh bool,
+) (*http.Response, error) {
+	endpoint, endpointURL, index := h.endpoints.Active()
+
+	// The same endpoints may be used for WebSocket transport, see client.NewAuto.
+	u := *endpointURL
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, OpAMPPlainHTTPMethod, u.String(), bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
 
-	body := bytes.NewReader(data)
-	req, err := http.NewRequestWithContext(ctx, OpAMPPlainHTTPMethod, h.url, body)
+	req.Header, err = h.headers.Build(ctx, endpoint, forceRefresh)
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set(headerContentType, contentTypeProtobuf)
+	if longPoll {
+		internal.SetLongPollTimeoutHeader(req.Header, h.longPollTimeout)
+	}
 
-	req.Header = h.requestHeader
-	return req, nil
+	return h.clients[index].Do(req)
+}
+
+// IsCredentialsRejected returns true if the Server rejected the request or
+// the WebSocket handshake because of missing, invalid or expired credentials.
+func IsCredentialsRejected(resp *http.Response) bool {
+	return resp != nil &&
+		(resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden)
 }
 
 func (h *HTTPSender) receiveResponse(ctx context.Context, resp *http.Response) {
 	msgBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
 		_ = resp.Body.Close()
-		h.logger.Errorf("cannot read response body: %v", err)
+		h.logger.Error("Cannot read response body", types.F("error", err))
 		return
 	}
 	_ = resp.Body.Close()
 
 	var response protobufs.ServerToAgent
 	if err := proto.Unmarshal(msgBytes, &response); err != nil {
-		h.logger.Errorf("cannot unmarshal response: %v", err)
+		h.logger.Error("Cannot unmarshal response", types.F("error", err))
+		return
+	}
+	h.metrics.MessagesReceived.Add(1, AttrTransportHTTP)
+	internal.RecordFieldSizes(h.metrics.MessageFieldSize, &response, AttrDirectionReceived)
+	if err := h.interceptReceive(ctx, &response); err != nil {
+		h.logger.Warn("Cannot process response", types.F("error", err))
 		return
 	}
 
+	h.receiveMutex.Lock()
+	defer h.receiveMutex.Unlock()
+	ctx, endSpan := h.startReceiveSpan(ctx, tracecontext.Extract(resp.Header))
+	defer endSpan()
 	h.receiveProcessor.ProcessReceivedMessage(ctx, &response)
 }
 
+// longPoll keeps a long-poll request outstanding so that the Server can send
+// a message to the Agent without waiting for the next regular request. Failed
+// requests are retried according to the backoff policy.
+// Returns when ctx is cancelled or when the backoff policy does not allow
+// retrying anymore. In the latter case the last error is reported via
+// OnConnectFailed and the Agent receives the messages in response to the regular
+// requests only.
+func (h *HTTPSender) longPoll(ctx context.Context) {
+	retryBackoff := NewBackOff(h.backoffPolicy)
+
+	for {
+		interval := time.Duration(0)
+		if err := h.longPollOnce(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			interval = retryBackoff.NextBackOff()
+			if interval == backoff.Stop {
+				h.logger.Error("Long-poll request failed, falling back to regular polling.", types.F("error", err))
+				h.callbacks.OnConnectFailed(err)
+				return
+			}
+			h.logger.Debug("Long-poll request failed, will retry.", types.F("error", err))
+		} else {
+			retryBackoff.Reset()
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// longPollOnce sends one long-poll request and processes the response.
+func (h *HTTPSender) longPollOnce(ctx context.Context) error {
+	// The long-poll request carries the instance UID only, the status is reported
+	// by the regular requests. The Server recognizes the long-poll request by the
+	// long-poll timeout header and does not treat it as a status report.
+	msg := &protobufs.AgentToServer{InstanceUid: h.nextMessage.InstanceUid()}
+	if err := h.interceptSend(ctx, msg); err != nil {
+		return err
+	}
+	internal.RecordFieldSizes(h.metrics.MessageFieldSize, msg, AttrDirectionSent)
+	body, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.sendRequest(ctx, body, true)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return fmt.Errorf("server response code=%d", resp.StatusCode)
+	}
+	h.metrics.MessagesSent.Add(1, AttrTransportHTTP)
+
+	h.receiveResponse(ctx, resp)
+	return nil
+}
+
 // SetPollingInterval sets the interval between polling. Has effect starting from the
 // next polling cycle.
 func (h *HTTPSender) SetPollingInterval(duration time.Duration) {
@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"github.com/open-telemetry/opamp-go/instrumentation"
+)
+
+// The attributes of the measurements.
+var (
+	AttrTransportWS       = instrumentation.Attribute{Key: "transport", Value: "ws"}
+	AttrTransportHTTP     = instrumentation.Attribute{Key: "transport", Value: "http"}
+	AttrDirectionSent     = instrumentation.Attribute{Key: "direction", Value: "sent"}
+	AttrDirectionReceived = instrumentation.Attribute{Key: "direction", Value: "received"}
+)
+
+// Metrics are the instruments that report the telemetry of the client internals,
+// see the instrumentation package for the list.
+type Metrics struct {
+	ConnectAttempts  instrumentation.Counter
+	ConnectFailures  instrumentation.Counter
+	Reconnects       instrumentation.Counter
+	MessagesSent     instrumentation.Counter
+	MessagesReceived instrumentation.Counter
+	MessageFieldSize instrumentation.Histogram
+	SendDuration     instrumentation.Histogram
+	DownloadBytes    instrumentation.Counter
+	DownloadDuration instrumentation.Histogram
+}
+
+// NewMetrics creates the instruments using the meter. nil meter discards the
+// measurements.
+func NewMetrics(meter instrumentation.Meter) *Metrics {
+	if meter == nil {
+		meter = instrumentation.NopMeter{}
+	}
+	return &Metrics{
+		ConnectAttempts: meter.Counter(
+			"opamp.client.connection.attempts", "{attempt}", "The number of attempts to connect to the Server.",
+		),
+		ConnectFailures: meter.Counter(
+			"opamp.client.connection.failures", "{attempt}", "The number of failed attempts to connect to the Server.",
+		),
+		Reconnects: meter.Counter(
+			"opamp.client.reconnects", "{connection}", "The number of connections established after the first one.",
+		),
+		MessagesSent: meter.Counter(
+			"opamp.client.messages.sent", "{message}", "The number of messages sent to the Server.",
+		),
+		MessagesReceived: meter.Counter(
+			"opamp.client.messages.received", "{message}", "The number of messages received from the Server.",
+		),
+		MessageFieldSize: meter.Histogram(
+			"opamp.client.message.field.size", "By", "The encoded size of the fields of the exchanged messages.",
+		),
+		SendDuration: meter.Histogram(
+			"opamp.client.send.duration", "s", "The duration of sending a message to the Server.",
+		),
+		DownloadBytes: meter.Counter(
+			"opamp.client.package.download.bytes", "By", "The number of downloaded package bytes.",
+		),
+		DownloadDuration: meter.Histogram(
+			"opamp.client.package.download.duration", "s", "The duration of package file downloads.",
+		),
+	}
+}
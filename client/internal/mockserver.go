@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -31,6 +32,9 @@ type MockServer struct {
 	expectedHandlers chan receivedMessageHandler
 	expectedComplete chan struct{}
 	isExpectMode     bool
+
+	// Non-zero if WebSocket upgrade requests must be refused.
+	rejectWebSocket int32
 }
 
 const headerContentType = "Content-Type"
@@ -62,6 +66,11 @@ func StartMockServer(t *testing.T) *MockServer {
 				return
 			}
 
+			if atomic.LoadInt32(&srv.rejectWebSocket) != 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
 			srv.handleWebSocket(t, w, r)
 		},
 	)
@@ -79,6 +88,16 @@ func StartMockServer(t *testing.T) *MockServer {
 	return srv
 }
 
+// SetRejectWebSocket defines if the server refuses WebSocket upgrade requests.
+// Plain HTTP requests are served regardless of this setting.
+func (m *MockServer) SetRejectWebSocket(reject bool) {
+	var v int32
+	if reject {
+		v = 1
+	}
+	atomic.StoreInt32(&m.rejectWebSocket, v)
+}
+
 // EnableExpectMode enables the expect mode that allows using Expect() method
 // to describe what message is expected to be received.
 func (m *MockServer) EnableExpectMode() {
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/open-telemetry/opamp-go/client/types"
+)
+
+// NetworkSettings defines how the client establishes network connections. The same
+// settings are used for the WebSocket and plain HTTP connections to the Server and
+// for the package downloads.
+type NetworkSettings struct {
+	// Optional custom function to establish network connections.
+	NetDialContext types.NetDialContextFunc
+
+	// The function that selects the proxy to use.
+	Proxy ProxyFunc
+
+	// HTTP client to use for downloading packages.
+	DownloadClient *http.Client
+}
+
+// NewNetworkSettings creates the network settings from the start settings.
+func NewNetworkSettings(settings types.StartSettings) (NetworkSettings, error) {
+	proxy, err := NewProxyFunc(settings.Proxy)
+	if err != nil {
+		return NetworkSettings{}, err
+	}
+	n := NetworkSettings{
+		NetDialContext: settings.NetDialContext,
+		Proxy:          proxy,
+	}
+	n.DownloadClient = n.HTTPClient(nil, "")
+	return n, nil
+}
+
+// DialContext returns the function to use for establishing network connections.
+// If NetDialContext is nil connections are dialed using net.Dialer.
+// If unixSocket is not empty all connections are dialed to the Unix domain socket
+// at that path regardless of the requested network and address.
+func (n NetworkSettings) DialContext(unixSocket string) types.NetDialContextFunc {
+	dial := n.NetDialContext
+	if dial == nil {
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}
+		dial = dialer.DialContext
+	}
+
+	if unixSocket == "" {
+		return dial
+	}
+
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dial(ctx, "unix", unixSocket)
+	}
+}
+
+// HTTPClient creates an HTTP client that uses the network settings.
+// tlsConfig and unixSocket are optional.
+func (n NetworkSettings) HTTPClient(tlsConfig *tls.Config, unixSocket string) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.DialContext = n.DialContext(unixSocket)
+	transport.Proxy = n.Proxy
+	if unixSocket != "" {
+		// Requests never leave the host, no need to use a proxy.
+		transport.Proxy = nil
+	}
+	return &http.Client{Transport: transport}
+}
+
+// WebSocketDialer creates a WebSocket dialer that uses the network settings.
+// tlsConfig and unixSocket are optional.
+func (n NetworkSettings) WebSocketDialer(tlsConfig *tls.Config, unixSocket string) websocket.Dialer {
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = tlsConfig
+	dialer.NetDialContext = n.DialContext(unixSocket)
+	dialer.Proxy = n.Proxy
+	if unixSocket != "" {
+		// Connection never leaves the host, no need to use a proxy.
+		dialer.Proxy = nil
+	}
+	return dialer
+}
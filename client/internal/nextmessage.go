@@ -33,6 +33,13 @@ func (s *NextMessage) Update(modifier func(msg *protobufs.AgentToServer)) {
 	s.messageMutex.Unlock()
 }
 
+// InstanceUid returns the instance UID of the next message.
+func (s *NextMessage) InstanceUid() string {
+	s.messageMutex.Lock()
+	defer s.messageMutex.Unlock()
+	return s.nextMessage.InstanceUid
+}
+
 // PopPending returns the next message to be sent, if it is pending or nil otherwise.
 // Clears the "pending" flag.
 func (s *NextMessage) PopPending() *protobufs.AgentToServer {
@@ -5,37 +5,46 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/open-telemetry/opamp-go/client/types"
+	sharedinternal "github.com/open-telemetry/opamp-go/internal"
 	"github.com/open-telemetry/opamp-go/protobufs"
 )
 
 // packagesSyncer performs the package syncing process.
 type packagesSyncer struct {
-	logger            types.Logger
+	logger            *sharedinternal.Logger
 	available         *protobufs.PackagesAvailable
 	clientSyncedState *ClientSyncedState
 	localState        types.PackagesStateProvider
 	sender            Sender
+	downloadClient    *http.Client
 
 	statuses *protobufs.PackageStatuses
 	doneCh   chan struct{}
 }
 
 func NewPackagesSyncer(
-	logger types.Logger,
+	logger *sharedinternal.Logger,
 	available *protobufs.PackagesAvailable,
 	sender Sender,
 	clientSyncedState *ClientSyncedState,
 	packagesStateProvider types.PackagesStateProvider,
+	downloadClient *http.Client,
 ) *packagesSyncer {
+	if downloadClient == nil {
+		downloadClient = http.DefaultClient
+	}
 	return &packagesSyncer{
 		logger:            logger,
 		available:         available,
 		sender:            sender,
 		clientSyncedState: clientSyncedState,
 		localState:        packagesStateProvider,
+		downloadClient:    downloadClient,
 		doneCh:            make(chan struct{}),
 	}
 }
@@ -98,17 +107,17 @@ func (s *packagesSyncer) initStatuses() error {
 func (s *packagesSyncer) doSync(ctx context.Context) {
 	hash, err := s.localState.AllPackagesHash()
 	if err != nil {
-		s.logger.Errorf("Package syncing failed: %V", err)
+		s.logger.Error("Package syncing failed", types.F("error", err))
 		return
 	}
 	if bytes.Compare(hash, s.available.AllPackagesHash) == 0 {
-		s.logger.Debugf("All packages are already up to date.")
+		s.logger.Debug("All packages are already up to date.")
 		return
 	}
 
 	failed := false
 	if err := s.deleteUnneededLocalPackages(); err != nil {
-		s.logger.Errorf("Cannot delete unneeded packages: %v", err)
+		s.logger.Error("Cannot delete unneeded packages", types.F("error", err))
 		failed = true
 	}
 
@@ -116,7 +125,7 @@ func (s *packagesSyncer) doSync(ctx context.Context) {
 	for name, pkg := range s.available.Packages {
 		err := s.syncPackage(ctx, name, pkg)
 		if err != nil {
-			s.logger.Errorf("Cannot sync package %s: %v", name, err)
+			s.logger.Error("Cannot sync package", types.F("package", name), types.F("error", err))
 			failed = true
 		}
 	}
@@ -125,12 +134,12 @@ func (s *packagesSyncer) doSync(ctx context.Context) {
 		// Update the "all" hash on success, so that next time Sync() does not thing,
 		// unless a new hash is received from the Server.
 		if err := s.localState.SetAllPackagesHash(s.available.AllPackagesHash); err != nil {
-			s.logger.Errorf("SetAllPackagesHash failed: %v", err)
+			s.logger.Error("SetAllPackagesHash failed", types.F("error", err))
 		} else {
-			s.logger.Debugf("All packages are synced and up to date.")
+			s.logger.Info("All packages are synced and up to date.")
 		}
 	} else {
-		s.logger.Errorf("Package syncing was not successful.")
+		s.logger.Error("Package syncing was not successful.")
 	}
 
 	_ = s.reportStatuses(true)
@@ -161,7 +170,7 @@ func (s *packagesSyncer) syncPackage(
 	mustCreate := !pkgLocal.Exists
 	if pkgLocal.Exists {
 		if bytes.Equal(pkgLocal.Hash, pkgAvail.Hash) {
-			s.logger.Debugf("Package %s hash is unchanged, skipping", pkgName)
+			s.logger.Debug("Package hash is unchanged, skipping", types.F("package", pkgName))
 			return nil
 		}
 		if pkgLocal.Type != pkgAvail.Type {
@@ -232,14 +241,13 @@ func (s *packagesSyncer) shouldDownloadFile(
 	fileContentHash, err := s.localState.FileContentHash(packageName)
 
 	if err != nil {
-		err := fmt.Errorf("cannot calculate checksum of %s: %v", packageName, err)
-		s.logger.Errorf(err.Error())
+		s.logger.Error("Cannot calculate checksum", types.F("package", packageName), types.F("error", err))
 		return true, nil
 	} else {
 		// Compare the checksum of the file we have with what
 		// we are offered by the server.
 		if bytes.Compare(fileContentHash, file.ContentHash) != 0 {
-			s.logger.Debugf("Package %s: file hash mismatch, will download.", packageName)
+			s.logger.Debug("File hash mismatch, will download.", types.F("package", packageName))
 			return true, nil
 		}
 	}
@@ -247,14 +255,15 @@ func (s *packagesSyncer) shouldDownloadFile(
 }
 
 func (s *packagesSyncer) downloadFile(ctx context.Context, pkgName string, file *protobufs.DownloadableFile) error {
-	s.logger.Debugf("Downloading package %s file from %s", pkgName, file.DownloadUrl)
+	s.logger.Info("Downloading package file", types.F("package", pkgName), types.F("url", file.DownloadUrl))
 
 	req, err := http.NewRequestWithContext(ctx, "GET", file.DownloadUrl, nil)
 	if err != nil {
 		return fmt.Errorf("cannot download file from %s: %v", file.DownloadUrl, err)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	start := time.Now()
+	resp, err := s.downloadClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("cannot download file from %s: %v", file.DownloadUrl, err)
 	}
@@ -267,13 +276,29 @@ func (s *packagesSyncer) downloadFile(ctx context.Context, pkgName string, file
 	// TODO: either add a callback to verify file.Signature or pass the Signature
 	// as a parameter to UpdateContent.
 
-	err = s.localState.UpdateContent(ctx, pkgName, resp.Body, file.ContentHash)
+	body := &countingReader{reader: resp.Body}
+	err = s.localState.UpdateContent(ctx, pkgName, body, file.ContentHash)
+	metrics := s.sender.Metrics()
+	metrics.DownloadBytes.Add(body.count)
+	metrics.DownloadDuration.Record(time.Since(start).Seconds())
 	if err != nil {
 		return fmt.Errorf("cannot download file from %s: %v", file.DownloadUrl, err)
 	}
 	return nil
 }
 
+// countingReader counts the bytes read from the reader.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
 func (s *packagesSyncer) deleteUnneededLocalPackages() error {
 	// Read the list of packages we have locally.
 	localPackages, err := s.localState.Packages()
@@ -285,7 +310,7 @@ func (s *packagesSyncer) deleteUnneededLocalPackages() error {
 	for _, localPkg := range localPackages {
 		// Do we have a package that is not offered?
 		if _, offered := s.available.Packages[localPkg]; !offered {
-			s.logger.Debugf("Package %s is no longer needed, deleting.", localPkg)
+			s.logger.Info("Package is no longer needed, deleting.", types.F("package", localPkg))
 			err := s.localState.DeletePackage(localPkg)
 			if err != nil {
 				lastErr = err
@@ -306,13 +331,13 @@ func (s *packagesSyncer) deleteUnneededLocalPackages() error {
 func (s *packagesSyncer) reportStatuses(sendImmediately bool) error {
 	// Save it in the user-supplied state provider.
 	if err := s.localState.SetLastReportedStatuses(s.statuses); err != nil {
-		s.logger.Errorf("Cannot save last reported statuses: %v", err)
+		s.logger.Error("Cannot save last reported statuses", types.F("error", err))
 		return err
 	}
 
 	// Also save it in our internal state (will be needed if the Server asks for it).
 	if err := s.clientSyncedState.SetPackageStatuses(s.statuses); err != nil {
-		s.logger.Errorf("Cannot save client state: %v", err)
+		s.logger.Error("Cannot save client state", types.F("error", err))
 		return err
 	}
 	s.sender.NextMessage().Update(
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/open-telemetry/opamp-go/client/types"
+)
+
+// ProxyFunc returns the URL of the proxy to use for the request or nil if the
+// request must be sent directly.
+type ProxyFunc func(req *http.Request) (*url.URL, error)
+
+// NewProxyFunc creates a ProxyFunc from the proxy settings. If settings is nil
+// the proxy is selected using the environment variables, see http.ProxyFromEnvironment.
+func NewProxyFunc(settings *types.ProxySettings) (ProxyFunc, error) {
+	if settings == nil {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	proxyURL, err := url.Parse(settings.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy URL scheme %q", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("proxy host is not specified in %q", settings.URL)
+	}
+
+	if settings.Username != "" || settings.Password != "" {
+		proxyURL.User = url.UserPassword(settings.Username, settings.Password)
+	}
+
+	noProxy := settings.NoProxy
+	return func(req *http.Request) (*url.URL, error) {
+		if isNoProxyHost(req.URL.Hostname(), noProxy) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}, nil
+}
+
+// isNoProxyHost returns true if the host matches any of the NoProxy entries.
+// See types.ProxySettings.NoProxy for the format of the entries.
+func isNoProxyHost(host string, noProxy []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	hostIP := net.ParseIP(host)
+
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+
+		case entry == "*":
+			return true
+
+		case strings.Contains(entry, "/"):
+			_, ipNet, err := net.ParseCIDR(entry)
+			if err == nil && hostIP != nil && ipNet.Contains(hostIP) {
+				return true
+			}
+
+		default:
+			entry = strings.TrimPrefix(entry, ".")
+			if host == entry || strings.HasSuffix(host, "."+entry) {
+				return true
+			}
+		}
+	}
+	return false
+}
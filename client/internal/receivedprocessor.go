@@ -3,14 +3,16 @@ package internal
 import (
 	"context"
 	"errors"
+	"net/http"
 
 	"github.com/open-telemetry/opamp-go/client/types"
+	sharedinternal "github.com/open-telemetry/opamp-go/internal"
 	"github.com/open-telemetry/opamp-go/protobufs"
 )
 
 // receivedProcessor handles the processing of messages received from the Server.
 type receivedProcessor struct {
-	logger types.Logger
+	logger *sharedinternal.Logger
 
 	// Callbacks to call for corresponding messages.
 	callbacks types.Callbacks
@@ -23,14 +25,22 @@ type receivedProcessor struct {
 	clientSyncedState *ClientSyncedState
 
 	packagesStateProvider types.PackagesStateProvider
+
+	// Headers builder to update when the Server offers new connection settings.
+	headers *HeaderBuilder
+
+	// HTTP client to use for downloading packages.
+	downloadClient *http.Client
 }
 
 func newReceivedProcessor(
-	logger types.Logger,
+	logger *sharedinternal.Logger,
 	callbacks types.Callbacks,
 	sender Sender,
 	clientSyncedState *ClientSyncedState,
 	packagesStateProvider types.PackagesStateProvider,
+	headers *HeaderBuilder,
+	downloadClient *http.Client,
 ) receivedProcessor {
 	return receivedProcessor{
 		logger:                logger,
@@ -38,6 +48,8 @@ func newReceivedProcessor(
 		sender:                sender,
 		clientSyncedState:     clientSyncedState,
 		packagesStateProvider: packagesStateProvider,
+		headers:               headers,
+		downloadClient:        downloadClient,
 	}
 }
 
@@ -54,7 +66,7 @@ func (r *receivedProcessor) ProcessReceivedMessage(ctx context.Context, msg *pro
 
 		scheduled, err := r.rcvFlags(ctx, msg.Flags)
 		if err != nil {
-			r.logger.Errorf("cannot processed received flags:%v", err)
+			r.logger.Error("Cannot process received flags", types.F("error", err))
 		}
 
 		msgData := &types.MessageData{
@@ -76,6 +88,7 @@ func (r *receivedProcessor) ProcessReceivedMessage(ctx context.Context, msg *pro
 				r.sender,
 				r.clientSyncedState,
 				r.packagesStateProvider,
+				r.downloadClient,
 			)
 		}
 
@@ -151,8 +164,16 @@ func (r *receivedProcessor) rcvOpampConnectionSettings(ctx context.Context, sett
 		return
 	}
 
+	r.logger.Debug("Server offered OpAMP connection settings", types.F("settings", settings.Opamp))
 	err := r.callbacks.OnOpampConnectionSettings(ctx, settings.Opamp)
-	if err == nil {
+	if err != nil {
+		r.logger.Info("OpAMP connection settings are rejected", types.F("error", err))
+	} else {
+		// The offered headers are layered on top of our own headers for all
+		// subsequent requests.
+		if r.headers != nil {
+			r.headers.SetOffered(settings.Opamp.Headers)
+		}
 		// TODO: verify connection using new settings.
 		r.callbacks.OnOpampConnectionSettingsAccepted(settings.Opamp)
 	}
@@ -160,19 +181,19 @@ func (r *receivedProcessor) rcvOpampConnectionSettings(ctx context.Context, sett
 
 func (r *receivedProcessor) processErrorResponse(body *protobufs.ServerErrorResponse) {
 	// TODO: implement this.
-	r.logger.Errorf("received an error from server: %s", body.ErrorMessage)
+	r.logger.Error("Received an error from the Server", types.F("type", body.Type), types.F("message", body.ErrorMessage))
 }
 
 func (r *receivedProcessor) rcvAgentIdentification(agentId *protobufs.AgentIdentification) error {
 	if agentId.NewInstanceUid == "" {
 		err := errors.New("empty instance uid is not allowed")
-		r.logger.Debugf(err.Error())
+		r.logger.Warn("Cannot change instance uid", types.F("error", err))
 		return err
 	}
 
 	err := r.sender.SetInstanceUid(agentId.NewInstanceUid)
 	if err != nil {
-		r.logger.Errorf("Error while setting instance uid: %v, err")
+		r.logger.Error("Error while setting instance uid", types.F("error", err))
 		return err
 	}
 
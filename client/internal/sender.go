@@ -1,9 +1,13 @@
 package internal
 
 import (
+	"context"
 	"errors"
+	"fmt"
 
+	"github.com/open-telemetry/opamp-go/client/types"
 	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/tracecontext"
 )
 
 // Sender is an interface of the sending portion of OpAMP protocol that stores
@@ -21,6 +25,22 @@ type Sender interface {
 
 	// SetInstanceUid sets a new instanceUid to be used for all subsequent messages to be sent.
 	SetInstanceUid(instanceUid string) error
+
+	// SetInterceptors sets the interceptors of the sent and received messages.
+	// Should not be called concurrently with any other method.
+	SetInterceptors(interceptors []types.Interceptor)
+
+	// SetStartSpan sets the func that starts the spans around the processing of
+	// the received messages, nil if no spans are started.
+	// Should not be called concurrently with any other method.
+	SetStartSpan(startSpan tracecontext.StartSpanFunc)
+
+	// SetMetrics sets the instruments that report the telemetry of the sender.
+	// Should not be called concurrently with any other method.
+	SetMetrics(metrics *Metrics)
+
+	// Metrics returns the instruments that report the telemetry of the sender.
+	Metrics() *Metrics
 }
 
 // SenderCommon is partial Sender implementation that is common WebSocket and plain
@@ -30,14 +50,26 @@ type SenderCommon struct {
 	// Indicates that there is a pending message to send.
 	hasPendingMessage chan struct{}
 
-	// The next message to send.
-	nextMessage NextMessage
+	// The next message to send. May be shared with other senders, see SwitchableSender.
+	nextMessage *NextMessage
+
+	// Interceptors of the sent and received messages.
+	interceptors []types.Interceptor
+
+	// Starts the spans around the processing of the received messages, nil if
+	// no spans are started.
+	startSpan tracecontext.StartSpanFunc
+
+	// Instruments that report the telemetry.
+	metrics *Metrics
 }
 
 func NewSenderCommon() SenderCommon {
+	nextMessage := NewNextMessage()
 	return SenderCommon{
 		hasPendingMessage: make(chan struct{}, 1),
-		nextMessage:       NewNextMessage(),
+		nextMessage:       &nextMessage,
+		metrics:           NewMetrics(nil),
 	}
 }
 
@@ -56,7 +88,77 @@ func (h *SenderCommon) ScheduleSend() {
 // NextMessage gives access to the next message that will be sent by this looper.
 // Can be called concurrently with any other method.
 func (h *SenderCommon) NextMessage() *NextMessage {
-	return &h.nextMessage
+	return h.nextMessage
+}
+
+// setNextMessage makes the sender use the specified NextMessage. Must be called
+// before the sender is used.
+func (h *SenderCommon) setNextMessage(nextMessage *NextMessage) {
+	h.nextMessage = nextMessage
+}
+
+// SetInterceptors sets the interceptors of the sent and received messages.
+// Should not be called concurrently with any other method.
+func (h *SenderCommon) SetInterceptors(interceptors []types.Interceptor) {
+	h.interceptors = interceptors
+}
+
+// SetStartSpan sets the func that starts the spans around the processing of
+// the received messages, nil if no spans are started.
+// Should not be called concurrently with any other method.
+func (h *SenderCommon) SetStartSpan(startSpan tracecontext.StartSpanFunc) {
+	h.startSpan = startSpan
+}
+
+// SetMetrics sets the instruments that report the telemetry of the sender.
+// Should not be called concurrently with any other method.
+func (h *SenderCommon) SetMetrics(metrics *Metrics) {
+	h.metrics = metrics
+}
+
+// Metrics returns the instruments that report the telemetry of the sender.
+func (h *SenderCommon) Metrics() *Metrics {
+	return h.metrics
+}
+
+// startReceiveSpan starts the span around the processing of a received message.
+// parent is the trace context sent by the Server. Returns the context to process
+// the message with and the func that ends the span.
+func (h *SenderCommon) startReceiveSpan(
+	ctx context.Context, parent tracecontext.TraceContext,
+) (context.Context, func()) {
+	if h.startSpan == nil {
+		return ctx, func() {}
+	}
+	return h.startSpan(ctx, "opamp.client.message", parent)
+}
+
+// interceptSend calls the OnSend interceptors. Returns an error if the message
+// must be dropped.
+func (h *SenderCommon) interceptSend(ctx context.Context, msg *protobufs.AgentToServer) error {
+	for _, interceptor := range h.interceptors {
+		if interceptor.OnSend == nil {
+			continue
+		}
+		if err := interceptor.OnSend(ctx, msg); err != nil {
+			return fmt.Errorf("message dropped by interceptor: %w", err)
+		}
+	}
+	return nil
+}
+
+// interceptReceive calls the OnReceive interceptors. Returns an error if the
+// message must be dropped.
+func (h *SenderCommon) interceptReceive(ctx context.Context, msg *protobufs.ServerToAgent) error {
+	for _, interceptor := range h.interceptors {
+		if interceptor.OnReceive == nil {
+			continue
+		}
+		if err := interceptor.OnReceive(ctx, msg); err != nil {
+			return fmt.Errorf("message dropped by interceptor: %w", err)
+		}
+	}
+	return nil
 }
 
 // SetInstanceUid sets a new instanceUid to be used for all subsequent messages to be sent.
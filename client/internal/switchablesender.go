@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"sync"
+
+	"github.com/open-telemetry/opamp-go/client/types"
+	"github.com/open-telemetry/opamp-go/tracecontext"
+)
+
+// sharingSender is a Sender that can use a NextMessage shared with other senders.
+type sharingSender interface {
+	Sender
+	setNextMessage(nextMessage *NextMessage)
+}
+
+// SwitchableSender is a Sender that forwards ScheduleSend calls to one of the
+// transport-specific senders, the active sender. All senders share the same
+// NextMessage, so the content pending to be sent is preserved when the active
+// sender is switched.
+type SwitchableSender struct {
+	SenderCommon
+
+	senders     []sharingSender
+	active      Sender
+	activeMutex sync.RWMutex
+}
+
+// NewSwitchableSender creates a SwitchableSender that shares its NextMessage with
+// the specified senders. The first of the senders is initially active.
+func NewSwitchableSender(senders ...sharingSender) *SwitchableSender {
+	s := &SwitchableSender{
+		SenderCommon: NewSenderCommon(),
+		senders:      senders,
+		active:       senders[0],
+	}
+	for _, sender := range senders {
+		sender.setNextMessage(s.nextMessage)
+	}
+	return s
+}
+
+// ScheduleSend signals to the active sender that the message in NextMessage
+// struct is now ready to be sent.
+func (s *SwitchableSender) ScheduleSend() {
+	s.activeMutex.RLock()
+	active := s.active
+	s.activeMutex.RUnlock()
+
+	active.ScheduleSend()
+}
+
+// SetInterceptors sets the interceptors of all senders.
+// Should not be called concurrently with any other method.
+func (s *SwitchableSender) SetInterceptors(interceptors []types.Interceptor) {
+	for _, sender := range s.senders {
+		sender.SetInterceptors(interceptors)
+	}
+}
+
+// SetStartSpan sets the func that starts the spans of all senders.
+// Should not be called concurrently with any other method.
+func (s *SwitchableSender) SetStartSpan(startSpan tracecontext.StartSpanFunc) {
+	for _, sender := range s.senders {
+		sender.SetStartSpan(startSpan)
+	}
+}
+
+// SetMetrics sets the instruments of all senders.
+// Should not be called concurrently with any other method.
+func (s *SwitchableSender) SetMetrics(metrics *Metrics) {
+	s.SenderCommon.SetMetrics(metrics)
+	for _, sender := range s.senders {
+		sender.SetMetrics(metrics)
+	}
+}
+
+// SetActive makes the specified sender active. The sender must be one of the
+// senders specified in NewSwitchableSender. If there is a pending message the
+// newly active sender is signalled to send it.
+func (s *SwitchableSender) SetActive(sender Sender) {
+	s.activeMutex.Lock()
+	s.active = sender
+	s.activeMutex.Unlock()
+
+	sender.ScheduleSend()
+}
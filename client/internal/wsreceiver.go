@@ -3,37 +3,42 @@ package internal
 import (
 	"context"
 	"fmt"
+	"net/http"
 
 	"github.com/gorilla/websocket"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/open-telemetry/opamp-go/client/types"
+	sharedinternal "github.com/open-telemetry/opamp-go/internal"
 	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/tracecontext"
 )
 
 // wsReceiver implements the WebSocket client's receiving portion of OpAMP protocol.
 type wsReceiver struct {
 	conn      *websocket.Conn
-	logger    types.Logger
+	logger    *sharedinternal.Logger
 	sender    *WSSender
 	callbacks types.Callbacks
 	processor receivedProcessor
 }
 
 func NewWSReceiver(
-	logger types.Logger,
+	logger *sharedinternal.Logger,
 	callbacks types.Callbacks,
 	conn *websocket.Conn,
 	sender *WSSender,
 	clientSyncedState *ClientSyncedState,
 	packagesStateProvider types.PackagesStateProvider,
+	headers *HeaderBuilder,
+	downloadClient *http.Client,
 ) *wsReceiver {
 	w := &wsReceiver{
 		conn:      conn,
 		logger:    logger,
 		sender:    sender,
 		callbacks: callbacks,
-		processor: newReceivedProcessor(logger, callbacks, sender, clientSyncedState, packagesStateProvider),
+		processor: newReceivedProcessor(logger, callbacks, sender, clientSyncedState, packagesStateProvider, headers, downloadClient),
 	}
 
 	return w
@@ -47,12 +52,21 @@ out:
 		var message protobufs.ServerToAgent
 		if err := r.receiveMessage(&message); err != nil {
 			if ctx.Err() == nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
-				r.logger.Errorf("Unexpected error while receiving: %v", err)
+				r.logger.Error("Unexpected error while receiving", types.F("error", err))
 			}
 			break out
-		} else {
-			r.processor.ProcessReceivedMessage(runContext, &message)
 		}
+
+		r.sender.metrics.MessagesReceived.Add(1, AttrTransportWS)
+		sharedinternal.RecordFieldSizes(r.sender.metrics.MessageFieldSize, &message, AttrDirectionReceived)
+		if err := r.sender.interceptReceive(runContext, &message); err != nil {
+			r.logger.Warn("Cannot process received message", types.F("error", err))
+			continue
+		}
+		// WebSocket messages carry no trace context.
+		processContext, endSpan := r.sender.startReceiveSpan(runContext, tracecontext.TraceContext{})
+		r.processor.ProcessReceivedMessage(processContext, &message)
+		endSpan()
 	}
 
 	cancelFunc()
@@ -2,11 +2,13 @@ package internal
 
 import (
 	"context"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/open-telemetry/opamp-go/client/types"
+	sharedinternal "github.com/open-telemetry/opamp-go/internal"
 	"github.com/open-telemetry/opamp-go/protobufs"
 )
 
@@ -14,12 +16,12 @@ import (
 type WSSender struct {
 	SenderCommon
 	conn   *websocket.Conn
-	logger types.Logger
+	logger *sharedinternal.Logger
 	// Indicates that the sender has fully stopped.
 	stopped chan struct{}
 }
 
-func NewSender(logger types.Logger) *WSSender {
+func NewSender(logger *sharedinternal.Logger) *WSSender {
 	return &WSSender{
 		logger:       logger,
 		SenderCommon: NewSenderCommon(),
@@ -30,7 +32,7 @@ func NewSender(logger types.Logger) *WSSender {
 // earlier. To stop the WSSender cancel the ctx.
 func (s *WSSender) Start(ctx context.Context, conn *websocket.Conn) error {
 	s.conn = conn
-	err := s.sendNextMessage()
+	err := s.sendNextMessage(ctx)
 
 	// Run the sender in the background.
 	s.stopped = make(chan struct{})
@@ -50,7 +52,7 @@ out:
 	for {
 		select {
 		case <-s.hasPendingMessage:
-			s.sendNextMessage()
+			s.sendNextMessage(ctx)
 
 		case <-ctx.Done():
 			break out
@@ -60,10 +62,14 @@ out:
 	close(s.stopped)
 }
 
-func (s *WSSender) sendNextMessage() error {
+func (s *WSSender) sendNextMessage(ctx context.Context) error {
 	msgToSend := s.nextMessage.PopPending()
 	if msgToSend != nil && !proto.Equal(msgToSend, &protobufs.AgentToServer{}) {
 		// There is a pending message and the message has some fields populated.
+		if err := s.interceptSend(ctx, msgToSend); err != nil {
+			s.logger.Warn("Cannot send", types.F("error", err))
+			return nil
+		}
 		return s.sendMessage(msgToSend)
 	}
 	return nil
@@ -72,13 +78,18 @@ func (s *WSSender) sendNextMessage() error {
 func (s *WSSender) sendMessage(msg *protobufs.AgentToServer) error {
 	data, err := proto.Marshal(msg)
 	if err != nil {
-		s.logger.Errorf("Cannot marshal data: %v", err)
+		s.logger.Error("Cannot marshal data", types.F("error", err))
 		return err
 	}
+	start := time.Now()
 	err = s.conn.WriteMessage(websocket.BinaryMessage, data)
 	if err != nil {
-		s.logger.Errorf("Cannot send: %v", err)
+		s.logger.Error("Cannot send", types.F("error", err))
 		// TODO: propagate error back to Client and reconnect.
+		return err
 	}
-	return err
+	s.metrics.SendDuration.Record(time.Since(start).Seconds(), AttrTransportWS)
+	s.metrics.MessagesSent.Add(1, AttrTransportWS)
+	sharedinternal.RecordFieldSizes(s.metrics.MessageFieldSize, msg, AttrDirectionSent)
+	return nil
 }
@@ -0,0 +1,20 @@
+package types
+
+import "time"
+
+// AutoTransportSettings defines how the client created by client.NewAuto selects
+// the transport. The client connects using WebSocket transport first and falls
+// back to plain HTTP transport if the WebSocket upgrade is refused by the Server
+// or by a proxy on the way, or if connecting fails repeatedly. While using plain
+// HTTP transport the client periodically retries upgrading to WebSocket.
+// Zero values of the fields mean that the default value is used.
+type AutoTransportSettings struct {
+	// FallbackAfterAttempts is the number of consecutive failed WebSocket connection
+	// attempts after which the client falls back to plain HTTP transport. When the
+	// upgrade is explicitly refused the client falls back immediately. Default is 3.
+	FallbackAfterAttempts int
+
+	// UpgradeRetryInterval defines how often the client tries to upgrade to
+	// WebSocket transport while it is using plain HTTP transport. Default is 5m.
+	UpgradeRetryInterval time.Duration
+}
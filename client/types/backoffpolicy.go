@@ -0,0 +1,48 @@
+package types
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrMaxAttemptsReached is reported via OnConnectFailed callback when the client
+// gives up connecting to the Server because BackoffPolicy.MaxAttempts consecutive
+// attempts have failed. The reported error matches ErrMaxAttemptsReached using
+// errors.Is and wraps the error of the last attempt. The client does not make any
+// further attempts after reporting this error.
+var ErrMaxAttemptsReached = errors.New("maximum number of connection attempts reached")
+
+// BackoffPolicy defines how the client waits between failed attempts to connect
+// to the Server (WebSocket transport) or to send a request (plain HTTP transport).
+// Zero values of the fields mean that the default value is used.
+type BackoffPolicy struct {
+	// InitialInterval is the interval to wait after the first failed attempt.
+	// Default is 500ms.
+	InitialInterval time.Duration
+
+	// MaxInterval is the upper bound of the interval between the attempts.
+	// Default is 60s.
+	MaxInterval time.Duration
+
+	// Multiplier is the factor by which the interval grows after every failed
+	// attempt. Default is 1.5.
+	Multiplier float64
+
+	// FullJitter, if true, makes the client wait for a random duration between 0
+	// and the calculated interval (exclusive) instead of the interval +/-50%. This spreads
+	// the reconnection attempts of large fleets of Agents more evenly after
+	// a Server restart.
+	FullJitter bool
+
+	// MaxAttempts is the number of consecutive failed attempts after which the client
+	// gives up and reports ErrMaxAttemptsReached via OnConnectFailed callback.
+	// 0 means the client never gives up.
+	MaxAttempts int
+
+	// ResetAfter is the minimum duration a WebSocket connection must stay healthy
+	// for the backoff to be reset to InitialInterval after the connection is lost.
+	// If the connection is lost earlier the client continues to back off as if the
+	// connection attempt failed. 0 means that the backoff is reset after every
+	// successful connection.
+	ResetAfter time.Duration
+}
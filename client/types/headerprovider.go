@@ -0,0 +1,33 @@
+package types
+
+import (
+	"context"
+	"net/http"
+)
+
+// HeaderProvider supplies HTTP headers, typically short-lived credentials such as
+// bearer tokens, that must be refreshed during the lifetime of the client.
+type HeaderProvider interface {
+	// Header is called before every WebSocket dial and before every plain HTTP
+	// request. The returned headers are added on top of the static headers defined
+	// in StartSettings and may be overridden by the headers offered by the Server
+	// in OpAMPConnectionSettings.
+	//
+	// forceRefresh is true if the Server rejected the previous request with
+	// 401 or 403 status. In that case the provider should not return cached
+	// credentials and must obtain new ones.
+	//
+	// If Header returns an error the attempt to connect or to send the request is
+	// considered failed and will be retried according to the BackoffPolicy.
+	Header(ctx context.Context, forceRefresh bool) (http.Header, error)
+}
+
+// HeaderProviderFunc is an adapter to allow the use of ordinary functions
+// as HeaderProvider.
+type HeaderProviderFunc func(ctx context.Context, forceRefresh bool) (http.Header, error)
+
+var _ HeaderProvider = HeaderProviderFunc(nil)
+
+func (f HeaderProviderFunc) Header(ctx context.Context, forceRefresh bool) (http.Header, error) {
+	return f(ctx, forceRefresh)
+}
@@ -0,0 +1,22 @@
+package types
+
+import (
+	"context"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+// Interceptor observes or modifies the messages exchanged with the Server, e.g.
+// for audit logging, adding custom attributes, collecting metrics or injecting
+// faults in tests. Both funcs are optional. See StartSettings.Interceptors.
+type Interceptor struct {
+	// OnSend is called before the message is sent to the Server and may modify
+	// the message. If OnSend returns an error the message is dropped and the
+	// error is logged.
+	OnSend func(ctx context.Context, msg *protobufs.AgentToServer) error
+
+	// OnReceive is called when a message is received from the Server before it is
+	// processed and may modify the message. If OnReceive returns an error the
+	// message is dropped and the error is logged.
+	OnReceive func(ctx context.Context, msg *protobufs.ServerToAgent) error
+}
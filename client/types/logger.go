@@ -1,6 +1,125 @@
 package types
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Logger is the logger of the client and the Server. If the Logger also
+// implements LeveledLogger the records are logged using Log, otherwise the
+// records are formatted and logged using Debugf and Errorf, see ToLeveled.
 type Logger interface {
 	Debugf(format string, v ...interface{})
 	Errorf(format string, v ...interface{})
 }
+
+// Level is the severity of a log record.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return "LEVEL(" + strconv.Itoa(int(l)) + ")"
+}
+
+// Field is a key-value pair that describes a log record, e.g. the error or
+// the instance UID of the Agent.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F returns a Field with the key and the value.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// LeveledLogger is a Logger that logs structured records with levels.
+// The secrets in the Protobuf messages passed as the values of the fields,
+// such as the values of the Headers and the private keys of the TLS
+// certificates, are redacted by the client and the Server.
+type LeveledLogger interface {
+	Logger
+
+	// Enabled returns true if the records of the level are logged. The client and
+	// the Server skip preparing the records that would be discarded.
+	Enabled(level Level) bool
+
+	// Log logs the record.
+	Log(level Level, msg string, fields ...Field)
+}
+
+// ToLeveled returns the logger as a LeveledLogger. If the logger does not
+// implement LeveledLogger the records of LevelDebug and LevelInfo are logged using
+// Debugf and the records of LevelWarn and LevelError using Errorf, the fields
+// are appended to the message, e.g. "Cannot send error=EOF".
+func ToLeveled(logger Logger) LeveledLogger {
+	if leveled, ok := logger.(LeveledLogger); ok {
+		return leveled
+	}
+	return leveledLogger{logger}
+}
+
+// leveledLogger adapts a Logger to the LeveledLogger interface.
+type leveledLogger struct {
+	Logger
+}
+
+func (l leveledLogger) Enabled(level Level) bool {
+	return true
+}
+
+func (l leveledLogger) Log(level Level, msg string, fields ...Field) {
+	if level >= LevelWarn {
+		l.Errorf("%s", FormatRecord(msg, fields...))
+	} else {
+		l.Debugf("%s", FormatRecord(msg, fields...))
+	}
+}
+
+// FormatRecord formats the message and the fields as a single line, e.g.
+// `Cannot send message error="connection reset"`. The values that contain
+// spaces, quotes or equal signs are quoted.
+func FormatRecord(msg string, fields ...Field) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, field := range fields {
+		b.WriteString(" ")
+		b.WriteString(field.Key)
+		b.WriteString("=")
+		b.WriteString(formatValue(field.Value))
+	}
+	return b.String()
+}
+
+func formatValue(value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	default:
+		s = fmt.Sprint(v)
+	}
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,22 @@
+package types
+
+// ProxySettings defines the proxy to use for the connections to the Server and
+// for the package downloads.
+type ProxySettings struct {
+	// URL of the proxy. MUST be set. Supported schemes are "http" for HTTP proxies
+	// (using CONNECT method for WebSocket and HTTPS connections) and "socks5" for
+	// SOCKS5 proxies, e.g. "http://proxy.example.com:3128".
+	URL string
+
+	// Optional credentials to authenticate to the proxy. HTTP proxies receive the
+	// credentials using Basic authentication, SOCKS5 proxies using username/password
+	// authentication. If set, the credentials override the user info of the URL.
+	Username string
+	Password string
+
+	// NoProxy is an optional list of hosts that must be connected to directly,
+	// bypassing the proxy. Each entry is either a host name, which also matches
+	// all its subdomains, an IP address, a CIDR range (e.g. "10.0.0.0/8") or
+	// "*" which matches all hosts.
+	NoProxy []string
+}
@@ -1,24 +1,99 @@
 package types
 
 import (
+	"context"
 	"crypto/tls"
+	"net"
 	"net/http"
+	"time"
 
+	"github.com/open-telemetry/opamp-go/instrumentation"
 	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/tracecontext"
 )
 
 type StartSettings struct {
 	// Connection parameters.
 
-	// Server URL. MUST be set.
+	// Server URL. MUST be set unless Endpoints is set.
+	// Use "unix" scheme to connect via a Unix domain socket, e.g.
+	// "unix:///run/opamp.sock". By default, the requests are sent to "/v1/opamp"
+	// path over the socket, a different path can be specified in the "path" query
+	// parameter, e.g. "unix:///run/opamp.sock?path=/opamp".
 	OpAMPServerURL string
 
+	// Endpoints is an optional ordered list of Server endpoints to use instead of
+	// OpAMPServerURL. The first endpoint is the primary. When the connection to the
+	// active endpoint fails the client rotates to the next endpoint in the list,
+	// waiting between the attempts according to the BackoffPolicy.
+	// OpAMPServerURL must be empty if Endpoints is set.
+	Endpoints []Endpoint
+
+	// PrimaryProbeInterval defines how often the client checks if the primary
+	// endpoint is reachable again while it is using one of the other Endpoints.
+	// If the primary is reachable the client switches back to it.
+	// 0 means the default interval of 5 minutes. Negative value disables probing.
+	PrimaryProbeInterval time.Duration
+
 	// Optional additional HTTP headers to send with all HTTP requests.
 	Header http.Header
 
+	// Optional provider of dynamic HTTP headers, e.g. short-lived credentials.
+	// Called before every WebSocket dial and every plain HTTP request.
+	// See HeaderProvider for details.
+	HeaderProvider HeaderProvider
+
 	// Optional TLS config for HTTP connection.
 	TLSConfig *tls.Config
 
+	// Optional function to use for establishing network connections to the Server,
+	// e.g. to connect via a custom proxy or to use in-memory connections in tests.
+	// If nil the connections are established using net.Dialer.
+	NetDialContext NetDialContextFunc
+
+	// Optional proxy to use for the connections to the Server and for the package
+	// downloads. If nil the proxy is selected using HTTP_PROXY, HTTPS_PROXY and
+	// NO_PROXY environment variables.
+	Proxy *ProxySettings
+
+	// LongPollTimeout enables long-polling when using plain HTTP transport. If
+	// positive, in addition to the regular requests the client keeps a request
+	// outstanding that the Server may hold for up to LongPollTimeout until it has
+	// a message to send to the Agent. This allows the Server to push messages
+	// without waiting for the next poll. If the long-poll requests keep failing
+	// until the BackoffPolicy gives up, the failure is reported via OnConnectFailed
+	// and the client continues with the regular requests only. Ignored when using
+	// WebSocket transport.
+	LongPollTimeout time.Duration
+
+	// BackoffPolicy defines how the client retries failed connection attempts
+	// and requests. If not set the default policy is used, which retries forever.
+	BackoffPolicy BackoffPolicy
+
+	// AutoTransport defines when the client created by client.NewAuto falls back
+	// from WebSocket to plain HTTP transport and back. Ignored by the other clients.
+	AutoTransport AutoTransportSettings
+
+	// Interceptors optionally observe or modify the messages sent to and received
+	// from the Server. The interceptors are called in order.
+	Interceptors []Interceptor
+
+	// Meter optionally receives the telemetry of the client internals, see the
+	// instrumentation package. nil means the telemetry is discarded.
+	Meter instrumentation.Meter
+
+	// TraceContext optionally returns the W3C trace context to send to the Server
+	// in the traceparent and tracestate headers. Called before every WebSocket
+	// dial and every plain HTTP request, ctx is the context of the dial or the
+	// request. nil means no trace context is sent.
+	TraceContext tracecontext.ProviderFunc
+
+	// StartSpan is optionally called to start a span around the processing of
+	// every message received from the Server. The context of the span is passed
+	// to the Callbacks. The parent is the trace context the Server sent in the
+	// headers of the plain HTTP response, if any.
+	StartSpan tracecontext.StartSpanFunc
+
 	// Agent information.
 	InstanceUid string
 
@@ -42,3 +117,22 @@ type StartSettings struct {
 	// i.e. package status reporting and syncing from the Server will be disabled.
 	PackagesStateProvider PackagesStateProvider
 }
+
+// NetDialContextFunc establishes a network connection. It has the same signature
+// as net.Dialer.DialContext.
+type NetDialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Endpoint is an OpAMP Server endpoint that the client can connect to.
+type Endpoint struct {
+	// Server URL. MUST be set. See StartSettings.OpAMPServerURL for the supported
+	// URL formats.
+	URL string
+
+	// Optional additional HTTP headers to send with all HTTP requests to this
+	// endpoint. The headers are added on top of the StartSettings.Header.
+	Header http.Header
+
+	// Optional TLS config for HTTP connection to this endpoint. If nil the
+	// StartSettings.TLSConfig is used.
+	TLSConfig *tls.Config
+}
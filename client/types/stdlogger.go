@@ -0,0 +1,46 @@
+package types
+
+import (
+	"fmt"
+	"log"
+)
+
+// StdLogger is a LeveledLogger that writes the records to a log.Logger of the
+// standard library, one line per record, e.g.
+// `2024/01/02 15:04:05 ERROR Cannot send error=EOF`.
+type StdLogger struct {
+	logger   *log.Logger
+	minLevel Level
+}
+
+var _ LeveledLogger = (*StdLogger)(nil)
+
+// NewStdLogger returns a StdLogger that writes the records of minLevel and above
+// to the logger. nil logger means log.Default().
+func NewStdLogger(logger *log.Logger, minLevel Level) *StdLogger {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &StdLogger{logger: logger, minLevel: minLevel}
+}
+
+func (l *StdLogger) Enabled(level Level) bool {
+	return level >= l.minLevel
+}
+
+func (l *StdLogger) Log(level Level, msg string, fields ...Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	l.logger.Print(level.String() + " " + FormatRecord(msg, fields...))
+}
+
+// Debugf logs the formatted message with LevelDebug.
+func (l *StdLogger) Debugf(format string, v ...interface{}) {
+	l.Log(LevelDebug, fmt.Sprintf(format, v...))
+}
+
+// Errorf logs the formatted message with LevelError.
+func (l *StdLogger) Errorf(format string, v ...interface{}) {
+	l.Log(LevelError, fmt.Sprintf(format, v...))
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"net/http"
-	"net/url"
 	"sync"
 	"time"
 
@@ -17,62 +16,84 @@ import (
 	"github.com/open-telemetry/opamp-go/protobufs"
 )
 
+// errConnectAborted is returned by ensureConnected when wsClient.abortConnect
+// requested to stop trying to connect.
+var errConnectAborted = errors.New("connecting aborted")
+
 // wsClient is an OpAMP Client implementation for WebSocket transport.
 // See specification: https://github.com/open-telemetry/opamp-spec/blob/main/specification.md#websocket-transport
 type wsClient struct {
 	common internal.ClientCommon
 
-	// OpAMP Server URL.
-	url *url.URL
-
-	// HTTP request headers to use when connecting to OpAMP Server.
-	requestHeader http.Header
-
-	// Websocket dialer and connection.
-	dialer    websocket.Dialer
+	// Websocket dialers, one per endpoint, and the connection.
+	dialers   []websocket.Dialer
 	conn      *websocket.Conn
 	connMutex sync.RWMutex
 
+	// Backoff policy and the state of the backoff between the connection attempts.
+	// The backoff state is preserved across reconnections unless the previous
+	// connection stayed healthy for at least backoffPolicy.ResetAfter.
+	backoffPolicy types.BackoffPolicy
+	backoff       backoff.BackOff
+
+	// The time when the last successful connection was established. Zero if
+	// never connected.
+	connectedAt time.Time
+
+	// The number of consecutive failed connection attempts.
+	failedAttempts int
+
+	// Optional func that is called after every failed connection attempt with
+	// the Server response, if any. If it returns true the client stops trying
+	// to connect and runOneCycle returns errConnectAborted.
+	abortConnect func(resp *http.Response, failedAttempts int) bool
+
 	// The sender is responsible for sending portion of the OpAMP protocol.
 	sender *internal.WSSender
 }
 
 func NewWebSocket(logger types.Logger) *wsClient {
-	if logger == nil {
-		logger = &sharedinternal.NopLogger{}
-	}
+	internalLogger := sharedinternal.NewLogger(logger)
+	sender := internal.NewSender(internalLogger)
+	return newWebSocket(internalLogger, sender, sender)
+}
 
-	sender := internal.NewSender(logger)
-	w := &wsClient{
-		common: internal.NewClientCommon(logger, sender),
-		sender: sender,
+// newWebSocket creates a wsClient that sends the messages using wsSender.
+// commonSender is the sender used by the ClientCommon, it must either be
+// wsSender or share the NextMessage with wsSender.
+func newWebSocket(logger *sharedinternal.Logger, wsSender *internal.WSSender, commonSender internal.Sender) *wsClient {
+	return &wsClient{
+		common: internal.NewClientCommon(logger, commonSender),
+		sender: wsSender,
 	}
-	return w
 }
 
 func (c *wsClient) Start(ctx context.Context, settings types.StartSettings) error {
-	if err := c.common.PrepareStart(ctx, settings); err != nil {
+	if err := c.prepareStart(ctx, settings); err != nil {
 		return err
 	}
 
-	// Prepare connection settings.
-	c.dialer = *websocket.DefaultDialer
+	c.common.StartConnectAndRun(c.runUntilStopped)
+	c.common.Logger.Debug("Starting OpAMP WebSocket client...")
 
-	var err error
-	c.url, err = url.Parse(settings.OpAMPServerURL)
-	if err != nil {
+	return nil
+}
+
+func (c *wsClient) prepareStart(ctx context.Context, settings types.StartSettings) error {
+	if err := c.common.PrepareStart(ctx, settings); err != nil {
 		return err
 	}
 
-	if settings.TLSConfig != nil {
-		c.url.Scheme = "wss"
+	// Prepare connection settings. The URL, headers and TLS config are defined
+	// per endpoint, see dial().
+	endpoints := c.common.Endpoints.All()
+	c.dialers = make([]websocket.Dialer, len(endpoints))
+	for i, endpoint := range endpoints {
+		c.dialers[i] = c.common.Network.WebSocketDialer(endpoint.TLSConfig, c.common.Endpoints.UnixSocket(i))
 	}
-	c.dialer.TLSClientConfig = settings.TLSConfig
-
-	c.requestHeader = settings.Header
 
-	c.common.StartConnectAndRun(c.runUntilStopped)
-	c.common.Logger.Debugf("Starting OpAMP WebSocket client...")
+	c.backoffPolicy = settings.BackoffPolicy
+	c.backoff = internal.NewBackOff(c.backoffPolicy)
 
 	return nil
 }
@@ -94,6 +115,10 @@ func (c *wsClient) AgentDescription() *protobufs.AgentDescription {
 	return c.common.AgentDescription()
 }
 
+func (c *wsClient) ActiveEndpoint() types.Endpoint {
+	return c.common.ActiveEndpoint()
+}
+
 func (c *wsClient) SetAgentDescription(descr *protobufs.AgentDescription) error {
 	return c.common.SetAgentDescription(descr)
 }
@@ -114,14 +139,17 @@ func (c *wsClient) SetPackageStatuses(statuses *protobufs.PackageStatuses) error
 // duration to indicate to the caller to retry after the specified time as instructed
 // by the Server.
 func (c *wsClient) tryConnectOnce(ctx context.Context) (err error, retryAfter sharedinternal.OptionalDuration) {
-	var resp *http.Response
-	conn, resp, err := c.dialer.DialContext(ctx, c.url.String(), c.requestHeader)
+	conn, resp, err := c.connect(ctx)
 	if err != nil {
-		if c.common.Callbacks != nil {
-			c.common.Callbacks.OnConnectFailed(err)
+		// Try the next endpoint on the next attempt.
+		c.common.Endpoints.Rotate()
+		c.failedAttempts++
+		if c.abortConnect != nil && c.abortConnect(resp, c.failedAttempts) {
+			c.reportConnectFailed(err)
+			return errConnectAborted, sharedinternal.OptionalDuration{Defined: false}
 		}
 		if resp != nil {
-			c.common.Logger.Errorf("Server responded with status=%v", resp.Status)
+			c.common.Logger.Error("Server rejected the connection", types.F("status", resp.Status))
 			duration := sharedinternal.ExtractRetryAfterHeader(resp)
 			return err, duration
 		}
@@ -129,40 +157,147 @@ func (c *wsClient) tryConnectOnce(ctx context.Context) (err error, retryAfter sh
 	}
 
 	// Successfully connected.
+	c.setConnected(conn)
+
+	return nil, sharedinternal.OptionalDuration{Defined: false}
+}
+
+// connect establishes a WebSocket connection to the active endpoint. Retries once
+// with refreshed headers if the Server rejects the credentials. Records the
+// connection attempt in the metrics.
+func (c *wsClient) connect(ctx context.Context) (*websocket.Conn, *http.Response, error) {
+	_, _, active := c.common.Endpoints.Active()
+	c.common.Metrics.ConnectAttempts.Add(1, internal.AttrTransportWS)
+	conn, resp, err := c.dial(ctx, active, false)
+	if err != nil && internal.IsCredentialsRejected(resp) {
+		c.common.Logger.Debug("Server rejected the credentials, retrying with refreshed headers.", types.F("status", resp.Status))
+		conn, resp, err = c.dial(ctx, active, true)
+	}
+	if err != nil {
+		c.common.Metrics.ConnectFailures.Add(1, internal.AttrTransportWS)
+	}
+	return conn, resp, err
+}
+
+// setConnected makes conn the current connection of the client.
+func (c *wsClient) setConnected(conn *websocket.Conn) {
 	c.connMutex.Lock()
 	c.conn = conn
 	c.connMutex.Unlock()
+	if !c.connectedAt.IsZero() {
+		c.common.Metrics.Reconnects.Add(1, internal.AttrTransportWS)
+	}
+	c.connectedAt = time.Now()
+	c.failedAttempts = 0
 	if c.common.Callbacks != nil {
 		c.common.Callbacks.OnConnect()
 	}
+}
 
-	return nil, sharedinternal.OptionalDuration{Defined: false}
+// dial establishes a WebSocket connection to the endpoint at the specified index.
+// forceRefresh is passed to the HeaderProvider, see HeaderProvider.Header.
+func (c *wsClient) dial(
+	ctx context.Context, index int, forceRefresh bool,
+) (*websocket.Conn, *http.Response, error) {
+	endpoint, endpointURL := c.common.Endpoints.At(index)
+	header, err := c.common.Headers.Build(ctx, endpoint, forceRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	u := *endpointURL
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+	if c.common.Endpoints.UnixSocket(index) != "" {
+		u.Scheme = "ws"
+	}
+	if endpoint.TLSConfig != nil {
+		u.Scheme = "wss"
+	}
+
+	return c.dialers[index].DialContext(ctx, u.String(), header)
 }
 
-// Continuously try until connected. Will return nil when successfully
-// connected. Will return error if it is cancelled via context.
-func (c *wsClient) ensureConnected(ctx context.Context) error {
-	infiniteBackoff := backoff.NewExponentialBackOff()
+// probePrimary periodically checks if the primary endpoint is reachable while
+// the client is connected to one of the other endpoints. Once the primary is
+// reachable the current connection is closed to force reconnecting to the primary.
+// Returns when the primary is reachable or when ctx is cancelled.
+func (c *wsClient) probePrimary(ctx context.Context, conn *websocket.Conn) {
+	ticker := time.NewTicker(c.common.Endpoints.PrimaryProbeInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			probeConn, _, err := c.dial(ctx, 0, false)
+			if err != nil {
+				c.common.Logger.Debug("Primary endpoint is still unreachable", types.F("error", err))
+				continue
+			}
+			_ = probeConn.Close()
+
+			c.common.Logger.Info("Primary endpoint is reachable again, reconnecting to it.")
+			c.common.Endpoints.SelectPrimary()
+			_ = conn.Close()
+			return
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
 
-	// Make ticker run forever.
-	infiniteBackoff.MaxElapsedTime = 0
+// reconnectInterval returns the interval to wait before the first connection
+// attempt after the previous connection was lost, or backoff.Stop if no more
+// attempts are allowed. The backoff is reset if the previous connection stayed
+// healthy for at least backoffPolicy.ResetAfter.
+func (c *wsClient) reconnectInterval() time.Duration {
+	if !c.connectedAt.IsZero() && time.Since(c.connectedAt) < c.backoffPolicy.ResetAfter {
+		// The previous connection did not stay healthy long enough. Continue
+		// backing off as if the previous connection attempt failed.
+		return c.backoff.NextBackOff()
+	}
+	c.backoff.Reset()
+	return 0
+}
 
-	interval := time.Duration(0)
+// Continuously try until connected. Will return nil when successfully
+// connected. Will return error if it is cancelled via context or if the maximum
+// number of attempts defined by the backoff policy is reached.
+func (c *wsClient) ensureConnected(ctx context.Context) error {
+	interval := c.reconnectInterval()
+	if interval == backoff.Stop {
+		return c.reportMaxAttemptsReached(nil)
+	}
 
 	for {
 		timer := time.NewTimer(interval)
-		interval = infiniteBackoff.NextBackOff()
 
 		select {
 		case <-timer.C:
 			{
 				if err, retryAfter := c.tryConnectOnce(ctx); err != nil {
 					if errors.Is(err, context.Canceled) {
-						c.common.Logger.Debugf("Client is stopped, will not try anymore.")
+						c.common.Logger.Debug("Client is stopped, will not try anymore.")
 						return err
-					} else {
-						c.common.Logger.Errorf("Connection failed (%v), will retry.", err)
 					}
+					if errors.Is(err, errConnectAborted) {
+						c.common.Logger.Debug("Connecting is aborted, will not try anymore.")
+						return err
+					}
+
+					interval = c.backoff.NextBackOff()
+					if interval == backoff.Stop {
+						c.common.Logger.Error("Connection failed, will not try anymore.", types.F("error", err))
+						return c.reportMaxAttemptsReached(err)
+					}
+					c.reportConnectFailed(err)
+					c.common.Logger.Warn("Connection failed, will retry.", types.F("error", err))
+
 					// Retry again a bit later.
 
 					if retryAfter.Defined && retryAfter.Duration > interval {
@@ -179,26 +314,53 @@ func (c *wsClient) ensureConnected(ctx context.Context) error {
 			}
 
 		case <-ctx.Done():
-			c.common.Logger.Debugf("Client is stopped, will not try anymore.")
+			c.common.Logger.Debug("Client is stopped, will not try anymore.")
 			timer.Stop()
 			return ctx.Err()
 		}
 	}
 }
 
+// reportConnectFailed reports a failed connection attempt after which the client
+// tries again.
+func (c *wsClient) reportConnectFailed(err error) {
+	if c.common.Callbacks != nil {
+		c.common.Callbacks.OnConnectFailed(err)
+	}
+}
+
+// reportMaxAttemptsReached reports that the client gives up connecting after the
+// last attempt failed with err, or after the connection did not stay healthy if
+// err is nil.
+func (c *wsClient) reportMaxAttemptsReached(err error) error {
+	c.common.Logger.Error("Giving up connecting to the Server.", types.F("attempts", c.backoffPolicy.MaxAttempts))
+	err = internal.MaxAttemptsReachedError(err)
+	c.reportConnectFailed(err)
+	return err
+}
+
 // runOneCycle performs the following actions:
 //   1. connect (try until succeeds).
 //   2. send first status report.
 //   3. receive and process messages until error happens.
 // If it encounters an error it closes the connection and returns.
 // Will stop and return if Stop() is called (ctx is cancelled, isStopping is set).
-func (c *wsClient) runOneCycle(ctx context.Context) {
+// Returns an error if the client must not try to connect anymore.
+func (c *wsClient) runOneCycle(ctx context.Context) error {
 	if err := c.ensureConnected(ctx); err != nil {
-		// Can't connect, so can't move forward. This currently happens when we
-		// are being stopped.
-		return
+		// Can't connect, so can't move forward. This happens when we are being
+		// stopped or when we gave up connecting.
+		return err
 	}
 
+	c.runConnected(ctx)
+	return nil
+}
+
+// runConnected sends the first status report over the connection that is
+// already established and then receives and processes messages until error
+// happens. Closes the connection before returning.
+func (c *wsClient) runConnected(ctx context.Context) {
 	if c.common.IsStopping() {
 		_ = c.conn.Close()
 		return
@@ -207,7 +369,7 @@ func (c *wsClient) runOneCycle(ctx context.Context) {
 	// Prepare the first status report.
 	err := c.common.PrepareFirstMessage(ctx)
 	if err != nil {
-		c.common.Logger.Errorf("cannot prepare the first message:%v", err)
+		c.common.Logger.Error("Cannot prepare the first message", types.F("error", err))
 		return
 	}
 
@@ -217,13 +379,19 @@ func (c *wsClient) runOneCycle(ctx context.Context) {
 	// Connected successfully. Start the sender. This will also send the first
 	// status report.
 	if err := c.sender.Start(procCtx, c.conn); err != nil {
-		c.common.Logger.Errorf("Failed to send first status report: %v", err)
+		c.common.Logger.Error("Failed to send first status report", types.F("error", err))
 		// We could not send the report, the only thing we can do is start over.
 		_ = c.conn.Close()
 		procCancel()
 		return
 	}
 
+	// If we are not connected to the primary endpoint check periodically if we
+	// can return to it.
+	if _, _, active := c.common.Endpoints.Active(); active != 0 && c.common.Endpoints.PrimaryProbeInterval() > 0 {
+		go c.probePrimary(procCtx, c.conn)
+	}
+
 	// First status report sent. Now loop to receive and process messages.
 	r := internal.NewWSReceiver(
 		c.common.Logger,
@@ -232,6 +400,8 @@ func (c *wsClient) runOneCycle(ctx context.Context) {
 		c.sender,
 		&c.common.ClientSyncedState,
 		c.common.PackagesStateProvider,
+		c.common.Headers,
+		c.common.Network.DownloadClient,
 	)
 	r.ReceiverLoop(ctx)
 
@@ -249,12 +419,14 @@ func (c *wsClient) runOneCycle(ctx context.Context) {
 }
 
 func (c *wsClient) runUntilStopped(ctx context.Context) {
-	// Iterates until we detect that the client is stopping.
+	// Iterates until we detect that the client is stopping or gave up connecting.
 	for {
 		if c.common.IsStopping() {
 			return
 		}
 
-		c.runOneCycle(ctx)
+		if err := c.runOneCycle(ctx); errors.Is(err, types.ErrMaxAttemptsReached) {
+			return
+		}
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/open-telemetry/opamp-go/client/internal"
@@ -51,3 +52,42 @@ func TestDisconnectWSByServer(t *testing.T) {
 	err := client.Stop(context.Background())
 	assert.NoError(t, err)
 }
+
+func TestWSClientBackoffResetAfter(t *testing.T) {
+	policy := types.BackoffPolicy{
+		InitialInterval: time.Second,
+		MaxInterval:     time.Hour,
+		Multiplier:      2,
+		ResetAfter:      time.Minute,
+	}
+
+	tests := []struct {
+		name      string
+		connected time.Duration
+		min, max  time.Duration
+	}{
+		// Reconnect immediately, the next failure waits for InitialInterval +/-50%.
+		{name: "healthy", connected: 2 * time.Minute, min: 500 * time.Millisecond, max: 1500 * time.Millisecond},
+		// Continue the sequence 1s, 2s with 4s +/-50%.
+		{name: "short-lived", connected: time.Second, min: 2 * time.Second, max: 6 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &wsClient{backoffPolicy: policy, backoff: internal.NewBackOff(policy)}
+
+			// Two failed attempts before the connection succeeded.
+			c.backoff.NextBackOff()
+			c.backoff.NextBackOff()
+			c.connectedAt = time.Now().Add(-test.connected)
+
+			interval := c.reconnectInterval()
+			if test.connected > policy.ResetAfter {
+				assert.Equal(t, time.Duration(0), interval)
+				interval = c.backoff.NextBackOff()
+			}
+			assert.GreaterOrEqual(t, interval, test.min)
+			assert.LessOrEqual(t, interval, test.max)
+		})
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+
+	"github.com/open-telemetry/opamp-go/client"
+	"github.com/open-telemetry/opamp-go/client/types"
+	"github.com/open-telemetry/opamp-go/internal"
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/recorder"
+)
+
+// agentOptions are the flags of the agent command.
+type agentOptions struct {
+	serverURL            string
+	instanceUid          string
+	identifyingAttrs     attributesFlag
+	attrs                attributesFlag
+	headers              stringsFlag
+	configDir            string
+	effectiveConfigFiles stringsFlag
+	acceptPackages       bool
+	packagesDir          string
+	recordFile           string
+	quiet                bool
+	showSecrets          bool
+	verbose              bool
+}
+
+func runAgent(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `Usage: opampctl agent [flags]
+
+Connects to the Server as a fake Agent and prints the sent and received messages
+as JSON documents until interrupted. Uses the plain HTTP transport if the URL of
+the Server has http or https scheme and WebSocket otherwise.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	var opts agentOptions
+	fs.StringVar(&opts.serverURL, "server", "ws://127.0.0.1:4320/v1/opamp", "URL of the Server")
+	fs.StringVar(&opts.instanceUid, "instance-uid", "", "instance UID of the Agent, a new ULID by default")
+	fs.Var(&opts.identifyingAttrs, "identifying-attr",
+		"identifying attribute of the Agent as key=value, may be repeated (default service.name=opampctl)")
+	fs.Var(&opts.attrs, "attr", "non-identifying attribute of the Agent as key=value, may be repeated")
+	fs.Var(&opts.headers, "header", `HTTP header to send as "Name: value", may be repeated`)
+	fs.StringVar(&opts.configDir, "config-dir", "",
+		"directory to write the received remote configs to, remote configs are not accepted if empty")
+	fs.Var(&opts.effectiveConfigFiles, "effective-config",
+		"file to report as the effective config, may be repeated (default the files of -config-dir)")
+	fs.BoolVar(&opts.acceptPackages, "accept-packages", false,
+		"accept the packages offered by the Server and simulate installing them")
+	fs.StringVar(&opts.packagesDir, "packages-dir", "",
+		"directory to write the content of the installed packages to, implies -accept-packages")
+	fs.StringVar(&opts.recordFile, "record", "", "file to record the messages to, see opampctl decode")
+	fs.BoolVar(&opts.quiet, "quiet", false, "do not print the messages")
+	fs.BoolVar(&opts.showSecrets, "show-secrets", false,
+		"print the secrets, e.g. the values of the Headers, instead of redacting them")
+	fs.BoolVar(&opts.verbose, "v", false, "log the debug messages of the client")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "unexpected arguments: %v\n", fs.Args())
+		fs.Usage()
+		return errUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return runFakeAgent(ctx, &opts, stdout, stderr)
+}
+
+// runFakeAgent runs the fake Agent until the ctx is done.
+func runFakeAgent(ctx context.Context, opts *agentOptions, stdout, stderr io.Writer) error {
+	agent, err := newFakeAgent(opts, stdout, stderr)
+	if err != nil {
+		return err
+	}
+	if err := agent.start(); err != nil {
+		_ = agent.close()
+		return err
+	}
+	<-ctx.Done()
+	return agent.stop()
+}
+
+// fakeAgent is an Agent that applies the remote configs by writing the files to
+// a directory and reports the files as the effective config.
+type fakeAgent struct {
+	opts     *agentOptions
+	logger   *types.StdLogger
+	printer  *printer
+	recorder *recorder.Recorder
+	packages *packagesState
+	client   client.OpAMPClient
+
+	// ctx is cancelled when the agent stops, to stop syncing the packages.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// appliedConfigHash is the hash of the last applied remote config. Accessed
+	// from the OnMessage callback only.
+	appliedConfigHash []byte
+}
+
+func newFakeAgent(opts *agentOptions, stdout, stderr io.Writer) (*fakeAgent, error) {
+	level := types.LevelInfo
+	if opts.verbose {
+		level = types.LevelDebug
+	}
+	agent := &fakeAgent{
+		opts:    opts,
+		logger:  types.NewStdLogger(log.New(stderr, "", log.LstdFlags), level),
+		printer: &printer{w: stdout, showSecrets: opts.showSecrets},
+	}
+	if opts.acceptPackages || opts.packagesDir != "" {
+		agent.packages = newPackagesState(opts.packagesDir, agent.logger)
+	}
+
+	u, err := url.Parse(opts.serverURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "http" || u.Scheme == "https" {
+		agent.client = client.NewHTTP(agent.logger)
+	} else {
+		agent.client = client.NewWebSocket(agent.logger)
+	}
+
+	if opts.recordFile != "" {
+		agent.recorder, err = recorder.Create(opts.recordFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	agent.ctx, agent.cancel = context.WithCancel(context.Background())
+	return agent, nil
+}
+
+func (a *fakeAgent) start() error {
+	description := &protobufs.AgentDescription{
+		IdentifyingAttributes:    a.opts.identifyingAttrs,
+		NonIdentifyingAttributes: a.opts.attrs,
+	}
+	if len(description.IdentifyingAttributes) == 0 {
+		description.IdentifyingAttributes = []*protobufs.KeyValue{stringKeyValue("service.name", "opampctl")}
+	}
+	if err := a.client.SetAgentDescription(description); err != nil {
+		return err
+	}
+
+	header, err := parseHeaders(a.opts.headers)
+	if err != nil {
+		return err
+	}
+	instanceUid := a.opts.instanceUid
+	if instanceUid == "" {
+		instanceUid = ulid.MustNew(ulid.Timestamp(time.Now()), rand.Reader).String()
+	}
+
+	settings := types.StartSettings{
+		OpAMPServerURL: a.opts.serverURL,
+		Header:         header,
+		InstanceUid:    instanceUid,
+		Callbacks: types.CallbacksStruct{
+			OnConnectFunc: func() {
+				a.logger.Log(types.LevelInfo, "Connected to the Server", types.F("instance_uid", instanceUid))
+			},
+			OnConnectFailedFunc: func(err error) {
+				a.logger.Log(types.LevelWarn, "Cannot connect to the Server", types.F("error", err))
+			},
+			OnErrorFunc: func(err *protobufs.ServerErrorResponse) {
+				a.logger.Log(types.LevelError, "Server returned an error", types.F("error", err.ErrorMessage))
+			},
+			OnMessageFunc:          a.onMessage,
+			GetEffectiveConfigFunc: a.effectiveConfig,
+		},
+	}
+	if !a.opts.quiet {
+		settings.Interceptors = append(settings.Interceptors, a.printInterceptor())
+	}
+	if a.recorder != nil {
+		settings.Interceptors = append(settings.Interceptors, a.recorder.ClientInterceptor())
+	}
+	if a.packages != nil {
+		settings.PackagesStateProvider = a.packages
+	}
+	return a.client.Start(context.Background(), settings)
+}
+
+func (a *fakeAgent) stop() error {
+	err := a.client.Stop(context.Background())
+	if closeErr := a.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// close stops syncing the packages and closes the recording, if any.
+func (a *fakeAgent) close() error {
+	a.cancel()
+	if a.recorder == nil {
+		return nil
+	}
+	return a.recorder.Close()
+}
+
+// printInterceptor returns the Interceptor that prints the messages.
+func (a *fakeAgent) printInterceptor() types.Interceptor {
+	printRecord := func(record recorder.Record) {
+		record.Time = time.Now()
+		if err := a.printer.printRecord(&record); err != nil {
+			a.logger.Log(types.LevelError, "Cannot print message", types.F("error", err))
+		}
+	}
+	return types.Interceptor{
+		OnSend: func(ctx context.Context, msg *protobufs.AgentToServer) error {
+			printRecord(recorder.Record{Direction: recorder.DirectionAgentToServer, AgentToServer: msg})
+			return nil
+		},
+		OnReceive: func(ctx context.Context, msg *protobufs.ServerToAgent) error {
+			printRecord(recorder.Record{Direction: recorder.DirectionServerToAgent, ServerToAgent: msg})
+			return nil
+		},
+	}
+}
+
+func (a *fakeAgent) onMessage(ctx context.Context, msg *types.MessageData) {
+	if msg.RemoteConfig != nil && a.opts.configDir != "" {
+		a.applyRemoteConfig(ctx, msg.RemoteConfig)
+	}
+	if msg.PackageSyncer != nil && a.packages != nil {
+		if err := msg.PackageSyncer.Sync(a.ctx); err != nil {
+			a.logger.Log(types.LevelError, "Cannot sync packages", types.F("error", err))
+		}
+	}
+	if msg.AgentIdentification != nil {
+		a.logger.Log(types.LevelInfo, "Server assigned a new instance UID",
+			types.F("instance_uid", msg.AgentIdentification.NewInstanceUid))
+	}
+}
+
+// applyRemoteConfig writes the files of the config to the config dir and
+// reports the status of the config and the new effective config. The config
+// that is already applied is ignored.
+func (a *fakeAgent) applyRemoteConfig(ctx context.Context, config *protobufs.AgentRemoteConfig) {
+	if a.appliedConfigHash != nil && bytes.Equal(a.appliedConfigHash, config.ConfigHash) {
+		return
+	}
+
+	status := &protobufs.RemoteConfigStatus{
+		LastRemoteConfigHash: config.ConfigHash,
+		Status:               protobufs.RemoteConfigStatus_APPLIED,
+	}
+	if err := writeConfigFiles(a.opts.configDir, config.Config); err != nil {
+		a.logger.Log(types.LevelError, "Cannot apply remote config", types.F("error", err))
+		status.Status = protobufs.RemoteConfigStatus_FAILED
+		status.ErrorMessage = err.Error()
+	} else {
+		a.appliedConfigHash = config.ConfigHash
+		a.logger.Log(types.LevelInfo, "Applied remote config", types.F("dir", a.opts.configDir))
+	}
+
+	if err := a.client.SetRemoteConfigStatus(status); err != nil {
+		a.logger.Log(types.LevelError, "Cannot report remote config status", types.F("error", err))
+	}
+	if status.Status == protobufs.RemoteConfigStatus_APPLIED {
+		if err := a.client.UpdateEffectiveConfig(ctx); err != nil {
+			a.logger.Log(types.LevelError, "Cannot report effective config", types.F("error", err))
+		}
+	}
+}
+
+// unnamedConfigFile is the name of the file the unnamed config file, i.e. the
+// one with the empty key, is written to.
+const unnamedConfigFile = "config"
+
+// writeConfigFiles writes the files of the config map to the dir, creating the
+// dir if necessary.
+func writeConfigFiles(dir string, configMap *protobufs.AgentConfigMap) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	for name, file := range configMap.GetConfigMap() {
+		if name == "" {
+			name = unnamedConfigFile
+		}
+		if name != filepath.Base(name) || name == "." || name == ".." {
+			return fmt.Errorf("invalid config file name %q", name)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), file.Body, 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// effectiveConfig returns the content of the effective config files or, if
+// none is specified, of the files in the config dir.
+func (a *fakeAgent) effectiveConfig(ctx context.Context) (*protobufs.EffectiveConfig, error) {
+	files := a.opts.effectiveConfigFiles
+	if len(files) == 0 && a.opts.configDir != "" {
+		entries, err := os.ReadDir(a.opts.configDir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				files = append(files, filepath.Join(a.opts.configDir, entry.Name()))
+			}
+		}
+	}
+
+	configMap := &protobufs.AgentConfigMap{ConfigMap: map[string]*protobufs.AgentConfigFile{}}
+	for _, file := range files {
+		body, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		configMap.ConfigMap[filepath.Base(file)] = &protobufs.AgentConfigFile{
+			Body:        body,
+			ContentType: contentType(file),
+		}
+	}
+	return &protobufs.EffectiveConfig{ConfigMap: configMap}, nil
+}
+
+// contentType returns the MIME type of the config file by its extension.
+func contentType(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return "text/yaml"
+	case ".json":
+		return "application/json"
+	}
+	return ""
+}
+
+// parseHeaders parses the headers specified as "Name: value".
+func parseHeaders(headers []string) (http.Header, error) {
+	header := http.Header{}
+	for _, h := range headers {
+		name, value, ok := internal.Cut(h, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid header %q, must be \"Name: value\"", h)
+		}
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return header, nil
+}
+
+func stringKeyValue(key, value string) *protobufs.KeyValue {
+	return &protobufs.KeyValue{
+		Key:   key,
+		Value: &protobufs.AnyValue{Value: &protobufs.AnyValue_StringValue{StringValue: value}},
+	}
+}
+
+// attributesFlag is a repeatable flag of key=value attributes.
+type attributesFlag []*protobufs.KeyValue
+
+func (f *attributesFlag) String() string {
+	attrs := make([]string, len(*f))
+	for i, attr := range *f {
+		attrs[i] = attr.Key + "=" + attr.Value.GetStringValue()
+	}
+	sort.Strings(attrs)
+	return strings.Join(attrs, ",")
+}
+
+func (f *attributesFlag) Set(value string) error {
+	key, val, ok := internal.Cut(value, "=")
+	if !ok || key == "" {
+		return errors.New("must be key=value")
+	}
+	*f = append(*f, stringKeyValue(key, val))
+	return nil
+}
+
+// stringsFlag is a repeatable string flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
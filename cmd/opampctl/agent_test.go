@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/open-telemetry/opamp-go/client/types"
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/recorder"
+	"github.com/open-telemetry/opamp-go/server"
+	servertypes "github.com/open-telemetry/opamp-go/server/types"
+)
+
+func eventually(t *testing.T, f func() bool) {
+	assert.Eventually(t, f, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestAgent(t *testing.T) {
+	remoteConfig := &protobufs.AgentRemoteConfig{
+		Config: &protobufs.AgentConfigMap{
+			ConfigMap: map[string]*protobufs.AgentConfigFile{
+				"collector.yaml": {Body: []byte("receivers: {}")},
+			},
+		},
+		ConfigHash: []byte{1, 2, 3},
+	}
+
+	// Start a Server that offers the remote config and remembers the reports.
+	var description, effectiveConfig, remoteConfigStatus atomic.Value
+	var offers int64
+	srv := server.New(nil)
+	handler, err := srv.Attach(server.Settings{
+		Callbacks: server.CallbacksStruct{
+			OnMessageFunc: func(conn servertypes.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+				// The unchanged fields carry only the hash.
+				if message.AgentDescription.GetIdentifyingAttributes() != nil {
+					description.Store(message.AgentDescription)
+				}
+				if message.EffectiveConfig.GetConfigMap() != nil {
+					effectiveConfig.Store(message.EffectiveConfig)
+				}
+				if message.RemoteConfigStatus.GetLastRemoteConfigHash() != nil {
+					remoteConfigStatus.Store(message.RemoteConfigStatus)
+				}
+				// Offer the remote config twice, the Agent applies it once.
+				if atomic.AddInt64(&offers, 1) > 2 {
+					return &protobufs.ServerToAgent{InstanceUid: message.InstanceUid}
+				}
+				return &protobufs.ServerToAgent{InstanceUid: message.InstanceUid, RemoteConfig: remoteConfig}
+			},
+		},
+	})
+	require.NoError(t, err)
+	httpServer := httptest.NewServer(http.HandlerFunc(handler))
+	defer httpServer.Close()
+	defer srv.Stop(context.Background())
+
+	dir := t.TempDir()
+	opts := &agentOptions{
+		serverURL:   "ws" + strings.TrimPrefix(httpServer.URL, "http"),
+		instanceUid: "01G2DMN5SM2HFEG2SEPSBN4PBQ",
+		configDir:   filepath.Join(dir, "config"),
+		recordFile:  filepath.Join(dir, "recording"),
+	}
+	require.NoError(t, opts.identifyingAttrs.Set("service.name=test"))
+	require.NoError(t, opts.attrs.Set("host.name=localhost"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var stdout, stderr bytes.Buffer
+	done := make(chan error)
+	go func() { done <- runFakeAgent(ctx, opts, &stdout, &stderr) }()
+
+	// The Agent applies the remote config and reports it as the effective config.
+	eventually(t, func() bool {
+		status, ok := remoteConfigStatus.Load().(*protobufs.RemoteConfigStatus)
+		return ok && status.Status == protobufs.RemoteConfigStatus_APPLIED
+	})
+	eventually(t, func() bool {
+		config, ok := effectiveConfig.Load().(*protobufs.EffectiveConfig)
+		if !ok {
+			return false
+		}
+		file := config.ConfigMap.ConfigMap["collector.yaml"]
+		return file != nil && string(file.Body) == "receivers: {}" && file.ContentType == "text/yaml"
+	})
+	cancel()
+	require.NoError(t, <-done)
+
+	body, err := os.ReadFile(filepath.Join(opts.configDir, "collector.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "receivers: {}", string(body))
+
+	agentDescription := description.Load().(*protobufs.AgentDescription)
+	assert.Equal(t, "service.name=test", (*attributesFlag)(&agentDescription.IdentifyingAttributes).String())
+	assert.Equal(t, "host.name=localhost", (*attributesFlag)(&agentDescription.NonIdentifyingAttributes).String())
+
+	// The messages are printed and recorded.
+	assert.Contains(t, stdout.String(), `"direction": "AgentToServer"`)
+	assert.Contains(t, stdout.String(), `"direction": "ServerToAgent"`)
+	assert.Equal(t, 1, strings.Count(stderr.String(), "INFO Applied remote config"))
+	records, err := recorder.ReadFile(opts.recordFile)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, len(records), 2)
+}
+
+func TestWriteConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	err := writeConfigFiles(dir, &protobufs.AgentConfigMap{
+		ConfigMap: map[string]*protobufs.AgentConfigFile{"": {Body: []byte("unnamed")}},
+	})
+	require.NoError(t, err)
+	body, err := os.ReadFile(filepath.Join(dir, unnamedConfigFile))
+	require.NoError(t, err)
+	assert.Equal(t, "unnamed", string(body))
+
+	for _, name := range []string{"../escape", "sub/file", ".."} {
+		err := writeConfigFiles(dir, &protobufs.AgentConfigMap{
+			ConfigMap: map[string]*protobufs.AgentConfigFile{name: {}},
+		})
+		assert.Error(t, err, name)
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	header, err := parseHeaders([]string{"Authorization: Bearer token", "X-Test:a:b"})
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer token", header.Get("Authorization"))
+	assert.Equal(t, "a:b", header.Get("X-Test"))
+
+	_, err = parseHeaders([]string{"invalid"})
+	assert.Error(t, err)
+}
+
+func TestPackagesState(t *testing.T) {
+	dir := t.TempDir()
+	var logs bytes.Buffer
+	state := newPackagesState(dir, types.NewStdLogger(log.New(&logs, "", 0), types.LevelInfo))
+
+	require.NoError(t, state.CreatePackage("plugin", protobufs.PackageAvailable_AddonPackage))
+	assert.Error(t, state.CreatePackage("plugin", protobufs.PackageAvailable_AddonPackage))
+	require.NoError(t, state.UpdateContent(context.Background(), "plugin", strings.NewReader("content"), []byte{1}))
+	require.NoError(t, state.SetPackageState("plugin", types.PackageState{
+		Exists: true, Type: protobufs.PackageAvailable_AddonPackage, Version: "1.0",
+	}))
+
+	names, err := state.Packages()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"plugin"}, names)
+	hash, err := state.FileContentHash("plugin")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1}, hash)
+	body, err := os.ReadFile(filepath.Join(dir, "plugin"))
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(body))
+	assert.Contains(t, logs.String(), "INFO Installed package name=plugin version=1.0")
+
+	require.NoError(t, state.DeletePackage("plugin"))
+	_, err = os.Stat(filepath.Join(dir, "plugin"))
+	assert.True(t, os.IsNotExist(err))
+	pkg, err := state.PackageState("plugin")
+	require.NoError(t, err)
+	assert.False(t, pkg.Exists)
+
+	assert.Error(t, state.UpdateContent(context.Background(), "../plugin", strings.NewReader(""), nil))
+}
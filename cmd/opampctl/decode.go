@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/recorder"
+)
+
+// The types of the input of the decode command.
+const (
+	inputRecording     = "recording"
+	inputAgentToServer = "agent-to-server"
+	inputServerToAgent = "server-to-agent"
+)
+
+// The encodings of the input of the decode command.
+const (
+	encodingBinary = "binary"
+	encodingHex    = "hex"
+	encodingBase64 = "base64"
+)
+
+func runDecode(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("decode", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `Usage: opampctl decode [flags] [file ...]
+
+Prints the OpAMP messages of the files as JSON documents. Reads the standard
+input if no file or "-" is specified. The input is either a recording written
+by the recorder package or the Protobuf payload of a single message, e.g. the
+body of a plain HTTP request captured by a proxy.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	typ := fs.String("type", inputRecording,
+		"type of the input: "+inputRecording+", "+inputAgentToServer+" or "+inputServerToAgent)
+	encoding := fs.String("encoding", encodingBinary,
+		"encoding of the input: "+encodingBinary+", "+encodingHex+" or "+encodingBase64)
+	showSecrets := fs.Bool("show-secrets", false,
+		"print the secrets, e.g. the values of the Headers, instead of redacting them")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	switch *typ {
+	case inputRecording, inputAgentToServer, inputServerToAgent:
+	default:
+		return fmt.Errorf("unknown input type %q", *typ)
+	}
+	switch *encoding {
+	case encodingBinary, encodingHex, encodingBase64:
+	default:
+		return fmt.Errorf("unknown encoding %q", *encoding)
+	}
+
+	p := &printer{w: stdout, showSecrets: *showSecrets}
+	names := fs.Args()
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	for _, name := range names {
+		data, err := readInput(name, stdin)
+		if err == nil {
+			data, err = decodeEncoding(data, *encoding)
+		}
+		if err == nil {
+			err = decodeMessages(p, data, *typ)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// readInput returns the content of the named file or of stdin if the name is "-".
+func readInput(name string, stdin io.Reader) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(name)
+}
+
+// decodeEncoding returns the binary data of the input. The whitespace, e.g. the
+// line breaks, of the hex and base64 inputs is ignored.
+func decodeEncoding(data []byte, encoding string) ([]byte, error) {
+	text := strings.Join(strings.Fields(string(data)), "")
+	switch encoding {
+	case encodingHex:
+		return hex.DecodeString(text)
+	case encodingBase64:
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return data, nil
+}
+
+// decodeMessages prints the messages of the data of the type.
+func decodeMessages(p *printer, data []byte, typ string) error {
+	switch typ {
+	case inputAgentToServer:
+		var msg protobufs.AgentToServer
+		if err := proto.Unmarshal(data, &msg); err != nil {
+			return err
+		}
+		return p.printMessage(&msg)
+
+	case inputServerToAgent:
+		var msg protobufs.ServerToAgent
+		if err := proto.Unmarshal(data, &msg); err != nil {
+			return err
+		}
+		return p.printMessage(&msg)
+	}
+
+	// Print the records read before the recording turns out to be truncated or
+	// corrupted.
+	reader := recorder.NewReader(bytes.NewReader(data))
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := p.printRecord(record); err != nil {
+			return err
+		}
+	}
+}
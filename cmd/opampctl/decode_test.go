@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/recorder"
+)
+
+func connectionSettingsOffer() *protobufs.ServerToAgent {
+	return &protobufs.ServerToAgent{
+		InstanceUid: "12345678",
+		ConnectionSettings: &protobufs.ConnectionSettingsOffers{
+			Opamp: &protobufs.OpAMPConnectionSettings{
+				DestinationEndpoint: "wss://example.com/v1/opamp",
+				Headers: &protobufs.Headers{
+					Headers: []*protobufs.Header{{Key: "Authorization", Value: "Bearer secret-token"}},
+				},
+			},
+		},
+	}
+}
+
+func runCommand(t *testing.T, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestDecodeRecording(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "recording")
+	rec, err := recorder.Create(name)
+	require.NoError(t, err)
+	require.NoError(t, rec.Record(recorder.Record{
+		Time:          time.Date(2022, 2, 3, 4, 5, 6, 0, time.UTC),
+		Direction:     recorder.DirectionAgentToServer,
+		AgentToServer: &protobufs.AgentToServer{InstanceUid: "12345678"},
+	}))
+	require.NoError(t, rec.RecordServerToAgent(2, connectionSettingsOffer()))
+	require.NoError(t, rec.Close())
+
+	code, stdout, stderr := runCommand(t, "", "decode", name)
+	require.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, `{
+  "time": "2022-02-03T04:05:06Z",
+  "direction": "AgentToServer",
+  "message": {
+    "instanceUid": "12345678"
+  }
+}
+`)
+	assert.Contains(t, stdout, `"direction": "ServerToAgent",
+  "connectionId": 2,`)
+	assert.Contains(t, stdout, `"destinationEndpoint": "wss://example.com/v1/opamp"`)
+	assert.Contains(t, stdout, `"value": "REDACTED"`)
+	assert.NotContains(t, stdout, "secret-token")
+
+	// The secrets are printed on request.
+	code, stdout, _ = runCommand(t, "", "decode", "-show-secrets", name)
+	require.Equal(t, 0, code)
+	assert.Contains(t, stdout, `"value": "Bearer secret-token"`)
+}
+
+func TestDecodePayload(t *testing.T) {
+	b, err := proto.Marshal(connectionSettingsOffer())
+	require.NoError(t, err)
+
+	// A hex dump split into lines is read from the standard input.
+	dump := hex.EncodeToString(b)
+	dump = dump[:10] + "\n" + dump[10:] + "\n"
+	code, stdout, stderr := runCommand(t, dump, "decode", "-type", "server-to-agent", "-encoding", "hex")
+	require.Equal(t, 0, code, stderr)
+	assert.True(t, strings.HasPrefix(stdout, "{\n  \"instanceUid\": \"12345678\",\n"), stdout)
+	assert.NotContains(t, stdout, "secret-token")
+}
+
+func TestDecodeErrors(t *testing.T) {
+	code, _, stderr := runCommand(t, "", "decode", "-type", "unknown")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, `unknown input type "unknown"`)
+
+	code, _, stderr = runCommand(t, "", "decode", "-no-such-flag")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "Usage: opampctl decode")
+
+	code, _, stderr = runCommand(t, "not hex", "decode", "-type", "agent-to-server", "-encoding", "hex")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "opampctl decode: -: ")
+
+	// A truncated recording is reported.
+	code, _, stderr = runCommand(t, "\x05\x09", "decode")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "unexpected EOF")
+
+	code, _, stderr = runCommand(t, "", "unknown")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, `unknown command "unknown"`)
+
+	code, stdout, _ := runCommand(t, "", "help")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout, "opampctl agent [flags]")
+}
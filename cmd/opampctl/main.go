@@ -0,0 +1,77 @@
+// Command opampctl connects to OpAMP Servers as a fake Agent and decodes the
+// OpAMP messages, e.g. to try out a Server without writing an Agent or to
+// inspect the messages recorded using the recorder package.
+//
+// Usage:
+//
+//	opampctl agent [flags]
+//	opampctl decode [flags] [file ...]
+//
+// Run "opampctl <command> -h" for the flags of the command.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// errUsage is returned by the commands if the flags are invalid. The flag
+// package has already printed the problem and the usage.
+var errUsage = errors.New("invalid usage")
+
+const usage = `Usage:
+  opampctl agent [flags]              connect to a Server as a fake Agent
+  opampctl decode [flags] [file ...]  print recorded or captured messages as JSON
+
+Run "opampctl <command> -h" for the flags of the command.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command specified by the args and returns the exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "agent":
+		err = runAgent(args[1:], stdout, stderr)
+	case "decode":
+		err = runDecode(args[1:], stdin, stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "opampctl: unknown command %q\n%s", args[0], usage)
+		return 2
+	}
+
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	}
+	fmt.Fprintf(stderr, "opampctl %s: %v\n", args[0], err)
+	return 1
+}
+
+// parseFlags parses the args of a command, returning flag.ErrHelp if the help
+// was requested and errUsage if the args are invalid.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/open-telemetry/opamp-go/client/types"
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+// packagesState is a PackagesStateProvider that simulates installing the
+// packages. Keeps the state in memory and writes the content of the packages to
+// a directory, if any, or discards it.
+type packagesState struct {
+	dir    string
+	logger *types.StdLogger
+
+	mutex                sync.Mutex
+	allPackagesHash      []byte
+	packages             map[string]types.PackageState
+	contentHashes        map[string][]byte
+	lastReportedStatuses *protobufs.PackageStatuses
+}
+
+var _ types.PackagesStateProvider = (*packagesState)(nil)
+
+func newPackagesState(dir string, logger *types.StdLogger) *packagesState {
+	return &packagesState{
+		dir:           dir,
+		logger:        logger,
+		packages:      map[string]types.PackageState{},
+		contentHashes: map[string][]byte{},
+	}
+}
+
+func (s *packagesState) AllPackagesHash() ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.allPackagesHash, nil
+}
+
+func (s *packagesState) SetAllPackagesHash(hash []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.allPackagesHash = hash
+	return nil
+}
+
+func (s *packagesState) Packages() ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	names := make([]string, 0, len(s.packages))
+	for name := range s.packages {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (s *packagesState) PackageState(packageName string) (types.PackageState, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.packages[packageName], nil
+}
+
+func (s *packagesState) SetPackageState(packageName string, state types.PackageState) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.packages[packageName]; !ok {
+		return fmt.Errorf("package %q does not exist", packageName)
+	}
+	s.packages[packageName] = state
+	s.logger.Log(types.LevelInfo, "Installed package",
+		types.F("name", packageName), types.F("version", state.Version))
+	return nil
+}
+
+func (s *packagesState) CreatePackage(packageName string, typ protobufs.PackageAvailable_PackageType) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.packages[packageName]; ok {
+		return fmt.Errorf("package %q already exists", packageName)
+	}
+	s.packages[packageName] = types.PackageState{Exists: true, Type: typ}
+	return nil
+}
+
+func (s *packagesState) FileContentHash(packageName string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.contentHashes[packageName], nil
+}
+
+func (s *packagesState) UpdateContent(
+	ctx context.Context, packageName string, data io.Reader, contentHash []byte,
+) error {
+	w := io.Discard
+	if s.dir != "" {
+		path, err := s.packagePath(packageName)
+		if err != nil {
+			return err
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	size, err := io.Copy(w, data)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.contentHashes[packageName] = contentHash
+	s.logger.Log(types.LevelInfo, "Downloaded package", types.F("name", packageName), types.F("size", size))
+	return nil
+}
+
+func (s *packagesState) DeletePackage(packageName string) error {
+	if s.dir != "" {
+		path, err := s.packagePath(packageName)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.packages, packageName)
+	delete(s.contentHashes, packageName)
+	s.logger.Log(types.LevelInfo, "Deleted package", types.F("name", packageName))
+	return nil
+}
+
+func (s *packagesState) LastReportedStatuses() (*protobufs.PackageStatuses, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lastReportedStatuses, nil
+}
+
+func (s *packagesState) SetLastReportedStatuses(statuses *protobufs.PackageStatuses) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastReportedStatuses = statuses
+	return nil
+}
+
+// packagePath returns the path of the file of the package content in the dir,
+// creating the dir if necessary. The top-level package has an empty name.
+func (s *packagesState) packagePath(packageName string) (string, error) {
+	name := packageName
+	if name == "" {
+		name = "agent"
+	}
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid package name %q", packageName)
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, name), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/open-telemetry/opamp-go/internal"
+	"github.com/open-telemetry/opamp-go/recorder"
+)
+
+// printer writes the messages as indented JSON documents. Safe to use
+// concurrently from any goroutine.
+type printer struct {
+	mutex sync.Mutex
+	w     io.Writer
+
+	// showSecrets disables the redaction of the secrets, e.g. the values of the
+	// Headers, see internal.Redact.
+	showSecrets bool
+}
+
+// recordJSON is the JSON document of a recorder.Record.
+type recordJSON struct {
+	Time         string          `json:"time"`
+	Direction    string          `json:"direction"`
+	ConnectionId uint64          `json:"connectionId,omitempty"`
+	Message      json.RawMessage `json:"message"`
+}
+
+func (p *printer) printRecord(record *recorder.Record) error {
+	var msg proto.Message = record.AgentToServer
+	if record.Direction == recorder.DirectionServerToAgent {
+		msg = record.ServerToAgent
+	}
+	message, err := p.marshal(msg)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(recordJSON{
+		Time:         record.Time.Format(time.RFC3339Nano),
+		Direction:    record.Direction.String(),
+		ConnectionId: record.ConnectionId,
+		Message:      message,
+	})
+	if err != nil {
+		return err
+	}
+	return p.write(b)
+}
+
+func (p *printer) printMessage(msg proto.Message) error {
+	b, err := p.marshal(msg)
+	if err != nil {
+		return err
+	}
+	return p.write(b)
+}
+
+func (p *printer) marshal(msg proto.Message) (json.RawMessage, error) {
+	if !p.showSecrets {
+		msg = internal.Redact(msg)
+	}
+	return protojson.Marshal(msg)
+}
+
+// write indents the JSON document and writes it followed by a newline.
+// protojson randomizes the whitespace of its output, indenting makes it stable.
+func (p *printer) write(b []byte) error {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, b, "", "  "); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	_, err := p.w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,76 @@
+package instrumentation_test
+
+import (
+	"expvar"
+	"fmt"
+	"strings"
+
+	"github.com/open-telemetry/opamp-go/instrumentation"
+)
+
+// expvarMeter is an adapter that publishes the measurements as expvar
+// variables, one integer per instrument and set of attributes. Histograms
+// publish the number of the recorded values and their sum.
+type expvarMeter struct {
+	vars *expvar.Map
+}
+
+func (m expvarMeter) Counter(name, unit, description string) instrumentation.Counter {
+	return expvarInstrument{vars: m.vars, name: name}
+}
+
+func (m expvarMeter) Histogram(name, unit, description string) instrumentation.Histogram {
+	return expvarInstrument{vars: m.vars, name: name}
+}
+
+func (m expvarMeter) Gauge(name, unit, description string) instrumentation.Gauge {
+	return expvarInstrument{vars: m.vars, name: name}
+}
+
+type expvarInstrument struct {
+	vars *expvar.Map
+	name string
+}
+
+func (i expvarInstrument) Add(value int64, attrs ...instrumentation.Attribute) {
+	i.vars.Add(key(i.name, attrs), value)
+}
+
+func (i expvarInstrument) Record(value float64, attrs ...instrumentation.Attribute) {
+	i.vars.Add(key(i.name+".count", attrs), 1)
+	i.vars.AddFloat(key(i.name+".sum", attrs), value)
+}
+
+func key(name string, attrs []instrumentation.Attribute) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, attr := range attrs {
+		fmt.Fprintf(&b, ",%s=%s", attr.Key, attr.Value)
+	}
+	return b.String()
+}
+
+func Example() {
+	// Normally the map is published with expvar.Publish() and is served at
+	// /debug/vars. The meter is passed to the client and the server in
+	// the Meter field of their settings.
+	vars := new(expvar.Map).Init()
+	var meter instrumentation.Meter = expvarMeter{vars: vars}
+
+	sent := meter.Counter("opamp.client.messages.sent", "{message}", "The number of messages sent to the Server.")
+	sent.Add(1, instrumentation.Attribute{Key: "transport", Value: "ws"})
+	sent.Add(2, instrumentation.Attribute{Key: "transport", Value: "ws"})
+
+	duration := meter.Histogram("opamp.client.send.duration", "s", "The duration of sending a message to the Server.")
+	duration.Record(0.25, instrumentation.Attribute{Key: "transport", Value: "ws"})
+	duration.Record(0.5, instrumentation.Attribute{Key: "transport", Value: "ws"})
+
+	vars.Do(func(kv expvar.KeyValue) {
+		fmt.Printf("%s: %s\n", kv.Key, kv.Value)
+	})
+
+	// Output:
+	// opamp.client.messages.sent,transport=ws: 3
+	// opamp.client.send.duration.count,transport=ws: 2
+	// opamp.client.send.duration.sum,transport=ws: 0.75
+}
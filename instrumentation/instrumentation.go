@@ -0,0 +1,79 @@
+// Package instrumentation defines the interface the OpAMP client and server use
+// to report their internal telemetry. The interface has no dependencies, an
+// adapter can forward the measurements to any metrics library, see the example.
+//
+// The client reports:
+//
+//	opamp.client.connection.attempts           Counter   transport
+//	opamp.client.connection.failures           Counter   transport
+//	opamp.client.reconnects                    Counter   transport
+//	opamp.client.messages.sent                 Counter   transport
+//	opamp.client.messages.received             Counter   transport
+//	opamp.client.message.field.size            Histogram direction, field
+//	opamp.client.send.duration                 Histogram transport
+//	opamp.client.package.download.bytes        Counter
+//	opamp.client.package.download.duration     Histogram
+//
+// The server reports:
+//
+//	opamp.server.connections.active            Gauge     transport
+//	opamp.server.connections.rejected          Counter   reason
+//	opamp.server.http.requests                 Counter
+//	opamp.server.messages.received             Counter   transport
+//	opamp.server.messages.sent                 Counter   transport
+//	opamp.server.messages.rejected             Counter   reason
+//	opamp.server.message.field.size            Histogram direction, field
+//	opamp.server.callback.duration             Histogram callback
+package instrumentation
+
+// Attribute is a key-value pair that qualifies a measurement.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// Meter creates the instruments. The instruments are created once, when the
+// client or the server is started, and are used concurrently.
+type Meter interface {
+	// Counter returns a monotonic counter, e.g. the number of sent messages.
+	Counter(name, unit, description string) Counter
+
+	// Histogram returns a histogram of measured values, e.g. durations.
+	Histogram(name, unit, description string) Histogram
+
+	// Gauge returns a gauge that goes up and down, e.g. the number of active
+	// connections.
+	Gauge(name, unit, description string) Gauge
+}
+
+// Counter is a monotonic counter.
+type Counter interface {
+	// Add adds the non-negative value to the counter.
+	Add(value int64, attrs ...Attribute)
+}
+
+// Histogram records the distribution of the measured values.
+type Histogram interface {
+	// Record records the value.
+	Record(value float64, attrs ...Attribute)
+}
+
+// Gauge is a value that goes up and down.
+type Gauge interface {
+	// Add adds the delta, which may be negative, to the gauge.
+	Add(delta int64, attrs ...Attribute)
+}
+
+// NopMeter is a Meter that discards all measurements.
+type NopMeter struct{}
+
+var _ Meter = NopMeter{}
+
+func (NopMeter) Counter(name, unit, description string) Counter     { return nopInstrument{} }
+func (NopMeter) Histogram(name, unit, description string) Histogram { return nopInstrument{} }
+func (NopMeter) Gauge(name, unit, description string) Gauge         { return nopInstrument{} }
+
+type nopInstrument struct{}
+
+func (nopInstrument) Add(value int64, attrs ...Attribute)      {}
+func (nopInstrument) Record(value float64, attrs ...Attribute) {}
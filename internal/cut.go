@@ -0,0 +1,12 @@
+package internal
+
+import "strings"
+
+// Cut slices s around the first instance of sep. Same as strings.Cut, which
+// is not available in Go 1.17.
+func Cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
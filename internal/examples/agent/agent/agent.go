@@ -82,7 +82,7 @@ func NewAgent(agentType string, agentVersion string) *Agent {
 
 	agent := &Agent{
 		effectiveConfig: localConfig,
-		logger:          &Logger{Logger: logger},
+		logger:          types.NewStdLogger(logger, types.LevelDebug),
 		agentType:       agentType,
 		agentVersion:    agentVersion,
 	}
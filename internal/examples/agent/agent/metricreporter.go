@@ -120,7 +120,7 @@ func NewMetricReporter(
 	)
 
 	reporter := &MetricReporter{
-		logger: &Logger{Logger: logger},
+		logger: types.NewStdLogger(logger, types.LevelDebug),
 	}
 
 	reporter.done = make(chan struct{})
@@ -27,7 +27,7 @@ func NewServer(agents *data.Agents) *Server {
 	)
 
 	srv := &Server{
-		logger: &Logger{logger: logger},
+		logger: clientTypes.NewStdLogger(logger, clientTypes.LevelDebug),
 		agents: agents,
 	}
 
@@ -43,8 +43,15 @@ func (srv *Server) Start() {
 				OnMessageFunc:         srv.onMessage,
 				OnConnectionCloseFunc: srv.onDisconnect,
 			},
+			// Give a new instance UID to Agents that clash with a connected Agent,
+			// e.g. Agents running on cloned VM images.
+			OnDuplicateInstanceUid: func(_, _ types.Connection, _ string) server.DuplicateInstanceUidAction {
+				return server.ReIdentifyDuplicateInstanceUid
+			},
 		},
 		ListenEndpoint: "127.0.0.1:4320",
+		// Let Prometheus scrape the health of the server.
+		MetricsPath: "/metrics",
 	}
 
 	err := srv.opampSrv.Start(settings)
@@ -5,11 +5,12 @@ import (
 	"os"
 	"os/signal"
 
+	"github.com/open-telemetry/opamp-go/client/types"
 	"github.com/open-telemetry/opamp-go/internal/examples/supervisor/supervisor"
 )
 
 func main() {
-	logger := &supervisor.Logger{Logger: log.Default()}
+	logger := types.NewStdLogger(log.Default(), types.LevelDebug)
 	supervisor, err := supervisor.NewSupervisor(logger)
 	if err != nil {
 		logger.Errorf(err.Error())
@@ -0,0 +1,25 @@
+package internal
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/open-telemetry/opamp-go/instrumentation"
+)
+
+// RecordFieldSizes records the encoded size of each top-level field set in the
+// message, qualified by the "field" attribute with the name of the field and by
+// the specified attributes.
+func RecordFieldSizes(histogram instrumentation.Histogram, msg proto.Message, attrs ...instrumentation.Attribute) {
+	m := msg.ProtoReflect()
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		// Measure the field alone, including its tag.
+		field := m.New()
+		field.Set(fd, v)
+		size := proto.Size(field.Interface())
+
+		fieldAttrs := append([]instrumentation.Attribute{{Key: "field", Value: string(fd.Name())}}, attrs...)
+		histogram.Record(float64(size), fieldAttrs...)
+		return true
+	})
+}
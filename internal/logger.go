@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"google.golang.org/protobuf/proto"
+
+	"github.com/open-telemetry/opamp-go/client/types"
+)
+
+// Logger logs the records of the client and the Server internals. The Protobuf
+// messages passed as the values of the fields are redacted, see Redact.
+type Logger struct {
+	logger types.LeveledLogger
+}
+
+// NewLogger returns a Logger that logs to the logger, see types.ToLeveled.
+// nil logger discards the records.
+func NewLogger(logger types.Logger) *Logger {
+	if logger == nil {
+		logger = &NopLogger{}
+	}
+	return &Logger{logger: types.ToLeveled(logger)}
+}
+
+func (l *Logger) Debug(msg string, fields ...types.Field) {
+	l.log(types.LevelDebug, msg, fields)
+}
+
+func (l *Logger) Info(msg string, fields ...types.Field) {
+	l.log(types.LevelInfo, msg, fields)
+}
+
+func (l *Logger) Warn(msg string, fields ...types.Field) {
+	l.log(types.LevelWarn, msg, fields)
+}
+
+func (l *Logger) Error(msg string, fields ...types.Field) {
+	l.log(types.LevelError, msg, fields)
+}
+
+func (l *Logger) log(level types.Level, msg string, fields []types.Field) {
+	if !l.logger.Enabled(level) {
+		return
+	}
+	l.logger.Log(level, msg, redactFields(fields)...)
+}
+
+// redactFields returns the fields with the Protobuf messages redacted.
+func redactFields(fields []types.Field) []types.Field {
+	var redacted []types.Field
+	for i, field := range fields {
+		msg, ok := field.Value.(proto.Message)
+		if !ok {
+			continue
+		}
+		if redacted == nil {
+			// Do not modify the fields of the caller.
+			redacted = append([]types.Field(nil), fields...)
+		}
+		redacted[i].Value = Redact(msg)
+	}
+	if redacted == nil {
+		return fields
+	}
+	return redacted
+}
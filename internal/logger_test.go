@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/open-telemetry/opamp-go/client/types"
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+// formattedLogger is a types.Logger that records the formatted messages.
+type formattedLogger struct {
+	debug []string
+	error []string
+}
+
+func (l *formattedLogger) Debugf(format string, v ...interface{}) {
+	l.debug = append(l.debug, fmt.Sprintf(format, v...))
+}
+
+func (l *formattedLogger) Errorf(format string, v ...interface{}) {
+	l.error = append(l.error, fmt.Sprintf(format, v...))
+}
+
+func TestLoggerFormatted(t *testing.T) {
+	formatted := &formattedLogger{}
+	logger := NewLogger(formatted)
+
+	logger.Debug("Connected", types.F("endpoint", "localhost:4320"))
+	logger.Info("Upgraded")
+	logger.Warn("Cannot send", types.F("error", errors.New("connection reset")))
+	logger.Error("Giving up", types.F("attempts", 3), types.F("reason", ""))
+
+	assert.EqualValues(t, []string{"Connected endpoint=localhost:4320", "Upgraded"}, formatted.debug)
+	assert.EqualValues(t, []string{`Cannot send error="connection reset"`, `Giving up attempts=3 reason=""`}, formatted.error)
+
+	// nil logger discards the records.
+	NewLogger(nil).Error("Discarded")
+}
+
+func TestLoggerLeveled(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(types.NewStdLogger(log.New(&buf, "", 0), types.LevelInfo))
+
+	logger.Debug("Discarded")
+	logger.Info("Upgraded", types.F("transport", "ws"))
+	logger.Error("Failed", types.F("error", errors.New("EOF")))
+
+	assert.EqualValues(t, "INFO Upgraded transport=ws\nERROR Failed error=EOF\n", buf.String())
+}
+
+func TestLoggerRedaction(t *testing.T) {
+	settings := &protobufs.OpAMPConnectionSettings{
+		DestinationEndpoint: "wss://example.com",
+		Headers: &protobufs.Headers{
+			Headers: []*protobufs.Header{{Key: "Authorization", Value: "Bearer secret"}},
+		},
+		Certificate: &protobufs.TLSCertificate{
+			PublicKey:  []byte("public"),
+			PrivateKey: []byte("key-material"),
+		},
+	}
+	original := proto.Clone(settings)
+
+	formatted := &formattedLogger{}
+	NewLogger(formatted).Debug("Offered", types.F("settings", settings))
+
+	assert.Len(t, formatted.debug, 1)
+	assert.NotContains(t, formatted.debug[0], "secret")
+	assert.NotContains(t, formatted.debug[0], "key-material")
+	assert.Contains(t, formatted.debug[0], "Authorization")
+	assert.Contains(t, formatted.debug[0], "public")
+	assert.Contains(t, formatted.debug[0], RedactedValue)
+
+	// The logged message is not modified.
+	assert.True(t, proto.Equal(original, settings))
+}
+
+func TestRedact(t *testing.T) {
+	// Messages without secrets are not copied.
+	noSecrets := &protobufs.AgentToServer{InstanceUid: "12345678"}
+	assert.True(t, Redact(noSecrets) == proto.Message(noSecrets))
+
+	// The secrets in the nested messages, lists and maps are redacted.
+	msg := &protobufs.ServerToAgent{
+		ConnectionSettings: &protobufs.ConnectionSettingsOffers{
+			OwnMetrics: &protobufs.TelemetryConnectionSettings{
+				Headers: &protobufs.Headers{Headers: []*protobufs.Header{{Key: "Api-Key", Value: "secret"}}},
+			},
+			OtherConnections: map[string]*protobufs.OtherConnectionSettings{
+				"db": {Certificate: &protobufs.TLSCertificate{PrivateKey: []byte("private")}},
+			},
+		},
+	}
+	redacted := Redact(msg).(*protobufs.ServerToAgent)
+	assert.EqualValues(t, RedactedValue, redacted.ConnectionSettings.OwnMetrics.Headers.Headers[0].Value)
+	assert.EqualValues(t, "Api-Key", redacted.ConnectionSettings.OwnMetrics.Headers.Headers[0].Key)
+	assert.EqualValues(t, RedactedValue, redacted.ConnectionSettings.OtherConnections["db"].Certificate.PrivateKey)
+	assert.EqualValues(t, "secret", msg.ConnectionSettings.OwnMetrics.Headers.Headers[0].Value)
+}
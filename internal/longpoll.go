@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LongPollTimeoutHTTPHeader is the HTTP request header that marks a long-poll
+// request over plain HTTP transport. The value is the number of seconds the
+// Server may hold the request until it has a message to send to the Agent.
+// The body of a long-poll request carries the instance UID only, the status of
+// the Agent is reported by the regular requests.
+const LongPollTimeoutHTTPHeader = "OpAMP-Long-Poll-Timeout"
+
+// SetLongPollTimeoutHeader sets the LongPollTimeoutHTTPHeader to the specified
+// timeout, rounded up to whole seconds.
+func SetLongPollTimeoutHeader(header http.Header, timeout time.Duration) {
+	seconds := (timeout + time.Second - 1) / time.Second
+	header.Set(LongPollTimeoutHTTPHeader, strconv.FormatInt(int64(seconds), 10))
+}
+
+// ExtractLongPollTimeoutHeader extracts the LongPollTimeoutHTTPHeader from the
+// request. Returns 0 if the header is not found or is not a positive number of
+// seconds.
+func ExtractLongPollTimeoutHeader(req *http.Request) time.Duration {
+	value := strings.TrimSpace(req.Header.Get(LongPollTimeoutHTTPHeader))
+	if value == "" {
+		return 0
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
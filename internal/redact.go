@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// RedactedValue replaces the values of the secrets in the redacted messages.
+const RedactedValue = "REDACTED"
+
+// redactedFields are the fields of the OpAMP messages that carry secrets.
+var redactedFields = map[protoreflect.FullName]bool{
+	"opamp.proto.Header.value":               true,
+	"opamp.proto.TLSCertificate.private_key": true,
+}
+
+// Redact returns a copy of the message with the secrets, such as the values of
+// the Headers and the private keys of the TLSCertificates, replaced by
+// RedactedValue. Returns the message itself if it has no secrets.
+func Redact(msg proto.Message) proto.Message {
+	if msg == nil || !hasSecrets(msg.ProtoReflect()) {
+		return msg
+	}
+	redacted := proto.Clone(msg)
+	redactMessage(redacted.ProtoReflect())
+	return redacted
+}
+
+// hasSecrets returns true if any of the fields of the message or of the nested
+// messages is a secret.
+func hasSecrets(m protoreflect.Message) bool {
+	found := false
+	forEachField(m, func(m protoreflect.Message, fd protoreflect.FieldDescriptor) {
+		found = true
+	})
+	return found
+}
+
+func redactMessage(m protoreflect.Message) {
+	forEachField(m, func(m protoreflect.Message, fd protoreflect.FieldDescriptor) {
+		if fd.Kind() == protoreflect.BytesKind {
+			m.Set(fd, protoreflect.ValueOfBytes([]byte(RedactedValue)))
+		} else {
+			m.Set(fd, protoreflect.ValueOfString(RedactedValue))
+		}
+	})
+}
+
+// forEachField calls f for each set secret field of the message and of the
+// nested messages.
+func forEachField(m protoreflect.Message, f func(m protoreflect.Message, fd protoreflect.FieldDescriptor)) {
+	var secrets []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case redactedFields[fd.FullName()]:
+			secrets = append(secrets, fd)
+		case fd.IsList() && fd.Message() != nil:
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				forEachField(list.Get(i).Message(), f)
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+				forEachField(v.Message(), f)
+				return true
+			})
+		case fd.Message() != nil && !fd.IsMap():
+			forEachField(v.Message(), f)
+		}
+		return true
+	})
+	// The fields are not modified while ranging over the message.
+	for _, fd := range secrets {
+		f(m, fd)
+	}
+}
@@ -0,0 +1,100 @@
+package testhelpers
+
+import (
+	"sync"
+
+	"github.com/open-telemetry/opamp-go/instrumentation"
+)
+
+// TestMeter is an instrumentation.Meter that remembers the measurements so that
+// tests can verify them.
+type TestMeter struct {
+	mutex        sync.Mutex
+	measurements map[string][]measurement
+}
+
+type measurement struct {
+	value float64
+	attrs []instrumentation.Attribute
+}
+
+var _ instrumentation.Meter = (*TestMeter)(nil)
+
+func NewTestMeter() *TestMeter {
+	return &TestMeter{measurements: map[string][]measurement{}}
+}
+
+func (m *TestMeter) Counter(name, unit, description string) instrumentation.Counter {
+	return &testInstrument{meter: m, name: name}
+}
+
+func (m *TestMeter) Histogram(name, unit, description string) instrumentation.Histogram {
+	return &testInstrument{meter: m, name: name}
+}
+
+func (m *TestMeter) Gauge(name, unit, description string) instrumentation.Gauge {
+	return &testInstrument{meter: m, name: name}
+}
+
+// Sum returns the sum of the values recorded by the named instrument with all of
+// the specified attributes.
+func (m *TestMeter) Sum(name string, attrs ...instrumentation.Attribute) float64 {
+	sum := 0.0
+	m.forEach(name, attrs, func(value float64) { sum += value })
+	return sum
+}
+
+// Count returns the number of the values recorded by the named instrument with
+// all of the specified attributes.
+func (m *TestMeter) Count(name string, attrs ...instrumentation.Attribute) int {
+	count := 0
+	m.forEach(name, attrs, func(float64) { count++ })
+	return count
+}
+
+func (m *TestMeter) forEach(name string, attrs []instrumentation.Attribute, f func(value float64)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, measurement := range m.measurements[name] {
+		if hasAttributes(measurement.attrs, attrs) {
+			f(measurement.value)
+		}
+	}
+}
+
+func (m *TestMeter) record(name string, value float64, attrs []instrumentation.Attribute) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.measurements[name] = append(m.measurements[name], measurement{value: value, attrs: attrs})
+}
+
+func hasAttributes(attrs []instrumentation.Attribute, required []instrumentation.Attribute) bool {
+	for _, r := range required {
+		found := false
+		for _, a := range attrs {
+			if a == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+type testInstrument struct {
+	meter *TestMeter
+	name  string
+}
+
+func (i *testInstrument) Add(value int64, attrs ...instrumentation.Attribute) {
+	i.meter.record(i.name, float64(value), attrs)
+}
+
+func (i *testInstrument) Record(value float64, attrs ...instrumentation.Attribute) {
+	i.meter.record(i.name, value, attrs)
+}
@@ -0,0 +1,90 @@
+package recorder
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Reader reads the records written by a Recorder.
+type Reader struct {
+	r      *bufio.Reader
+	closer io.Closer
+}
+
+// NewReader returns a Reader that reads the records from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Open opens the named file for reading the records. Close the Reader to close
+// the file.
+func Open(name string) (*Reader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	r := NewReader(f)
+	r.closer = f
+	return r, nil
+}
+
+// ReadFile returns all records of the named file.
+func ReadFile(name string) ([]Record, error) {
+	r, err := Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return r.ReadAll()
+}
+
+// Read returns the next record. Returns io.EOF if there are no more records and
+// io.ErrUnexpectedEOF if the recording ends in the middle of a record, e.g.
+// because the recording process was killed.
+func (r *Reader) Read() (*Record, error) {
+	size, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxRecordSize {
+		return nil, fmt.Errorf("%w: size %d exceeds %d bytes", errInvalidRecord, size, maxRecordSize)
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r.r, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return unmarshalRecord(body)
+}
+
+// ReadAll returns the remaining records.
+func (r *Reader) ReadAll() ([]Record, error) {
+	var records []Record
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, *record)
+	}
+}
+
+// Close closes the file opened by Open. Does not close the io.Reader passed to
+// NewReader.
+func (r *Reader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	err := r.closer.Close()
+	r.closer = nil
+	return err
+}
@@ -0,0 +1,179 @@
+// Package recorder records the OpAMP messages exchanged between the Agents and
+// the Server to a file and replays the recordings, e.g. to reproduce the issues
+// observed in a fleet in tests.
+//
+// A recording is a sequence of records, each of which is a varint length
+// followed by a Protobuf message of the following schema:
+//
+//	message Record {
+//	    fixed64 time_unix_nano = 1;
+//	    Direction direction = 2;
+//	    uint64 connection_id = 3;
+//	    opamp.proto.AgentToServer agent_to_server = 4;
+//	    opamp.proto.ServerToAgent server_to_agent = 5;
+//	}
+//
+// Use a Recorder to write a recording, see Recorder.ClientInterceptor and
+// Recorder.ServerInterceptor, a Reader to read it and a ClientReplayer or
+// ReplayToServer to replay it.
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+// Direction is the direction a recorded message was sent in.
+type Direction int
+
+const (
+	DirectionAgentToServer Direction = 1
+	DirectionServerToAgent Direction = 2
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionAgentToServer:
+		return "AgentToServer"
+	case DirectionServerToAgent:
+		return "ServerToAgent"
+	}
+	return "Direction(" + strconv.Itoa(int(d)) + ")"
+}
+
+// Record is a recorded message.
+type Record struct {
+	// Time when the message was recorded.
+	Time time.Time
+
+	Direction Direction
+
+	// ConnectionId identifies the connection of the Server the message was
+	// exchanged over, starting from 1. Plain HTTP requests are separate
+	// connections. 0 for the messages recorded by the client.
+	ConnectionId uint64
+
+	// AgentToServer is set if the Direction is DirectionAgentToServer.
+	AgentToServer *protobufs.AgentToServer
+
+	// ServerToAgent is set if the Direction is DirectionServerToAgent.
+	ServerToAgent *protobufs.ServerToAgent
+}
+
+// The field numbers of the Record message.
+const (
+	fieldTime          protowire.Number = 1
+	fieldDirection     protowire.Number = 2
+	fieldConnectionId  protowire.Number = 3
+	fieldAgentToServer protowire.Number = 4
+	fieldServerToAgent protowire.Number = 5
+)
+
+// maxRecordSize limits the memory allocated for a record of a corrupted
+// recording.
+const maxRecordSize = 64 << 20
+
+var errInvalidRecord = errors.New("invalid record")
+
+// appendRecord appends the length-delimited encoding of the record to b.
+func appendRecord(b []byte, record *Record) ([]byte, error) {
+	if err := record.validate(); err != nil {
+		return b, err
+	}
+	var field protowire.Number = fieldAgentToServer
+	var msg proto.Message = record.AgentToServer
+	if record.Direction == DirectionServerToAgent {
+		field, msg = fieldServerToAgent, record.ServerToAgent
+	}
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return b, err
+	}
+
+	var body []byte
+	body = protowire.AppendTag(body, fieldTime, protowire.Fixed64Type)
+	body = protowire.AppendFixed64(body, uint64(record.Time.UnixNano()))
+	body = protowire.AppendTag(body, fieldDirection, protowire.VarintType)
+	body = protowire.AppendVarint(body, uint64(record.Direction))
+	if record.ConnectionId != 0 {
+		body = protowire.AppendTag(body, fieldConnectionId, protowire.VarintType)
+		body = protowire.AppendVarint(body, record.ConnectionId)
+	}
+	body = protowire.AppendTag(body, field, protowire.BytesType)
+	body = protowire.AppendBytes(body, payload)
+
+	b = protowire.AppendVarint(b, uint64(len(body)))
+	return append(b, body...), nil
+}
+
+// unmarshalRecord decodes the body of a record. Unknown fields are skipped.
+func unmarshalRecord(body []byte) (*Record, error) {
+	record := &Record{}
+	for len(body) > 0 {
+		num, typ, n := protowire.ConsumeTag(body)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		body = body[n:]
+
+		switch {
+		case num == fieldTime && typ == protowire.Fixed64Type:
+			var v uint64
+			v, n = protowire.ConsumeFixed64(body)
+			record.Time = time.Unix(0, int64(v))
+		case num == fieldDirection && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(body)
+			record.Direction = Direction(v)
+		case num == fieldConnectionId && typ == protowire.VarintType:
+			record.ConnectionId, n = protowire.ConsumeVarint(body)
+		case num == fieldAgentToServer && typ == protowire.BytesType:
+			var v []byte
+			v, n = protowire.ConsumeBytes(body)
+			record.AgentToServer = &protobufs.AgentToServer{}
+			if n >= 0 {
+				if err := proto.Unmarshal(v, record.AgentToServer); err != nil {
+					return nil, err
+				}
+			}
+		case num == fieldServerToAgent && typ == protowire.BytesType:
+			var v []byte
+			v, n = protowire.ConsumeBytes(body)
+			record.ServerToAgent = &protobufs.ServerToAgent{}
+			if n >= 0 {
+				if err := proto.Unmarshal(v, record.ServerToAgent); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, body)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		body = body[n:]
+	}
+
+	if err := record.validate(); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// validate returns an error unless the message of the Direction is set.
+func (r *Record) validate() error {
+	switch {
+	case r.Direction == DirectionAgentToServer && r.AgentToServer != nil:
+	case r.Direction == DirectionServerToAgent && r.ServerToAgent != nil:
+	default:
+		return fmt.Errorf("%w: no %v message", errInvalidRecord, r.Direction)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package recorder
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/open-telemetry/opamp-go/client/types"
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/server"
+	servertypes "github.com/open-telemetry/opamp-go/server/types"
+)
+
+// Recorder writes the records of the messages to an io.Writer. Safe to use
+// concurrently from any goroutine.
+type Recorder struct {
+	mutex  sync.Mutex
+	w      io.Writer
+	closer io.Closer
+	buf    []byte
+	err    error
+
+	// The connections of the Server seen by the interceptor, see
+	// ServerInterceptor.
+	connsMutex sync.Mutex
+	conns      map[servertypes.Connection]*recordingConnection
+	lastConnId uint64
+}
+
+// NewRecorder returns a Recorder that writes the records to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w, conns: map[servertypes.Connection]*recordingConnection{}}
+}
+
+// Create creates or truncates the named file and returns a Recorder that writes
+// the records to it. Close the Recorder to close the file.
+func Create(name string) (*Recorder, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	r := NewRecorder(f)
+	r.closer = f
+	return r, nil
+}
+
+// Record writes the record. Zero Time means the current time. Once writing fails
+// the following records are discarded and the error is returned by Err.
+func (r *Recorder) Record(record Record) error {
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	b, err := appendRecord(r.buf[:0], &record)
+	if err != nil {
+		return err
+	}
+	r.buf = b
+	if _, err := r.w.Write(b); err != nil {
+		r.err = err
+		return err
+	}
+	return nil
+}
+
+// RecordAgentToServer writes the record of the message sent by the Agent.
+func (r *Recorder) RecordAgentToServer(connectionId uint64, msg *protobufs.AgentToServer) error {
+	return r.Record(Record{Direction: DirectionAgentToServer, ConnectionId: connectionId, AgentToServer: msg})
+}
+
+// RecordServerToAgent writes the record of the message sent by the Server.
+func (r *Recorder) RecordServerToAgent(connectionId uint64, msg *protobufs.ServerToAgent) error {
+	return r.Record(Record{Direction: DirectionServerToAgent, ConnectionId: connectionId, ServerToAgent: msg})
+}
+
+// Err returns the error that occurred while writing the records, if any.
+func (r *Recorder) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
+}
+
+// Close closes the file created by Create. Returns the error that occurred
+// while writing the records, if any. Does not close the io.Writer passed to
+// NewRecorder.
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closer != nil {
+		if err := r.closer.Close(); err != nil && r.err == nil {
+			r.err = err
+		}
+		r.closer = nil
+	}
+	return r.err
+}
+
+// ClientInterceptor returns the client Interceptor that records the messages
+// sent and received by the client, see types.StartSettings.Interceptors. The
+// messages are recorded as modified by the preceding interceptors, add the
+// Recorder last to record the messages as they are sent to the Server.
+// The errors of the Recorder do not affect the client, see Err.
+func (r *Recorder) ClientInterceptor() types.Interceptor {
+	return types.Interceptor{
+		OnSend: func(ctx context.Context, msg *protobufs.AgentToServer) error {
+			_ = r.RecordAgentToServer(0, msg)
+			return nil
+		},
+		OnReceive: func(ctx context.Context, msg *protobufs.ServerToAgent) error {
+			_ = r.RecordServerToAgent(0, msg)
+			return nil
+		},
+	}
+}
+
+// ServerInterceptor returns the Server Interceptor that records the messages
+// passed to and returned from Callbacks.OnMessage and the messages sent using
+// Connection.Send, see server.Settings.Interceptors. The following interceptors
+// and the Callbacks receive a Connection that records the sent messages.
+// The messages the Server responds with without calling the Callbacks, e.g. to
+// the invalid messages, and the messages sent using OpAMPServer.Enqueue are not
+// recorded. The errors of the Recorder do not affect the Server, see Err.
+func (r *Recorder) ServerInterceptor() server.Interceptor {
+	return server.Interceptor{
+		OnConnected: func(conn servertypes.Connection, next server.ConnectionHandler) {
+			next(r.connection(conn))
+		},
+		OnMessage: func(
+			conn servertypes.Connection, message *protobufs.AgentToServer, next server.MessageHandler,
+		) *protobufs.ServerToAgent {
+			rc := r.connection(conn)
+			_ = r.RecordAgentToServer(rc.id, message)
+			response := next(rc, message)
+			if response != nil {
+				_ = r.RecordServerToAgent(rc.id, response)
+			}
+			return response
+		},
+		OnConnectionClose: func(conn servertypes.Connection, next server.ConnectionHandler) {
+			rc := r.connection(conn)
+			r.connsMutex.Lock()
+			delete(r.conns, conn)
+			r.connsMutex.Unlock()
+			next(rc)
+		},
+	}
+}
+
+// connection returns the recordingConnection of the conn, assigning it the next
+// connection id if the conn is new.
+func (r *Recorder) connection(conn servertypes.Connection) *recordingConnection {
+	r.connsMutex.Lock()
+	defer r.connsMutex.Unlock()
+
+	rc, ok := r.conns[conn]
+	if !ok {
+		r.lastConnId++
+		rc = &recordingConnection{Connection: conn, recorder: r, id: r.lastConnId}
+		r.conns[conn] = rc
+	}
+	return rc
+}
+
+// recordingConnection is a Connection that records the sent messages.
+type recordingConnection struct {
+	servertypes.Connection
+	recorder *Recorder
+	id       uint64
+}
+
+var _ servertypes.Connection = (*recordingConnection)(nil)
+
+// Send records the message before sending it, so that the record precedes the
+// records of the messages the Agent sends in response.
+func (c *recordingConnection) Send(ctx context.Context, message *protobufs.ServerToAgent) error {
+	_ = c.recorder.RecordServerToAgent(c.id, message)
+	return c.Connection.Send(ctx, message)
+}
@@ -0,0 +1,214 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/open-telemetry/opamp-go/client"
+	"github.com/open-telemetry/opamp-go/client/types"
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/server"
+	servertypes "github.com/open-telemetry/opamp-go/server/types"
+)
+
+func eventually(t *testing.T, f func() bool) {
+	assert.Eventually(t, f, 5*time.Second, 10*time.Millisecond)
+}
+
+func createRemoteConfig() *protobufs.AgentRemoteConfig {
+	return &protobufs.AgentRemoteConfig{
+		Config: &protobufs.AgentConfigMap{
+			ConfigMap: map[string]*protobufs.AgentConfigFile{
+				"": {Body: []byte("receivers: {}")},
+			},
+		},
+		ConfigHash: []byte{1, 2, 3, 4},
+	}
+}
+
+func createAgentDescr() *protobufs.AgentDescription {
+	return &protobufs.AgentDescription{
+		IdentifyingAttributes: []*protobufs.KeyValue{
+			{
+				Key:   "service.name",
+				Value: &protobufs.AnyValue{Value: &protobufs.AnyValue_StringValue{StringValue: "recorded"}},
+			},
+		},
+	}
+}
+
+func assertRecord(t *testing.T, expected, actual Record) {
+	assert.Equal(t, expected.Direction, actual.Direction)
+	assert.Equal(t, expected.ConnectionId, actual.ConnectionId)
+	assert.True(t, proto.Equal(expected.AgentToServer, actual.AgentToServer), "%v", actual.AgentToServer)
+	assert.True(t, proto.Equal(expected.ServerToAgent, actual.ServerToAgent), "%v", actual.ServerToAgent)
+}
+
+func TestRecordAndRead(t *testing.T) {
+	ts := time.Date(2022, 2, 3, 4, 5, 6, 7, time.UTC)
+	records := []Record{
+		{
+			Time:          ts,
+			Direction:     DirectionAgentToServer,
+			ConnectionId:  3,
+			AgentToServer: &protobufs.AgentToServer{InstanceUid: "12345678", Capabilities: protobufs.AgentCapabilities_ReportsStatus},
+		},
+		{
+			Time:          ts.Add(time.Second),
+			Direction:     DirectionServerToAgent,
+			ConnectionId:  3,
+			ServerToAgent: &protobufs.ServerToAgent{InstanceUid: "12345678", RemoteConfig: createRemoteConfig()},
+		},
+	}
+
+	var buf bytes.Buffer
+	recorder := NewRecorder(&buf)
+	for _, record := range records {
+		require.NoError(t, recorder.Record(record))
+	}
+	require.NoError(t, recorder.RecordAgentToServer(0, &protobufs.AgentToServer{}))
+
+	// Records without the message of the direction are rejected.
+	err := recorder.Record(Record{Direction: DirectionServerToAgent, AgentToServer: &protobufs.AgentToServer{}})
+	assert.ErrorIs(t, err, errInvalidRecord)
+	assert.NoError(t, recorder.Err())
+	assert.NoError(t, recorder.Close())
+
+	read, err := NewReader(bytes.NewReader(buf.Bytes())).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, read, 3)
+	for i, record := range records {
+		assertRecord(t, record, read[i])
+		assert.True(t, record.Time.Equal(read[i].Time))
+	}
+	assertRecord(t, Record{Direction: DirectionAgentToServer, AgentToServer: &protobufs.AgentToServer{}}, read[2])
+	assert.False(t, read[2].Time.IsZero())
+
+	// A truncated recording returns the complete records.
+	reader := NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	read, err = reader.ReadAll()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Len(t, read, 2)
+
+	// The size of a corrupted record is limited.
+	corrupted := protowire.AppendVarint(nil, maxRecordSize+1)
+	_, err = NewReader(bytes.NewReader(corrupted)).Read()
+	assert.ErrorIs(t, err, errInvalidRecord)
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestRecordError(t *testing.T) {
+	recorder := NewRecorder(failingWriter{})
+	msg := &protobufs.AgentToServer{}
+	assert.EqualError(t, recorder.RecordAgentToServer(0, msg), "disk full")
+	assert.EqualError(t, recorder.RecordAgentToServer(0, msg), "disk full")
+	assert.EqualError(t, recorder.Err(), "disk full")
+	assert.EqualError(t, recorder.Close(), "disk full")
+}
+
+func TestCreateAndReadFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "recording")
+	recorder, err := Create(name)
+	require.NoError(t, err)
+	msg := &protobufs.ServerToAgent{InstanceUid: "12345678"}
+	require.NoError(t, recorder.RecordServerToAgent(1, msg))
+	require.NoError(t, recorder.Close())
+
+	records, err := ReadFile(name)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assertRecord(t, Record{Direction: DirectionServerToAgent, ConnectionId: 1, ServerToAgent: msg}, records[0])
+
+	_, err = ReadFile(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestInterceptors(t *testing.T) {
+	// Start a Server that sends a remote config and records the messages.
+	var serverBuf, clientBuf bytes.Buffer
+	serverRecorder := NewRecorder(&serverBuf)
+	var sent int64
+	srv := server.New(nil)
+	handler, err := srv.Attach(server.Settings{
+		Callbacks: server.CallbacksStruct{
+			OnMessageFunc: func(conn servertypes.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+				if atomic.AddInt64(&sent, 1) > 1 {
+					return nil
+				}
+				// The message sent using the Connection is recorded too.
+				assert.NoError(t, conn.Send(context.Background(), &protobufs.ServerToAgent{InstanceUid: message.InstanceUid}))
+				return &protobufs.ServerToAgent{InstanceUid: message.InstanceUid, RemoteConfig: createRemoteConfig()}
+			},
+		},
+		Interceptors: []server.Interceptor{serverRecorder.ServerInterceptor()},
+	})
+	require.NoError(t, err)
+	httpServer, addr, err := serve(handler)
+	require.NoError(t, err)
+	defer httpServer.Close()
+
+	// Start a client that records the messages.
+	clientRecorder := NewRecorder(&clientBuf)
+	var remoteConfigs int64
+	c := client.NewWebSocket(nil)
+	require.NoError(t, c.SetAgentDescription(createAgentDescr()))
+	settings := types.StartSettings{
+		OpAMPServerURL: "ws://" + addr + replayPath,
+		InstanceUid:    "01G2DMN5SM2HFEG2SEPSBN4PBQ",
+		Callbacks: types.CallbacksStruct{
+			OnMessageFunc: func(ctx context.Context, msg *types.MessageData) {
+				if msg.RemoteConfig != nil {
+					atomic.AddInt64(&remoteConfigs, 1)
+				}
+			},
+		},
+		Interceptors: []types.Interceptor{clientRecorder.ClientInterceptor()},
+	}
+	require.NoError(t, c.Start(context.Background(), settings))
+	eventually(t, func() bool { return atomic.LoadInt64(&remoteConfigs) == 1 })
+	require.NoError(t, c.Stop(context.Background()))
+	require.NoError(t, srv.Stop(context.Background()))
+	require.NoError(t, clientRecorder.Close())
+	require.NoError(t, serverRecorder.Close())
+
+	// The client recorded its first message and the responses.
+	records, err := NewReader(&clientBuf).ReadAll()
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(records), 3)
+	assert.Equal(t, DirectionAgentToServer, records[0].Direction)
+	assert.EqualValues(t, settings.InstanceUid, records[0].AgentToServer.InstanceUid)
+	assertRecord(t, Record{
+		Direction:     DirectionServerToAgent,
+		ServerToAgent: &protobufs.ServerToAgent{InstanceUid: settings.InstanceUid},
+	}, records[1])
+	assertRecord(t, Record{
+		Direction:     DirectionServerToAgent,
+		ServerToAgent: &protobufs.ServerToAgent{InstanceUid: settings.InstanceUid, RemoteConfig: createRemoteConfig()},
+	}, records[2])
+
+	// The Server recorded the same sequence over the first connection.
+	records, err = NewReader(&serverBuf).ReadAll()
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(records), 3)
+	for i, direction := range []Direction{DirectionAgentToServer, DirectionServerToAgent, DirectionServerToAgent} {
+		assert.Equal(t, direction, records[i].Direction)
+		assert.EqualValues(t, 1, records[i].ConnectionId)
+	}
+	assert.True(t, proto.Equal(createRemoteConfig(), records[2].ServerToAgent.RemoteConfig))
+}
@@ -0,0 +1,305 @@
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/server"
+	servertypes "github.com/open-telemetry/opamp-go/server/types"
+)
+
+const (
+	replayPath = "/v1/opamp"
+
+	// The maximum duration of sending one of multiple recorded responses.
+	replaySendTimeout = 10 * time.Second
+)
+
+// ClientReplayer is a fake Server that responds to the messages of an
+// OpAMPClient with the ServerToAgent messages of a recording, e.g. to reproduce
+// in a test how the client handles a sequence of messages observed in a fleet.
+//
+// The n-th message received from the client is responded with the ServerToAgent
+// messages recorded after the n-th AgentToServer message, the recorded messages
+// are sent unchanged. The messages received beyond the recording and the ones
+// after which nothing was recorded are responded with an empty message.
+// If multiple messages were recorded after an AgentToServer message they are
+// sent one by one over WebSocket connections. A plain HTTP request can have one
+// response only, so the messages are merged into one, the fields set in later
+// messages replace the same fields of earlier messages.
+// Pass the records of a client recording or of one connection of a Server
+// recording, see Record.ConnectionId.
+type ClientReplayer struct {
+	responses [][]*protobufs.ServerToAgent
+
+	srv        server.OpAMPServer
+	httpServer *http.Server
+	addr       string
+
+	mutex    sync.Mutex
+	received []*protobufs.AgentToServer
+	done     chan struct{}
+}
+
+// NewClientReplayer starts a ClientReplayer of the records listening on a
+// random local port. Close the ClientReplayer when done.
+func NewClientReplayer(records []Record) (*ClientReplayer, error) {
+	r := &ClientReplayer{
+		responses: serverResponses(records),
+		srv:       server.New(nil),
+		done:      make(chan struct{}),
+	}
+	if len(r.responses) == 0 {
+		close(r.done)
+	}
+
+	handler, err := r.srv.Attach(server.Settings{
+		Callbacks: server.CallbacksStruct{OnMessageFunc: r.onMessage},
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.httpServer, r.addr, err = serve(handler)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// serverResponses groups the ServerToAgent messages of the records by the
+// AgentToServer message they follow. The messages recorded before the first
+// AgentToServer message are grouped with the ones following it.
+func serverResponses(records []Record) [][]*protobufs.ServerToAgent {
+	var responses [][]*protobufs.ServerToAgent
+	var leading []*protobufs.ServerToAgent
+	for _, record := range records {
+		switch {
+		case record.Direction == DirectionAgentToServer:
+			responses = append(responses, nil)
+		case len(responses) == 0:
+			leading = append(leading, record.ServerToAgent)
+		default:
+			last := len(responses) - 1
+			responses[last] = append(responses[last], record.ServerToAgent)
+		}
+	}
+	if len(responses) > 0 {
+		responses[0] = append(leading, responses[0]...)
+	}
+	return responses
+}
+
+func (r *ClientReplayer) onMessage(
+	conn servertypes.Connection, message *protobufs.AgentToServer,
+) *protobufs.ServerToAgent {
+	r.mutex.Lock()
+	n := len(r.received)
+	r.received = append(r.received, message)
+	if n == len(r.responses)-1 {
+		close(r.done)
+	}
+	r.mutex.Unlock()
+
+	if n >= len(r.responses) || len(r.responses[n]) == 0 {
+		return &protobufs.ServerToAgent{InstanceUid: message.InstanceUid}
+	}
+	responses := r.responses[n]
+	for i, response := range responses[:len(responses)-1] {
+		// Sending from OnMessage fails for plain HTTP connections. The client
+		// will not receive the rest of the recording if sending over WebSocket
+		// fails, which the test notices.
+		ctx, cancel := context.WithTimeout(context.Background(), replaySendTimeout)
+		err := conn.Send(ctx, proto.Clone(response).(*protobufs.ServerToAgent))
+		cancel()
+		if err != nil {
+			return mergeMessages(responses[i:])
+		}
+	}
+	return proto.Clone(responses[len(responses)-1]).(*protobufs.ServerToAgent)
+}
+
+// mergeMessages returns a copy of the first message with the fields set in the
+// later messages replaced by the values of the later messages.
+func mergeMessages(messages []*protobufs.ServerToAgent) *protobufs.ServerToAgent {
+	merged := proto.Clone(messages[0]).(*protobufs.ServerToAgent)
+	m := merged.ProtoReflect()
+	for _, message := range messages[1:] {
+		proto.Clone(message).ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+			m.Set(fd, v)
+			return true
+		})
+	}
+	return merged
+}
+
+// WebSocketURL returns the URL for the WebSocket clients, see
+// types.StartSettings.OpAMPServerURL.
+func (r *ClientReplayer) WebSocketURL() string {
+	return "ws://" + r.addr + replayPath
+}
+
+// HTTPURL returns the URL for the plain HTTP clients, see
+// types.StartSettings.OpAMPServerURL.
+func (r *ClientReplayer) HTTPURL() string {
+	return "http://" + r.addr + replayPath
+}
+
+// Received returns the messages received from the client so far.
+func (r *ClientReplayer) Received() []*protobufs.AgentToServer {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	received := make([]*protobufs.AgentToServer, len(r.received))
+	copy(received, r.received)
+	return received
+}
+
+// Done returns a channel that is closed when the client has sent as many
+// messages as there are AgentToServer messages in the recording.
+func (r *ClientReplayer) Done() <-chan struct{} {
+	return r.done
+}
+
+// Close stops the ClientReplayer and closes the connections.
+func (r *ClientReplayer) Close() error {
+	if err := r.srv.Stop(context.Background()); err != nil {
+		return err
+	}
+	return r.httpServer.Close()
+}
+
+// ReplayToServer attaches the srv using the settings and sends the AgentToServer
+// messages of the records to it in order. The messages of every connection, see
+// Record.ConnectionId, are sent over a separate WebSocket connection, which is
+// closed after the last message of the connection is replayed, so that the srv
+// sees the connections of the recording. The response to a message is the next
+// message the srv sends over the connection. Returns the responses of the Server
+// in the same order as the AgentToServer messages. The ServerToAgent messages of
+// the records are ignored, compare them with the responses to find the
+// differences. Stops at the first message that fails, e.g. if the connection is
+// rejected or closed by the Server, and returns the responses received so far.
+// The caller is responsible for stopping the srv.
+func ReplayToServer(
+	ctx context.Context, srv server.OpAMPServer, settings server.Settings, records []Record,
+) ([]*protobufs.ServerToAgent, error) {
+	handler, err := srv.Attach(settings)
+	if err != nil {
+		return nil, err
+	}
+	httpServer, addr, err := serve(handler)
+	if err != nil {
+		return nil, err
+	}
+	defer httpServer.Close()
+
+	// The index of the last AgentToServer record of every connection.
+	last := map[uint64]int{}
+	for i, record := range records {
+		if record.Direction == DirectionAgentToServer {
+			last[record.ConnectionId] = i
+		}
+	}
+
+	conns := map[uint64]*websocket.Conn{}
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+
+	url := "ws://" + addr + replayPath
+	var responses []*protobufs.ServerToAgent
+	for i, record := range records {
+		if record.Direction != DirectionAgentToServer {
+			continue
+		}
+		conn := conns[record.ConnectionId]
+		if conn == nil {
+			conn, _, err = websocket.DefaultDialer.DialContext(ctx, url, nil)
+			if err != nil {
+				return responses, fmt.Errorf("cannot replay record %d: %w", i, err)
+			}
+			conns[record.ConnectionId] = conn
+		}
+
+		response, err := exchange(ctx, conn, record.AgentToServer)
+		if err != nil {
+			return responses, fmt.Errorf("cannot replay record %d: %w", i, err)
+		}
+		responses = append(responses, response)
+
+		if last[record.ConnectionId] == i {
+			_ = conn.Close()
+			delete(conns, record.ConnectionId)
+		}
+	}
+	return responses, nil
+}
+
+// exchange sends the message over the WebSocket connection and returns the next
+// message received over the connection. The connection is closed if ctx is
+// cancelled.
+func exchange(
+	ctx context.Context, conn *websocket.Conn, msg *protobufs.AgentToServer,
+) (*protobufs.ServerToAgent, error) {
+	body, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Unblock writing and reading.
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	if err := conn.WriteMessage(websocket.BinaryMessage, body); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	_, body, err = conn.ReadMessage()
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	var response protobufs.ServerToAgent
+	if err := proto.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// contextError returns the error of ctx if it is cancelled, since cancelling
+// closes the connection and err is the resulting network error then.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// serve starts an http.Server of the handler listening on a random local port.
+// Returns the address it listens on.
+func serve(handler server.HTTPHandlerFunc) (*http.Server, string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(replayPath, handler)
+	httpServer := &http.Server{Handler: mux}
+	go func() { _ = httpServer.Serve(listener) }()
+	return httpServer, listener.Addr().String(), nil
+}
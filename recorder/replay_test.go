@@ -0,0 +1,235 @@
+package recorder
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/open-telemetry/opamp-go/client"
+	"github.com/open-telemetry/opamp-go/client/types"
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/server"
+	servertypes "github.com/open-telemetry/opamp-go/server/types"
+)
+
+func agentToServer(instanceUid string) Record {
+	return Record{Direction: DirectionAgentToServer, AgentToServer: &protobufs.AgentToServer{InstanceUid: instanceUid}}
+}
+
+func serverToAgent(msg *protobufs.ServerToAgent) Record {
+	return Record{Direction: DirectionServerToAgent, ServerToAgent: msg}
+}
+
+func TestServerResponses(t *testing.T) {
+	m1 := &protobufs.ServerToAgent{InstanceUid: "1"}
+	m2 := &protobufs.ServerToAgent{InstanceUid: "2"}
+	m3 := &protobufs.ServerToAgent{InstanceUid: "3"}
+	responses := serverResponses([]Record{
+		serverToAgent(m1),
+		agentToServer("a"),
+		serverToAgent(m2),
+		agentToServer("a"),
+		agentToServer("a"),
+		serverToAgent(m3),
+	})
+	assert.Equal(t, [][]*protobufs.ServerToAgent{{m1, m2}, nil, {m3}}, responses)
+	assert.Empty(t, serverResponses(nil))
+}
+
+func TestClientReplayer(t *testing.T) {
+	for _, transport := range []string{"ws", "http"} {
+		t.Run(transport, func(t *testing.T) {
+			replayer, err := NewClientReplayer([]Record{
+				agentToServer("recorded"),
+				serverToAgent(&protobufs.ServerToAgent{InstanceUid: "recorded", RemoteConfig: createRemoteConfig()}),
+			})
+			require.NoError(t, err)
+			defer replayer.Close()
+
+			var remoteConfigs int64
+			var c client.OpAMPClient
+			settings := types.StartSettings{
+				InstanceUid: "01G2DMN5SM2HFEG2SEPSBN4PBQ",
+				Callbacks: types.CallbacksStruct{
+					OnMessageFunc: func(ctx context.Context, msg *types.MessageData) {
+						if proto.Equal(msg.RemoteConfig, createRemoteConfig()) {
+							atomic.AddInt64(&remoteConfigs, 1)
+						}
+					},
+				},
+			}
+			if transport == "ws" {
+				c = client.NewWebSocket(nil)
+				settings.OpAMPServerURL = replayer.WebSocketURL()
+			} else {
+				c = client.NewHTTP(nil)
+				settings.OpAMPServerURL = replayer.HTTPURL()
+			}
+			require.NoError(t, c.SetAgentDescription(createAgentDescr()))
+			require.NoError(t, c.Start(context.Background(), settings))
+
+			select {
+			case <-replayer.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("the client sent no message")
+			}
+			eventually(t, func() bool { return atomic.LoadInt64(&remoteConfigs) == 1 })
+			require.NoError(t, c.Stop(context.Background()))
+
+			received := replayer.Received()
+			require.NotEmpty(t, received)
+			assert.EqualValues(t, settings.InstanceUid, received[0].InstanceUid)
+		})
+	}
+}
+
+func TestClientReplayerMultipleMessages(t *testing.T) {
+	first := createRemoteConfig()
+	second := createRemoteConfig()
+	second.ConfigHash = []byte{5, 6, 7, 8}
+	records := []Record{
+		agentToServer("recorded"),
+		serverToAgent(&protobufs.ServerToAgent{InstanceUid: "recorded", RemoteConfig: first}),
+		serverToAgent(&protobufs.ServerToAgent{InstanceUid: "recorded", RemoteConfig: second}),
+	}
+
+	tests := []struct {
+		transport     string
+		remoteConfigs int64
+	}{
+		// The messages are sent one by one.
+		{transport: "ws", remoteConfigs: 2},
+		// The messages are merged into one response, the later one wins.
+		{transport: "http", remoteConfigs: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.transport, func(t *testing.T) {
+			replayer, err := NewClientReplayer(records)
+			require.NoError(t, err)
+			defer replayer.Close()
+
+			var remoteConfigs int64
+			var lastRemoteConfig atomic.Value
+			var c client.OpAMPClient
+			settings := types.StartSettings{
+				InstanceUid: "01G2DMN5SM2HFEG2SEPSBN4PBQ",
+				Callbacks: types.CallbacksStruct{
+					OnMessageFunc: func(ctx context.Context, msg *types.MessageData) {
+						if msg.RemoteConfig != nil {
+							lastRemoteConfig.Store(msg.RemoteConfig)
+							atomic.AddInt64(&remoteConfigs, 1)
+						}
+					},
+				},
+			}
+			if test.transport == "ws" {
+				c = client.NewWebSocket(nil)
+				settings.OpAMPServerURL = replayer.WebSocketURL()
+			} else {
+				c = client.NewHTTP(nil)
+				settings.OpAMPServerURL = replayer.HTTPURL()
+			}
+			require.NoError(t, c.SetAgentDescription(createAgentDescr()))
+			require.NoError(t, c.Start(context.Background(), settings))
+
+			eventually(t, func() bool {
+				config, ok := lastRemoteConfig.Load().(*protobufs.AgentRemoteConfig)
+				return ok && proto.Equal(second, config)
+			})
+			require.NoError(t, c.Stop(context.Background()))
+			assert.EqualValues(t, test.remoteConfigs, atomic.LoadInt64(&remoteConfigs))
+		})
+	}
+}
+
+func TestReplayToServer(t *testing.T) {
+	var messages int64
+	settings := server.Settings{
+		Callbacks: server.CallbacksStruct{
+			OnMessageFunc: func(conn servertypes.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+				n := atomic.AddInt64(&messages, 1)
+				return &protobufs.ServerToAgent{InstanceUid: message.InstanceUid, Capabilities: protobufs.ServerCapabilities(n)}
+			},
+		},
+	}
+	srv := server.New(nil)
+	defer srv.Stop(context.Background())
+
+	responses, err := ReplayToServer(context.Background(), srv, settings, []Record{
+		agentToServer("1"),
+		serverToAgent(&protobufs.ServerToAgent{InstanceUid: "1"}),
+		agentToServer("2"),
+	})
+	require.NoError(t, err)
+	require.Len(t, responses, 2)
+	assert.True(t, proto.Equal(&protobufs.ServerToAgent{InstanceUid: "1", Capabilities: 1}, responses[0]))
+	assert.True(t, proto.Equal(&protobufs.ServerToAgent{InstanceUid: "2", Capabilities: 2}, responses[1]))
+}
+
+func TestReplayToServerConnections(t *testing.T) {
+	var mutex sync.Mutex
+	conns := map[string]servertypes.Connection{}
+	var closed int64
+	settings := server.Settings{
+		Callbacks: server.CallbacksStruct{
+			OnMessageFunc: func(conn servertypes.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+				mutex.Lock()
+				defer mutex.Unlock()
+				if existing, ok := conns[message.InstanceUid]; ok && existing != conn {
+					t.Errorf("messages of instance %s received over different connections", message.InstanceUid)
+				}
+				conns[message.InstanceUid] = conn
+				return &protobufs.ServerToAgent{InstanceUid: message.InstanceUid}
+			},
+			OnConnectionCloseFunc: func(conn servertypes.Connection) {
+				atomic.AddInt64(&closed, 1)
+			},
+		},
+	}
+	srv := server.New(nil)
+	defer srv.Stop(context.Background())
+
+	onConnection := func(connectionId uint64, record Record) Record {
+		record.ConnectionId = connectionId
+		return record
+	}
+	responses, err := ReplayToServer(context.Background(), srv, settings, []Record{
+		onConnection(1, agentToServer("1")),
+		onConnection(2, agentToServer("2")),
+		onConnection(1, agentToServer("1")),
+		onConnection(2, agentToServer("2")),
+	})
+	require.NoError(t, err)
+	require.Len(t, responses, 4)
+	for i, instanceUid := range []string{"1", "2", "1", "2"} {
+		assert.EqualValues(t, instanceUid, responses[i].InstanceUid)
+	}
+
+	// Every recorded connection is replayed over a separate connection, which is
+	// closed after its last message.
+	mutex.Lock()
+	assert.Len(t, conns, 2)
+	assert.True(t, conns["1"] != conns["2"])
+	mutex.Unlock()
+	assert.Eventually(t, func() bool { return atomic.LoadInt64(&closed) == 2 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestReplayToServerRejected(t *testing.T) {
+	settings := server.Settings{
+		Authenticator: server.NewBearerAuthenticator(map[string]string{"secret": "agent"}),
+	}
+	srv := server.New(nil)
+	defer srv.Stop(context.Background())
+
+	// The recorded messages carry no credentials.
+	responses, err := ReplayToServer(context.Background(), srv, settings, []Record{agentToServer("12345678")})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot replay record 0")
+	assert.Empty(t, responses)
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+const defaultRetryAfter = 30 * time.Second
+
+// admission limits the number of concurrent connections globally and per remote
+// IP address. It is safe to call methods of this struct concurrently.
+type admission struct {
+	maxTotal int
+	maxPerIP int
+
+	mutex sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+func newAdmission(maxTotal, maxPerIP int) *admission {
+	return &admission{maxTotal: maxTotal, maxPerIP: maxPerIP, perIP: map[string]int{}}
+}
+
+// acquire admits a connection from the address. Returns 0 if the connection is
+// admitted and release must be called when it is closed, otherwise returns the
+// HTTP status code to respond with: 503 if the Server is at its global limit,
+// 429 if the address is at its limit.
+func (a *admission) acquire(addr net.Addr) int {
+	ip := remoteIP(addr)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.maxTotal > 0 && a.total >= a.maxTotal {
+		return http.StatusServiceUnavailable
+	}
+	if a.maxPerIP > 0 && ip != "" && a.perIP[ip] >= a.maxPerIP {
+		return http.StatusTooManyRequests
+	}
+	a.total++
+	if ip != "" {
+		a.perIP[ip]++
+	}
+	return 0
+}
+
+// release releases the connection admitted by acquire.
+func (a *admission) release(addr net.Addr) {
+	ip := remoteIP(addr)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.total--
+	if ip != "" {
+		if a.perIP[ip] <= 1 {
+			delete(a.perIP, ip)
+		} else {
+			a.perIP[ip]--
+		}
+	}
+}
+
+// remoteIP returns the IP address of the Agent or empty string if the address
+// is not an IP address, e.g. for Unix domain sockets.
+func remoteIP(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	return ""
+}
+
+// tokenBucket limits the rate of the messages received from a connection. Not
+// safe for concurrent use.
+type tokenBucket struct {
+	// Tokens added per second.
+	rate float64
+	// Maximum number of tokens.
+	burst float64
+
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// take takes a token if there is one. Otherwise returns false and the duration
+// until the next token is available.
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	return false, wait
+}
+
+// writeRetryAfter writes the status code with Retry-After header that asks the
+// Agent to retry after the specified duration.
+func writeRetryAfter(w http.ResponseWriter, statusCode int, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(statusCode)
+}
+
+// unavailableResponse returns the response that asks the Agent to retry sending
+// the message after the specified duration.
+func unavailableResponse(instanceUid string, retryAfter time.Duration) *protobufs.ServerToAgent {
+	return &protobufs.ServerToAgent{
+		InstanceUid: instanceUid,
+		ErrorResponse: &protobufs.ServerErrorResponse{
+			Type:         protobufs.ServerErrorResponse_Unavailable,
+			ErrorMessage: "too many messages",
+			Details: &protobufs.ServerErrorResponse_RetryInfo{
+				RetryInfo: &protobufs.RetryInfo{RetryAfterNanoseconds: uint64(retryAfter)},
+			},
+		},
+	}
+}
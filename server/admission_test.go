@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/open-telemetry/opamp-go/internal"
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/server/types"
+)
+
+func TestAdmission(t *testing.T) {
+	a := newAdmission(3, 2)
+	addr1 := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}
+	addr2 := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 2}
+	unixAddr := &net.UnixAddr{Name: "/tmp/opamp.sock", Net: "unix"}
+
+	assert.EqualValues(t, 0, a.acquire(addr1))
+	assert.EqualValues(t, 0, a.acquire(addr1))
+	assert.EqualValues(t, http.StatusTooManyRequests, a.acquire(addr1))
+	assert.EqualValues(t, 0, a.acquire(addr2))
+	assert.EqualValues(t, http.StatusServiceUnavailable, a.acquire(addr2))
+
+	a.release(addr1)
+	assert.EqualValues(t, 0, a.acquire(unixAddr))
+	a.release(unixAddr)
+	assert.EqualValues(t, 0, a.acquire(addr1))
+}
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(2, 0)
+	now := time.Now()
+
+	ok, _ := b.take(now)
+	assert.True(t, ok)
+	ok, _ = b.take(now)
+	assert.True(t, ok)
+	ok, wait := b.take(now)
+	assert.False(t, ok)
+	assert.EqualValues(t, 500*time.Millisecond, wait)
+
+	ok, _ = b.take(now.Add(500 * time.Millisecond))
+	assert.True(t, ok)
+}
+
+func TestServerConnectionLimit(t *testing.T) {
+	settings := &StartSettings{Settings: Settings{MaxConnections: 1, RetryAfter: 10 * time.Second}}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	conn, _, err := dialClient(settings)
+	require.NoError(t, err)
+
+	// The second connection is shed.
+	_, resp, err := dialClient(settings)
+	assert.Error(t, err)
+	require.NotNil(t, resp)
+	assert.EqualValues(t, http.StatusServiceUnavailable, resp.StatusCode)
+	retryAfter := internal.ExtractRetryAfterHeader(resp)
+	assert.True(t, retryAfter.Defined)
+	assert.EqualValues(t, 10*time.Second, retryAfter.Duration)
+
+	// Closing the connection makes room for a new one.
+	conn.Close()
+	eventually(t, func() bool {
+		conn, _, err = dialClient(settings)
+		return err == nil
+	})
+	conn.Close()
+}
+
+func TestServerConnectionLimitPerIP(t *testing.T) {
+	settings := &StartSettings{Settings: Settings{MaxConnectionsPerIP: 1}}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	conn, _, err := dialClient(settings)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, resp, err := dialClient(settings)
+	assert.Error(t, err)
+	require.NotNil(t, resp)
+	assert.EqualValues(t, http.StatusTooManyRequests, resp.StatusCode)
+	retryAfter := internal.ExtractRetryAfterHeader(resp)
+	assert.True(t, retryAfter.Defined)
+	assert.EqualValues(t, defaultRetryAfter, retryAfter.Duration)
+}
+
+func TestServerMessageRateLimit(t *testing.T) {
+	var rcvCount int64
+	callbacks := CallbacksStruct{
+		OnMessageFunc: func(conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			atomic.AddInt64(&rcvCount, 1)
+			return &protobufs.ServerToAgent{}
+		},
+	}
+	settings := &StartSettings{
+		Settings: Settings{Callbacks: callbacks, MessageRateLimit: 0.1, MessageBurst: 2},
+	}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	conn, _, err := dialClient(settings)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for i := 0; i < 3; i++ {
+		b, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: "12345678"})
+		require.NoError(t, err)
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, b))
+	}
+
+	var responses []*protobufs.ServerToAgent
+	for i := 0; i < 3; i++ {
+		_, b, err := conn.ReadMessage()
+		require.NoError(t, err)
+		var response protobufs.ServerToAgent
+		require.NoError(t, proto.Unmarshal(b, &response))
+		responses = append(responses, &response)
+	}
+
+	// The burst is processed, the message over the limit is rejected.
+	assert.EqualValues(t, 2, atomic.LoadInt64(&rcvCount))
+	var rejected []*protobufs.ServerErrorResponse
+	for _, response := range responses {
+		if response.ErrorResponse != nil {
+			rejected = append(rejected, response.ErrorResponse)
+		}
+	}
+	require.Len(t, rejected, 1)
+	assert.EqualValues(t, protobufs.ServerErrorResponse_Unavailable, rejected[0].Type)
+	assert.Greater(t, rejected[0].GetRetryInfo().GetRetryAfterNanoseconds(), uint64(time.Second))
+	assert.EqualValues(t, 1, srv.Stats().RejectedRateLimited)
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	clienttypes "github.com/open-telemetry/opamp-go/client/types"
+	"github.com/open-telemetry/opamp-go/server/types"
+)
+
+var (
+	// ErrUnauthenticated is returned by Authenticator if the request has no valid
+	// credentials. The Server responds with 401 status and WWW-Authenticate header.
+	ErrUnauthenticated = errors.New("unauthenticated")
+
+	// ErrForbidden is returned by Authenticator if the credentials are valid but
+	// the Agent is not allowed to connect. The Server responds with 403 status.
+	ErrForbidden = errors.New("forbidden")
+
+	errMissingCredentials = fmt.Errorf("%w: missing credentials", ErrUnauthenticated)
+	errInvalidCredentials = fmt.Errorf("%w: invalid credentials", ErrUnauthenticated)
+)
+
+const (
+	headerAuthorization   = "Authorization"
+	headerWWWAuthenticate = "WWW-Authenticate"
+	defaultRealm          = "OpAMP"
+)
+
+// Authenticator authenticates the incoming connections before they are passed to
+// Callbacks.OnConnecting, see Settings.Authenticator.
+type Authenticator interface {
+	// Authenticate returns the principal of the Agent that sent the request.
+	// Returns an error wrapping ErrUnauthenticated if the credentials are missing or
+	// invalid, or an error wrapping ErrForbidden if the Agent is not allowed to
+	// connect. Other errors are treated as ErrUnauthenticated.
+	Authenticate(req *http.Request) (*types.Principal, error)
+
+	// Challenge returns the value of WWW-Authenticate header to send if
+	// Authenticate returned the specified error. Empty string means the header
+	// is not sent.
+	Challenge(err error) string
+}
+
+// TokenVerifierFunc verifies the bearer token and returns the principal the token
+// is issued for. See Authenticator.Authenticate for the errors to return.
+type TokenVerifierFunc func(ctx context.Context, token string) (*types.Principal, error)
+
+// BasicVerifierFunc verifies the user name and password and returns the principal.
+// See Authenticator.Authenticate for the errors to return.
+type BasicVerifierFunc func(ctx context.Context, username, password string) (*types.Principal, error)
+
+// CertVerifierFunc returns the principal of the verified client certificate.
+// See Authenticator.Authenticate for the errors to return.
+type CertVerifierFunc func(cert *x509.Certificate) (*types.Principal, error)
+
+type bearerAuthenticator struct {
+	verify TokenVerifierFunc
+}
+
+// NewBearerAuthenticator creates an Authenticator that accepts the bearer tokens
+// from the static list. tokens maps the accepted tokens to the principal names.
+func NewBearerAuthenticator(tokens map[string]string) Authenticator {
+	// Copy the tokens to protect from modifications by the caller.
+	accepted := make(map[string]string, len(tokens))
+	for token, name := range tokens {
+		accepted[token] = name
+	}
+
+	return NewBearerVerifierAuthenticator(
+		func(_ context.Context, token string) (*types.Principal, error) {
+			for acceptedToken, name := range accepted {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(acceptedToken)) == 1 {
+					return &types.Principal{Name: name}, nil
+				}
+			}
+			return nil, errInvalidCredentials
+		},
+	)
+}
+
+// NewBearerVerifierAuthenticator creates an Authenticator that accepts the bearer
+// tokens for which verify returns a principal, e.g. valid signed tokens.
+func NewBearerVerifierAuthenticator(verify TokenVerifierFunc) Authenticator {
+	return &bearerAuthenticator{verify: verify}
+}
+
+func (a *bearerAuthenticator) Authenticate(req *http.Request) (*types.Principal, error) {
+	token, ok := cutPrefixFold(req.Header.Get(headerAuthorization), "Bearer ")
+	if !ok || token == "" {
+		return nil, errMissingCredentials
+	}
+
+	principal, err := a.verify(req.Context(), strings.TrimSpace(token))
+	if err != nil {
+		return nil, err
+	}
+	if principal == nil {
+		return nil, errInvalidCredentials
+	}
+	return withMethod(principal, "bearer"), nil
+}
+
+func (a *bearerAuthenticator) Challenge(err error) string {
+	if errors.Is(err, errMissingCredentials) {
+		return fmt.Sprintf("Bearer realm=%q", defaultRealm)
+	}
+	return fmt.Sprintf(`Bearer realm=%q, error="invalid_token"`, defaultRealm)
+}
+
+type basicAuthenticator struct {
+	realm  string
+	verify BasicVerifierFunc
+}
+
+// NewBasicAuthenticator creates an Authenticator that uses HTTP Basic authentication.
+// realm is sent in the WWW-Authenticate header, "OpAMP" is used if it is empty.
+func NewBasicAuthenticator(realm string, verify BasicVerifierFunc) Authenticator {
+	if realm == "" {
+		realm = defaultRealm
+	}
+	return &basicAuthenticator{realm: realm, verify: verify}
+}
+
+func (a *basicAuthenticator) Authenticate(req *http.Request) (*types.Principal, error) {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, errMissingCredentials
+	}
+
+	principal, err := a.verify(req.Context(), username, password)
+	if err != nil {
+		return nil, err
+	}
+	if principal == nil {
+		return nil, errInvalidCredentials
+	}
+	return withMethod(principal, "basic"), nil
+}
+
+func (a *basicAuthenticator) Challenge(error) string {
+	return fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, a.realm)
+}
+
+type clientCertAuthenticator struct {
+	verify CertVerifierFunc
+}
+
+// NewClientCertAuthenticator creates an Authenticator that authenticates the
+// Agents by their TLS client certificates. The certificates must be verified by
+// the TLS configuration of the Server, e.g. using tls.RequireAndVerifyClientCert.
+// If verify is nil the principal name is the common name of the certificate
+// subject. This authenticator does not work if TLS is terminated by a proxy in
+// front of the Server.
+func NewClientCertAuthenticator(verify CertVerifierFunc) Authenticator {
+	if verify == nil {
+		verify = func(cert *x509.Certificate) (*types.Principal, error) {
+			return &types.Principal{Name: cert.Subject.CommonName}, nil
+		}
+	}
+	return &clientCertAuthenticator{verify: verify}
+}
+
+func (a *clientCertAuthenticator) Authenticate(req *http.Request) (*types.Principal, error) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil, errMissingCredentials
+	}
+
+	principal, err := a.verify(req.TLS.VerifiedChains[0][0])
+	if err != nil {
+		return nil, err
+	}
+	if principal == nil {
+		return nil, errInvalidCredentials
+	}
+	return withMethod(principal, "mtls"), nil
+}
+
+func (a *clientCertAuthenticator) Challenge(error) string {
+	// There is no HTTP authentication scheme for TLS client certificates.
+	return ""
+}
+
+// authenticate authenticates the request using the configured Authenticator.
+// Returns the request carrying the principal in its context. If authentication
+// fails writes the error response and returns false.
+func (s *server) authenticate(w http.ResponseWriter, req *http.Request) (*http.Request, *types.Principal, bool) {
+	if s.settings.Authenticator == nil {
+		return req, nil, true
+	}
+
+	principal, err := s.settings.Authenticator.Authenticate(req)
+	if err == nil {
+		return req.WithContext(types.ContextWithPrincipal(req.Context(), principal)), principal, true
+	}
+
+	if errors.Is(err, ErrForbidden) {
+		s.logger.Debug("Connection is forbidden", clienttypes.F("error", err))
+		s.metrics.rejectConnection(reasonForbidden)
+	} else {
+		s.logger.Debug("Connection is not authenticated", clienttypes.F("error", err))
+		s.metrics.rejectConnection(reasonUnauthenticated)
+	}
+	s.writeAuthError(w, err)
+	return nil, nil, false
+}
+
+// writeAuthError responds the request with 403 status if the error returned by
+// the Authenticator wraps ErrForbidden, otherwise with 401 status and the
+// challenge of the Authenticator, if any.
+func (s *server) writeAuthError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrForbidden) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if challenge := s.settings.Authenticator.Challenge(err); challenge != "" {
+		w.Header().Set(headerWWWAuthenticate, challenge)
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+// withMethod returns a copy of the principal with the Method set.
+func withMethod(principal *types.Principal, method string) *types.Principal {
+	p := *principal
+	p.Method = method
+	return &p
+}
+
+// cutPrefixFold returns s without the case-insensitive prefix and true if s
+// starts with the prefix.
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
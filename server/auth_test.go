@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/server/types"
+)
+
+func TestBearerAuthenticator(t *testing.T) {
+	auth := NewBearerAuthenticator(map[string]string{"secret": "agent1"})
+
+	req := &http.Request{Header: http.Header{}}
+	_, err := auth.Authenticate(req)
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	assert.EqualValues(t, `Bearer realm="OpAMP"`, auth.Challenge(err))
+
+	req.Header.Set("Authorization", "Bearer wrong")
+	_, err = auth.Authenticate(req)
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	assert.EqualValues(t, `Bearer realm="OpAMP", error="invalid_token"`, auth.Challenge(err))
+
+	req.Header.Set("Authorization", "bearer secret")
+	principal, err := auth.Authenticate(req)
+	require.NoError(t, err)
+	assert.EqualValues(t, types.Principal{Name: "agent1", Method: "bearer"}, *principal)
+}
+
+func TestBasicAuthenticator(t *testing.T) {
+	auth := NewBasicAuthenticator("", func(_ context.Context, username, password string) (*types.Principal, error) {
+		if username == "agent1" && password == "secret" {
+			return &types.Principal{Name: username}, nil
+		}
+		return nil, ErrUnauthenticated
+	})
+	assert.EqualValues(t, `Basic realm="OpAMP", charset="UTF-8"`, auth.Challenge(ErrUnauthenticated))
+
+	req := &http.Request{Header: http.Header{}}
+	_, err := auth.Authenticate(req)
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	req.SetBasicAuth("agent1", "wrong")
+	_, err = auth.Authenticate(req)
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	req.SetBasicAuth("agent1", "secret")
+	principal, err := auth.Authenticate(req)
+	require.NoError(t, err)
+	assert.EqualValues(t, types.Principal{Name: "agent1", Method: "basic"}, *principal)
+}
+
+func TestClientCertAuthenticator(t *testing.T) {
+	auth := NewClientCertAuthenticator(nil)
+
+	// No TLS or no verified client certificate.
+	_, err := auth.Authenticate(&http.Request{})
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	_, err = auth.Authenticate(&http.Request{TLS: &tls.ConnectionState{}})
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	assert.EqualValues(t, "", auth.Challenge(err))
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "agent1"}}
+	req := &http.Request{TLS: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}}
+	principal, err := auth.Authenticate(req)
+	require.NoError(t, err)
+	assert.EqualValues(t, types.Principal{Name: "agent1", Method: "mtls"}, *principal)
+}
+
+func TestServerAuthentication(t *testing.T) {
+	var connPrincipal, connectingPrincipal atomic.Value
+	callbacks := CallbacksStruct{
+		OnConnectingFunc: func(request *http.Request) types.ConnectionResponse {
+			connectingPrincipal.Store(types.PrincipalFromContext(request.Context()))
+			return types.ConnectionResponse{Accept: true}
+		},
+		OnMessageFunc: func(conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			connPrincipal.Store(conn.Principal())
+			return &protobufs.ServerToAgent{}
+		},
+	}
+
+	// Start a Server that accepts one token and forbids another.
+	authenticator := NewBearerVerifierAuthenticator(func(_ context.Context, token string) (*types.Principal, error) {
+		switch token {
+		case "secret":
+			return &types.Principal{Name: "agent1"}, nil
+		case "banned":
+			return nil, ErrForbidden
+		}
+		return nil, ErrUnauthenticated
+	})
+	settings := &StartSettings{Settings: Settings{Callbacks: callbacks, Authenticator: authenticator}}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	post := func(token string) *http.Response {
+		b, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: "12345678"})
+		require.NoError(t, err)
+		req, err := http.NewRequest("POST", "http://"+settings.ListenEndpoint+settings.ListenPath, bytes.NewReader(b))
+		require.NoError(t, err)
+		req.Header.Set(headerContentType, contentTypeProtobuf)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := post("")
+	assert.EqualValues(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.EqualValues(t, `Bearer realm="OpAMP"`, resp.Header.Get("WWW-Authenticate"))
+
+	resp = post("wrong")
+	assert.EqualValues(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.EqualValues(t, `Bearer realm="OpAMP", error="invalid_token"`, resp.Header.Get("WWW-Authenticate"))
+
+	resp = post("banned")
+	assert.EqualValues(t, http.StatusForbidden, resp.StatusCode)
+	assert.Nil(t, connPrincipal.Load())
+
+	resp = post("secret")
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+	expected := &types.Principal{Name: "agent1", Method: "bearer"}
+	assert.EqualValues(t, expected, connPrincipal.Load())
+	assert.EqualValues(t, expected, connectingPrincipal.Load())
+
+	// WebSocket connections are authenticated too.
+	_, wsResp, err := dialClient(settings)
+	assert.Error(t, err)
+	assert.EqualValues(t, http.StatusUnauthorized, wsResp.StatusCode)
+}
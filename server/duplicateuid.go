@@ -0,0 +1,86 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/open-telemetry/opamp-go/server/types"
+)
+
+// DuplicateInstanceUidAction defines what the Server does when an Agent uses the
+// instance UID of another live connection, e.g. because the Agent runs on a
+// clone of the VM image of another Agent.
+type DuplicateInstanceUidAction int
+
+const (
+	// AllowDuplicateInstanceUid passes the message to OnMessage. The messages
+	// enqueued for the instance UID are delivered over the newest connection.
+	AllowDuplicateInstanceUid DuplicateInstanceUidAction = iota
+
+	// RejectDuplicateInstanceUid responds to the newest connection with
+	// ServerErrorResponse of BadRequest type without calling OnMessage and closes
+	// the connection.
+	RejectDuplicateInstanceUid
+
+	// ReIdentifyDuplicateInstanceUid responds to the newest connection with
+	// ServerErrorResponse of BadRequest type and AgentIdentification with a newly
+	// generated instance UID without calling OnMessage.
+	ReIdentifyDuplicateInstanceUid
+)
+
+// DuplicateInstanceUidFunc decides what to do when the duplicate connection uses
+// the instance UID of the existing live connection, see Settings.OnDuplicateInstanceUid.
+type DuplicateInstanceUidFunc func(
+	existing types.Connection, duplicate types.Connection, instanceUid string,
+) DuplicateInstanceUidAction
+
+// liveInstances tracks the instance UIDs used by the live WebSocket connections.
+// It is safe to call methods of this struct concurrently.
+type liveInstances struct {
+	mutex sync.Mutex
+	conns map[string]types.Connection
+}
+
+func newLiveInstances() *liveInstances {
+	return &liveInstances{conns: map[string]types.Connection{}}
+}
+
+// claim records that the connection uses the instance UID unless a different
+// connection already uses it, in which case the other connection is returned.
+func (l *liveInstances) claim(instanceUid string, conn types.Connection) types.Connection {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if existing := l.conns[instanceUid]; existing != nil && existing != conn {
+		return existing
+	}
+	l.conns[instanceUid] = conn
+	return nil
+}
+
+// lookup returns the connection that uses the instance UID, nil if there is none.
+func (l *liveInstances) lookup(instanceUid string) types.Connection {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.conns[instanceUid]
+}
+
+// replace records that the connection uses the instance UID instead of the
+// connection that used it so far.
+func (l *liveInstances) replace(instanceUid string, conn types.Connection) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.conns[instanceUid] = conn
+}
+
+// release forgets that the connection uses the instance UID. Does nothing if a
+// different connection uses the instance UID.
+func (l *liveInstances) release(instanceUid string, conn types.Connection) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.conns[instanceUid] == conn {
+		delete(l.conns, instanceUid)
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/server/types"
+)
+
+func TestLiveInstances(t *testing.T) {
+	l := newLiveInstances()
+	conn1 := &wsConnection{}
+	conn2 := &wsConnection{}
+
+	assert.Nil(t, l.claim("uid1", conn1))
+	assert.Nil(t, l.claim("uid1", conn1))
+	assert.True(t, l.claim("uid1", conn2) == conn1)
+
+	// Releasing by a different connection does nothing.
+	l.release("uid1", conn2)
+	assert.True(t, l.lookup("uid1") == conn1)
+
+	l.release("uid1", conn1)
+	assert.Nil(t, l.lookup("uid1"))
+	assert.Nil(t, l.claim("uid1", conn2))
+}
+
+// wsExchange sends the message over the WebSocket connection and returns the
+// response.
+func wsExchange(t *testing.T, conn *websocket.Conn, instanceUid string) *protobufs.ServerToAgent {
+	b, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: instanceUid})
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, b))
+
+	_, b, err = conn.ReadMessage()
+	require.NoError(t, err)
+	var response protobufs.ServerToAgent
+	require.NoError(t, proto.Unmarshal(b, &response))
+	return &response
+}
+
+func TestServerDuplicateInstanceUid(t *testing.T) {
+	var rcvCount int64
+	var action atomic.Value
+	callbacks := CallbacksStruct{
+		OnMessageFunc: func(conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			atomic.AddInt64(&rcvCount, 1)
+			return &protobufs.ServerToAgent{}
+		},
+	}
+	onDuplicate := func(existing, duplicate types.Connection, instanceUid string) DuplicateInstanceUidAction {
+		assert.True(t, existing != duplicate)
+		assert.EqualValues(t, "uid1", instanceUid)
+		return action.Load().(DuplicateInstanceUidAction)
+	}
+	settings := &StartSettings{Settings: Settings{Callbacks: callbacks, OnDuplicateInstanceUid: onDuplicate}}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	conn1, _, err := dialClient(settings)
+	require.NoError(t, err)
+	defer conn1.Close()
+	response := wsExchange(t, conn1, "uid1")
+	assert.Nil(t, response.ErrorResponse)
+
+	// The duplicate is re-identified and may continue with the new instance UID.
+	action.Store(ReIdentifyDuplicateInstanceUid)
+	conn2, _, err := dialClient(settings)
+	require.NoError(t, err)
+	defer conn2.Close()
+	response = wsExchange(t, conn2, "uid1")
+	assert.EqualValues(t, protobufs.ServerErrorResponse_BadRequest, response.ErrorResponse.GetType())
+	newUid := response.AgentIdentification.GetNewInstanceUid()
+	require.NotEmpty(t, newUid)
+	assert.NotZero(t, response.Flags&protobufs.ServerToAgent_ReportAgentDescription)
+	response = wsExchange(t, conn2, newUid)
+	assert.Nil(t, response.ErrorResponse)
+	assert.EqualValues(t, 2, atomic.LoadInt64(&rcvCount))
+
+	// The duplicate is rejected and disconnected.
+	action.Store(RejectDuplicateInstanceUid)
+	conn3, _, err := dialClient(settings)
+	require.NoError(t, err)
+	defer conn3.Close()
+	response = wsExchange(t, conn3, "uid1")
+	assert.EqualValues(t, protobufs.ServerErrorResponse_BadRequest, response.ErrorResponse.GetType())
+	assert.Nil(t, response.AgentIdentification)
+	_, _, err = conn3.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation))
+	assert.EqualValues(t, 2, atomic.LoadInt64(&rcvCount))
+
+	// Plain HTTP requests are checked against the live connections.
+	b, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: "uid1"})
+	require.NoError(t, err)
+	resp, err := http.Post("http://"+settings.ListenEndpoint+settings.ListenPath, contentTypeProtobuf, bytes.NewReader(b))
+	require.NoError(t, err)
+	b, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	response = &protobufs.ServerToAgent{}
+	require.NoError(t, proto.Unmarshal(b, response))
+	assert.EqualValues(t, protobufs.ServerErrorResponse_BadRequest, response.ErrorResponse.GetType())
+	assert.EqualValues(t, 2, atomic.LoadInt64(&rcvCount))
+
+	// Duplicates are accepted if allowed.
+	action.Store(AllowDuplicateInstanceUid)
+	conn4, _, err := dialClient(settings)
+	require.NoError(t, err)
+	defer conn4.Close()
+	response = wsExchange(t, conn4, "uid1")
+	assert.Nil(t, response.ErrorResponse)
+	assert.EqualValues(t, 3, atomic.LoadInt64(&rcvCount))
+
+	// Once the connections are closed the instance UID is free again.
+	conn1.Close()
+	conn4.Close()
+	eventually(t, func() bool { return srv.instances.lookup("uid1") == nil })
+}
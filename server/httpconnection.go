@@ -7,26 +7,107 @@ import (
 
 	"github.com/open-telemetry/opamp-go/protobufs"
 	"github.com/open-telemetry/opamp-go/server/types"
+	"github.com/open-telemetry/opamp-go/tracecontext"
 )
 
-var errInvalidHTTPConnection = errors.New("cannot Send() over HTTP connection")
+var (
+	errHTTPConnectionClosed = errors.New("HTTP request is already responded")
+	errHTTPRequestNotHeld   = errors.New("HTTP request is not held, the response must be returned by OnMessage")
+)
 
 // httpConnection represents an OpAMP connection over a plain HTTP connection.
 // Only one response is possible to send when using plain HTTP connection
 // and that response will be sent by OpAMP Server's HTTP request handler after the
 // onMessage callback returns.
+//
+// If the onMessage callback returns nil the request handler holds the request and
+// the response can be sent using Send() until the timeout elapses. Send() fails
+// immediately while the onMessage callback is in progress, since the request
+// handler cannot accept the message before the callback returns. Long-poll
+// requests are held without calling the callbacks, the outbox sends the enqueued
+// messages using Send().
 type httpConnection struct {
-	conn net.Conn
+	remoteAddr   net.Addr
+	principal    *types.Principal
+	traceContext tracecontext.TraceContext
+
+	// The context of the request and then of the message being processed.
+	message *messageContext
+
+	// The channel to pass the response from Send() to the request handler.
+	response chan *protobufs.ServerToAgent
+
+	// Closed when the onMessage callback returns. After that the request handler
+	// either holds the request or responds it.
+	handled chan struct{}
+
+	// Closed when the request is responded.
+	responded chan struct{}
+}
+
+func newHTTPConnection(
+	ctx context.Context,
+	remoteAddr net.Addr,
+	principal *types.Principal,
+	traceContext tracecontext.TraceContext,
+) *httpConnection {
+	return &httpConnection{
+		remoteAddr:   remoteAddr,
+		principal:    principal,
+		traceContext: traceContext,
+		message:      newMessageContext(ctx),
+		response:     make(chan *protobufs.ServerToAgent),
+		handled:      make(chan struct{}),
+		responded:    make(chan struct{}),
+	}
+}
+
+func (c *httpConnection) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *httpConnection) Principal() *types.Principal {
+	return c.principal
+}
+
+func (c *httpConnection) TraceContext() tracecontext.TraceContext {
+	return c.traceContext
 }
 
-func (c httpConnection) RemoteAddr() net.Addr {
-	return c.conn.RemoteAddr()
+func (c *httpConnection) Context() context.Context {
+	return c.message.get()
 }
 
 var _ types.Connection = (*httpConnection)(nil)
 
-func (c httpConnection) Send(_ context.Context, _ *protobufs.ServerToAgent) error {
-	// Send() should not be called for plain HTTP connection. Instead, the response will
-	// be sent after the onMessage callback returns.
-	return errInvalidHTTPConnection
+// send delivers the message enqueued for the Agent, see outboxConn. The message
+// is the response to the held request.
+func (c *httpConnection) send(ctx context.Context, message *protobufs.ServerToAgent) error {
+	return c.Send(ctx, message)
+}
+
+// Send delivers the message as the response to the held request. Blocks until
+// the request handler accepts the message. Returns an error if the request is
+// already responded or the onMessage callback did not return yet, e.g. if Send()
+// is called by the callback.
+func (c *httpConnection) Send(ctx context.Context, message *protobufs.ServerToAgent) error {
+	select {
+	case <-c.handled:
+	default:
+		select {
+		case <-c.responded:
+			return errHTTPConnectionClosed
+		default:
+			return errHTTPRequestNotHeld
+		}
+	}
+
+	select {
+	case c.response <- message:
+		return nil
+	case <-c.responded:
+		return errHTTPConnectionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
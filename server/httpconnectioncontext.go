@@ -19,5 +19,8 @@ func contextWithConn(ctx context.Context, c net.Conn) context.Context {
 }
 func connFromRequest(r *http.Request) net.Conn {
 	// Extract the net.Conn from the context of the specified http.Request.
-	return r.Context().Value(connContextKey).(net.Conn)
+	// Returns nil if the http.Server does not use contextWithConn, e.g. if it is
+	// created by the user in Attach mode.
+	conn, _ := r.Context().Value(connContextKey).(net.Conn)
+	return conn
 }
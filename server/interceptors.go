@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/server/types"
+)
+
+// ConnectingHandler handles a new incoming connection, see types.Callbacks.OnConnecting.
+type ConnectingHandler func(request *http.Request) types.ConnectionResponse
+
+// ConnectionHandler handles a connection event, see types.Callbacks.OnConnected
+// and types.Callbacks.OnConnectionClose.
+type ConnectionHandler func(conn types.Connection)
+
+// MessageHandler handles a message received from the Agent, see types.Callbacks.OnMessage.
+type MessageHandler func(conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent
+
+// Interceptor intercepts the calls of the Callbacks. Each func receives the next
+// handler of the chain, which is the next interceptor or the Callbacks. A func
+// may modify the arguments before calling next and the result after calling next,
+// or may short-circuit the chain by not calling next, e.g. by returning a
+// rejecting ConnectionResponse or a ServerToAgent message with ErrorResponse set.
+// All funcs are optional, nil funcs pass the calls through.
+type Interceptor struct {
+	OnConnecting      func(request *http.Request, next ConnectingHandler) types.ConnectionResponse
+	OnConnected       func(conn types.Connection, next ConnectionHandler)
+	OnMessage         func(conn types.Connection, message *protobufs.AgentToServer, next MessageHandler) *protobufs.ServerToAgent
+	OnConnectionClose func(conn types.Connection, next ConnectionHandler)
+}
+
+// ChainCallbacks returns the Callbacks that call the interceptors in order and
+// then the callbacks. The first interceptor is the outermost one. nil callbacks
+// behave like an empty CallbacksStruct.
+func ChainCallbacks(callbacks types.Callbacks, interceptors ...Interceptor) types.Callbacks {
+	if callbacks == nil {
+		callbacks = CallbacksStruct{}
+	}
+
+	onConnecting := ConnectingHandler(callbacks.OnConnecting)
+	onConnected := ConnectionHandler(callbacks.OnConnected)
+	onMessage := MessageHandler(callbacks.OnMessage)
+	onConnectionClose := ConnectionHandler(callbacks.OnConnectionClose)
+
+	// Wrap the handlers starting from the innermost interceptor.
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor := interceptors[i]
+		if interceptor.OnConnecting != nil {
+			next := onConnecting
+			onConnecting = func(request *http.Request) types.ConnectionResponse {
+				return interceptor.OnConnecting(request, next)
+			}
+		}
+		if interceptor.OnConnected != nil {
+			next := onConnected
+			onConnected = func(conn types.Connection) {
+				interceptor.OnConnected(conn, next)
+			}
+		}
+		if interceptor.OnMessage != nil {
+			next := onMessage
+			onMessage = func(conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+				return interceptor.OnMessage(conn, message, next)
+			}
+		}
+		if interceptor.OnConnectionClose != nil {
+			next := onConnectionClose
+			onConnectionClose = func(conn types.Connection) {
+				interceptor.OnConnectionClose(conn, next)
+			}
+		}
+	}
+
+	return CallbacksStruct{
+		OnConnectingFunc:      onConnecting,
+		OnConnectedFunc:       onConnected,
+		OnMessageFunc:         onMessage,
+		OnConnectionCloseFunc: onConnectionClose,
+	}
+}
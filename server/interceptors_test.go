@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/server/types"
+)
+
+func TestChainCallbacksOrder(t *testing.T) {
+	var calls []string
+	tracing := func(name string) Interceptor {
+		return Interceptor{
+			OnConnected: func(conn types.Connection, next ConnectionHandler) {
+				calls = append(calls, name+" before")
+				next(conn)
+				calls = append(calls, name+" after")
+			},
+		}
+	}
+	callbacks := CallbacksStruct{
+		OnConnectedFunc: func(conn types.Connection) { calls = append(calls, "callbacks") },
+	}
+
+	chained := ChainCallbacks(callbacks, tracing("first"), Interceptor{}, tracing("second"))
+	chained.OnConnected(nil)
+	assert.EqualValues(t, []string{"first before", "second before", "callbacks", "second after", "first after"}, calls)
+}
+
+func TestChainCallbacksModifyAndShortCircuit(t *testing.T) {
+	callbacks := CallbacksStruct{
+		OnMessageFunc: func(conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			return &protobufs.ServerToAgent{InstanceUid: message.InstanceUid}
+		},
+	}
+	interceptor := Interceptor{
+		OnConnecting: func(request *http.Request, next ConnectingHandler) types.ConnectionResponse {
+			if request.Header.Get("X-Banned") != "" {
+				return types.ConnectionResponse{Accept: false, HTTPStatusCode: http.StatusForbidden}
+			}
+			return next(request)
+		},
+		OnMessage: func(
+			conn types.Connection, message *protobufs.AgentToServer, next MessageHandler,
+		) *protobufs.ServerToAgent {
+			if message.InstanceUid == "" {
+				return &protobufs.ServerToAgent{
+					ErrorResponse: &protobufs.ServerErrorResponse{Type: protobufs.ServerErrorResponse_BadRequest},
+				}
+			}
+			response := next(conn, message)
+			response.Capabilities = protobufs.ServerCapabilities_AcceptsStatus
+			return response
+		},
+	}
+	chained := ChainCallbacks(callbacks, interceptor)
+
+	request := &http.Request{Header: http.Header{}}
+	assert.True(t, chained.OnConnecting(request).Accept)
+	request.Header.Set("X-Banned", "true")
+	assert.EqualValues(t, http.StatusForbidden, chained.OnConnecting(request).HTTPStatusCode)
+
+	response := chained.OnMessage(nil, &protobufs.AgentToServer{InstanceUid: "uid"})
+	assert.EqualValues(t, "uid", response.InstanceUid)
+	assert.EqualValues(t, protobufs.ServerCapabilities_AcceptsStatus, response.Capabilities)
+
+	response = chained.OnMessage(nil, &protobufs.AgentToServer{})
+	assert.EqualValues(t, protobufs.ServerErrorResponse_BadRequest, response.ErrorResponse.GetType())
+}
+
+func TestServerInterceptors(t *testing.T) {
+	var mutex sync.Mutex
+	var events []string
+	record := func(event string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		events = append(events, event)
+	}
+	interceptor := Interceptor{
+		OnConnected: func(conn types.Connection, next ConnectionHandler) {
+			record("connected")
+			next(conn)
+		},
+		OnMessage: func(
+			conn types.Connection, message *protobufs.AgentToServer, next MessageHandler,
+		) *protobufs.ServerToAgent {
+			record("message")
+			return next(conn, message)
+		},
+		OnConnectionClose: func(conn types.Connection, next ConnectionHandler) {
+			record("closed")
+			next(conn)
+		},
+	}
+
+	// The interceptors work without Callbacks.
+	settings := &StartSettings{Settings: Settings{Interceptors: []Interceptor{interceptor}}}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	conn, _, err := dialClient(settings)
+	require.NoError(t, err)
+	b, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: "uid"})
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, b))
+	_, b, err = conn.ReadMessage()
+	require.NoError(t, err)
+	var response protobufs.ServerToAgent
+	require.NoError(t, proto.Unmarshal(b, &response))
+	assert.EqualValues(t, "uid", response.InstanceUid)
+	conn.Close()
+
+	eventually(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(events) == 3
+	})
+	assert.EqualValues(t, []string{"connected", "message", "closed"}, events)
+}
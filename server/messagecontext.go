@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// messageContext holds the context of the message being processed by a
+// connection and the func that ends the span of the message, if the span must
+// end after the response is sent asynchronously. It is safe to call methods of
+// this struct concurrently.
+type messageContext struct {
+	mutex sync.Mutex
+
+	// The context of the current or the last processed message.
+	ctx context.Context
+
+	// Ends the span of the message whose response is not sent yet, nil if none.
+	endSpan func()
+}
+
+func newMessageContext(ctx context.Context) *messageContext {
+	return &messageContext{ctx: ctx}
+}
+
+func (m *messageContext) get() context.Context {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.ctx
+}
+
+// start makes ctx the context of the message being processed. Ends the span of
+// the previous message if its response was not sent.
+func (m *messageContext) start(ctx context.Context) {
+	m.responded()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.ctx = ctx
+}
+
+// respondLater remembers to end the span when the response is sent, see responded.
+func (m *messageContext) respondLater(endSpan func()) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.endSpan = endSpan
+}
+
+// responded ends the span of the message whose response is sent asynchronously,
+// if any. Called when the response to the message is sent, when it can't be sent
+// or when the connection is closed.
+func (m *messageContext) responded() {
+	m.mutex.Lock()
+	endSpan := m.endSpan
+	m.endSpan = nil
+	m.mutex.Unlock()
+
+	if endSpan != nil {
+		endSpan()
+	}
+}
+
+// detachedContext carries the values of the parent context but is never
+// cancelled, e.g. to keep the values of the context of the request upgraded to
+// a WebSocket connection that outlives the request.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (c detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
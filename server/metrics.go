@@ -0,0 +1,159 @@
+package server
+
+import (
+	"time"
+
+	"github.com/open-telemetry/opamp-go/instrumentation"
+)
+
+// The attributes of the measurements.
+var (
+	attrTransportWS       = instrumentation.Attribute{Key: "transport", Value: "ws"}
+	attrTransportHTTP     = instrumentation.Attribute{Key: "transport", Value: "http"}
+	attrDirectionSent     = instrumentation.Attribute{Key: "direction", Value: "sent"}
+	attrDirectionReceived = instrumentation.Attribute{Key: "direction", Value: "received"}
+)
+
+// The reasons of the message rejections, the values of the "reason" attribute.
+const (
+	reasonTooLarge    = "too_large"
+	reasonMalformed   = "malformed"
+	reasonRateLimited = "rate_limited"
+	reasonInvalid     = "invalid"
+)
+
+// The reasons of the connection rejections, the values of the "reason" attribute.
+const (
+	reasonConnectionLimit = "connection_limit"
+	reasonUnauthenticated = "unauthenticated"
+	reasonForbidden       = "forbidden"
+	reasonRefused         = "refused"
+)
+
+// The callbacks which duration is measured, the values of the "callback" attribute.
+var (
+	attrCallbackOnConnecting = instrumentation.Attribute{Key: "callback", Value: "OnConnecting"}
+	attrCallbackOnMessage    = instrumentation.Attribute{Key: "callback", Value: "OnMessage"}
+)
+
+// metrics are the instruments that report the telemetry of the Server, see the
+// instrumentation package for the list.
+type metrics struct {
+	connectionsActive   instrumentation.Gauge
+	connectionsRejected instrumentation.Counter
+	httpRequests        instrumentation.Counter
+	messagesReceived    instrumentation.Counter
+	messagesSent        instrumentation.Counter
+	messagesRejected    instrumentation.Counter
+	messageFieldSize    instrumentation.Histogram
+	callbackDuration    instrumentation.Histogram
+}
+
+// newMetrics creates the instruments using the meter. nil meter discards the
+// measurements.
+func newMetrics(meter instrumentation.Meter) *metrics {
+	if meter == nil {
+		meter = instrumentation.NopMeter{}
+	}
+	return &metrics{
+		connectionsActive: meter.Gauge(
+			"opamp.server.connections.active", "{connection}",
+			"The number of WebSocket connections and plain HTTP requests being processed.",
+		),
+		connectionsRejected: meter.Counter(
+			"opamp.server.connections.rejected", "{connection}",
+			"The number of connections rejected before the OpAMP messages are exchanged.",
+		),
+		httpRequests: meter.Counter(
+			"opamp.server.http.requests", "{request}", "The number of plain HTTP requests received from the Agents.",
+		),
+		messagesReceived: meter.Counter(
+			"opamp.server.messages.received", "{message}", "The number of messages received from the Agents.",
+		),
+		messagesSent: meter.Counter(
+			"opamp.server.messages.sent", "{message}", "The number of messages sent to the Agents.",
+		),
+		messagesRejected: meter.Counter(
+			"opamp.server.messages.rejected", "{message}", "The number of messages rejected by the Server.",
+		),
+		messageFieldSize: meter.Histogram(
+			"opamp.server.message.field.size", "By", "The encoded size of the fields of the exchanged messages.",
+		),
+		callbackDuration: meter.Histogram(
+			"opamp.server.callback.duration", "s", "The duration of the Callbacks calls.",
+		),
+	}
+}
+
+// rejectConnection counts the connection rejected for the reason.
+func (m *metrics) rejectConnection(reason string) {
+	m.connectionsRejected.Add(1, instrumentation.Attribute{Key: "reason", Value: reason})
+}
+
+// timeCallback records the duration of the callback call started at start.
+func (m *metrics) timeCallback(start time.Time, callback instrumentation.Attribute) {
+	m.callbackDuration.Record(time.Since(start).Seconds(), callback)
+}
+
+// teeMeter is a Meter that reports the measurements to several meters.
+type teeMeter []instrumentation.Meter
+
+// newTeeMeter returns a Meter that reports the measurements to all non-nil meters.
+func newTeeMeter(meters ...instrumentation.Meter) instrumentation.Meter {
+	var tee teeMeter
+	for _, meter := range meters {
+		if meter != nil {
+			tee = append(tee, meter)
+		}
+	}
+	if len(tee) == 1 {
+		return tee[0]
+	}
+	return tee
+}
+
+func (t teeMeter) Counter(name, unit, description string) instrumentation.Counter {
+	var tee teeInstrument
+	for _, meter := range t {
+		tee.counters = append(tee.counters, meter.Counter(name, unit, description))
+	}
+	return tee
+}
+
+func (t teeMeter) Histogram(name, unit, description string) instrumentation.Histogram {
+	var tee teeInstrument
+	for _, meter := range t {
+		tee.histograms = append(tee.histograms, meter.Histogram(name, unit, description))
+	}
+	return tee
+}
+
+func (t teeMeter) Gauge(name, unit, description string) instrumentation.Gauge {
+	var tee teeInstrument
+	for _, meter := range t {
+		tee.gauges = append(tee.gauges, meter.Gauge(name, unit, description))
+	}
+	return tee
+}
+
+// teeInstrument is a counter, a gauge or a histogram of a teeMeter.
+type teeInstrument struct {
+	counters   []instrumentation.Counter
+	gauges     []instrumentation.Gauge
+	histograms []instrumentation.Histogram
+}
+
+func (t teeInstrument) Add(value int64, attrs ...instrumentation.Attribute) {
+	for _, counter := range t.counters {
+		counter.Add(value, attrs...)
+	}
+	for _, gauge := range t.gauges {
+		gauge.Add(value, attrs...)
+	}
+}
+
+func (t teeInstrument) Record(value float64, attrs ...instrumentation.Attribute) {
+	for _, histogram := range t.histograms {
+		histogram.Record(value, attrs...)
+	}
+}
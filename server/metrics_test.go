@@ -0,0 +1,71 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/open-telemetry/opamp-go/instrumentation"
+	"github.com/open-telemetry/opamp-go/internal/testhelpers"
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/server/types"
+)
+
+func TestServerMetrics(t *testing.T) {
+	callbacks := CallbacksStruct{
+		OnMessageFunc: func(conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			return &protobufs.ServerToAgent{InstanceUid: message.InstanceUid}
+		},
+	}
+	meter := testhelpers.NewTestMeter()
+	settings := &StartSettings{Settings: Settings{Callbacks: callbacks, Meter: meter}}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	b, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: "12345678"})
+	require.NoError(t, err)
+
+	// Exchange one message over plain HTTP.
+	resp, err := http.Post("http://"+settings.ListenEndpoint+settings.ListenPath, contentTypeProtobuf, bytes.NewReader(b))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+
+	// Exchange one message over WebSocket and send a malformed one.
+	conn, _, err := dialClient(settings)
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, b))
+	_, _, err = conn.ReadMessage()
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte{0xff}))
+	_, _, err = conn.ReadMessage()
+	require.NoError(t, err)
+
+	received := "opamp.server.messages.received"
+	sent := "opamp.server.messages.sent"
+	assert.EqualValues(t, 1, meter.Sum(received, attrTransportHTTP))
+	assert.EqualValues(t, 1, meter.Sum(received, attrTransportWS))
+	assert.EqualValues(t, 1, meter.Sum(sent, attrTransportHTTP))
+	// The malformed message is answered with an error response.
+	assert.EqualValues(t, 2, meter.Sum(sent, attrTransportWS))
+
+	reasonMalformedAttr := instrumentation.Attribute{Key: "reason", Value: reasonMalformed}
+	assert.EqualValues(t, 1, meter.Sum("opamp.server.messages.rejected", reasonMalformedAttr))
+
+	// Only the instance_uid field is set in the exchanged messages, besides the
+	// error response.
+	fieldAttr := instrumentation.Attribute{Key: "field", Value: "instance_uid"}
+	assert.EqualValues(t, 2, meter.Count("opamp.server.message.field.size", attrDirectionReceived, fieldAttr))
+	assert.EqualValues(t, 2, meter.Count("opamp.server.message.field.size", attrDirectionSent, fieldAttr))
+	assert.EqualValues(t, 5, meter.Count("opamp.server.message.field.size"))
+
+	assert.EqualValues(t, 1, meter.Sum("opamp.server.connections.active"))
+	conn.Close()
+	eventually(t, func() bool { return meter.Sum("opamp.server.connections.active") == 0 })
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+// outbox holds the messages enqueued for delivery to the Agents until they can
+// be delivered. Messages enqueued for the same instance UID are merged into one
+// message, the fields of later messages replace the fields of earlier messages.
+// Messages that are not delivered within the TTL are dropped.
+// It is safe to call methods of this struct concurrently.
+type outbox struct {
+	mutex sync.Mutex
+
+	// The maximum duration a message waits for delivery.
+	ttl time.Duration
+
+	// Messages waiting to be delivered, by instance UID.
+	pending map[string]*pendingMessage
+
+	// The time after which enqueue drops the expired messages of all instances.
+	nextSweep time.Time
+
+	// Connections that can deliver a message immediately, by instance UID. These
+	// are WebSocket connections and held long-poll requests.
+	conns map[string]outboxConn
+}
+
+// outboxConn is a connection that can deliver the enqueued messages.
+type outboxConn interface {
+	// send sends the message. Unlike Connection.Send, the message is not treated
+	// as the response to a message of the Agent.
+	send(ctx context.Context, message *protobufs.ServerToAgent) error
+}
+
+// pendingMessage is a message waiting in the outbox.
+type pendingMessage struct {
+	message *protobufs.ServerToAgent
+
+	// The time after which the message is dropped.
+	expires time.Time
+}
+
+func newOutbox() *outbox {
+	return &outbox{
+		ttl:     defaultOutboxTTL,
+		pending: map[string]*pendingMessage{},
+		conns:   map[string]outboxConn{},
+	}
+}
+
+// setTTL sets the maximum duration a message waits for delivery, 0 means the
+// default.
+func (o *outbox) setTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = defaultOutboxTTL
+	}
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.ttl = ttl
+}
+
+// enqueue adds the message to the pending message of the instance. If there is
+// a connection registered for the instance the pending message is sent
+// immediately, otherwise it remains pending until it is taken or expires.
+func (o *outbox) enqueue(instanceUid string, message *protobufs.ServerToAgent) error {
+	message = proto.Clone(message).(*protobufs.ServerToAgent)
+
+	o.mutex.Lock()
+	now := time.Now()
+	o.sweep(now)
+	o.put(instanceUid, message, now)
+	conn := o.conns[instanceUid]
+	o.mutex.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return o.deliver(instanceUid, conn)
+}
+
+// deliver sends the pending message of the instance using the connection.
+// If sending fails the message remains pending.
+func (o *outbox) deliver(instanceUid string, conn outboxConn) error {
+	message := o.take(instanceUid)
+	if message == nil {
+		// Already delivered by someone else.
+		return nil
+	}
+
+	if err := conn.send(context.Background(), message); err != nil {
+		// Put the message back. The content enqueued in the meantime takes precedence.
+		o.mutex.Lock()
+		if pending := o.pending[instanceUid]; pending != nil {
+			mergeFields(message, pending.message)
+			pending.message = message
+		} else {
+			o.put(instanceUid, message, time.Now())
+		}
+		o.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// take removes and returns the pending message of the instance. Returns nil if
+// there is no pending message or it expired.
+func (o *outbox) take(instanceUid string) *protobufs.ServerToAgent {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	pending := o.pending[instanceUid]
+	if pending == nil {
+		return nil
+	}
+	delete(o.pending, instanceUid)
+	if time.Now().After(pending.expires) {
+		return nil
+	}
+	return pending.message
+}
+
+// put merges the message into the pending message of the instance. Extends the
+// expiration of the pending message. Must be called with the mutex locked.
+func (o *outbox) put(instanceUid string, message *protobufs.ServerToAgent, now time.Time) {
+	pending := o.pending[instanceUid]
+	if pending == nil || now.After(pending.expires) {
+		pending = &pendingMessage{message: message}
+		o.pending[instanceUid] = pending
+	} else {
+		mergeFields(pending.message, message)
+	}
+	pending.expires = now.Add(o.ttl)
+}
+
+// sweep drops the expired messages of all instances, at most once per TTL, so
+// that the messages of the Agents that never come back do not pile up.
+// Must be called with the mutex locked.
+func (o *outbox) sweep(now time.Time) {
+	if now.Before(o.nextSweep) {
+		return
+	}
+	o.nextSweep = now.Add(o.ttl)
+	for instanceUid, pending := range o.pending {
+		if now.After(pending.expires) {
+			delete(o.pending, instanceUid)
+		}
+	}
+}
+
+// register remembers that the connection can deliver messages to the instance.
+// The messages enqueued after this call are sent using the connection. The
+// messages that are already pending must be taken by the caller.
+func (o *outbox) register(instanceUid string, conn outboxConn) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.conns[instanceUid] = conn
+}
+
+// unregister forgets the connection registered for the instance using register.
+// Does nothing if a different connection is registered for the instance.
+func (o *outbox) unregister(instanceUid string, conn outboxConn) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.conns[instanceUid] == conn {
+		delete(o.conns, instanceUid)
+	}
+}
+
+// mergeResponse merges the response with the message taken from the outbox. The
+// fields of the response take precedence. Returns the merged message, which is
+// nil if both the response and the queued message are nil.
+func mergeResponse(queued *protobufs.ServerToAgent, response *protobufs.ServerToAgent) *protobufs.ServerToAgent {
+	if queued == nil {
+		return response
+	}
+	if response == nil {
+		return queued
+	}
+	mergeFields(queued, response)
+	return queued
+}
+
+// mergeFields sets the fields of dst that are set in src to the values of src.
+// Unlike proto.Merge, the fields are replaced as a whole, e.g. the remote config
+// of src replaces the remote config of dst instead of being merged into it.
+// dst shares the values of the message fields with src.
+func mergeFields(dst, src *protobufs.ServerToAgent) {
+	dstMessage := dst.ProtoReflect()
+	src.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		dstMessage.Set(fd, v)
+		return true
+	})
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+)
+
+func createConfig(files ...string) *protobufs.AgentRemoteConfig {
+	config := &protobufs.AgentRemoteConfig{
+		Config:     &protobufs.AgentConfigMap{ConfigMap: map[string]*protobufs.AgentConfigFile{}},
+		ConfigHash: []byte(files[0]),
+	}
+	for _, name := range files {
+		config.Config.ConfigMap[name] = &protobufs.AgentConfigFile{Body: []byte(name)}
+	}
+	return config
+}
+
+func TestOutboxReplacesFields(t *testing.T) {
+	o := newOutbox()
+	first := createConfig("first.yaml", "common.yaml")
+	second := createConfig("second.yaml")
+
+	require.NoError(t, o.enqueue("agent", &protobufs.ServerToAgent{
+		RemoteConfig: first,
+		Flags:        protobufs.ServerToAgent_ReportEffectiveConfig,
+	}))
+	require.NoError(t, o.enqueue("agent", &protobufs.ServerToAgent{RemoteConfig: second}))
+
+	// The second config replaces the first one instead of being merged into it.
+	message := o.take("agent")
+	require.NotNil(t, message)
+	assert.True(t, proto.Equal(second, message.RemoteConfig))
+	assert.EqualValues(t, protobufs.ServerToAgent_ReportEffectiveConfig, message.Flags)
+	assert.Nil(t, o.take("agent"))
+
+	// The config of the response replaces the queued config.
+	queued := &protobufs.ServerToAgent{RemoteConfig: createConfig("first.yaml", "common.yaml")}
+	merged := mergeResponse(queued, &protobufs.ServerToAgent{InstanceUid: "agent", RemoteConfig: second})
+	assert.True(t, proto.Equal(
+		&protobufs.ServerToAgent{InstanceUid: "agent", RemoteConfig: second}, merged,
+	))
+}
+
+func TestOutboxTTL(t *testing.T) {
+	o := newOutbox()
+	o.setTTL(10 * time.Millisecond)
+
+	require.NoError(t, o.enqueue("expired", &protobufs.ServerToAgent{RemoteConfig: createConfig("a.yaml")}))
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, o.take("expired"))
+
+	// The expired messages of the instances that do not come back are dropped too.
+	require.NoError(t, o.enqueue("gone", &protobufs.ServerToAgent{RemoteConfig: createConfig("a.yaml")}))
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, o.enqueue("agent", &protobufs.ServerToAgent{RemoteConfig: createConfig("b.yaml")}))
+	o.mutex.Lock()
+	assert.Len(t, o.pending, 1)
+	o.mutex.Unlock()
+	assert.NotNil(t, o.take("agent"))
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"bufio"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/open-telemetry/opamp-go/instrumentation"
+)
+
+const contentTypePrometheusText = "text/plain; version=0.0.4; charset=utf-8"
+
+// The upper bounds of the histogram buckets by the unit of the instrument.
+var (
+	secondsBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	bytesBuckets   = []float64{64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20}
+)
+
+// PrometheusExporter is an instrumentation.Meter that keeps the measurements in
+// memory and serves them in the Prometheus text exposition format. The names of
+// the instruments are converted to the Prometheus conventions, e.g.
+// "opamp.server.messages.received" is exposed as
+// "opamp_server_messages_received_total" and "opamp.server.callback.duration"
+// as "opamp_server_callback_duration_seconds".
+//
+// Set StartSettings.MetricsPath to serve the telemetry of the Server next to the
+// OpAMP path. If the Server is attached to an existing http.Server, pass the
+// exporter as Settings.Meter and add it as a handler of the desired path.
+type PrometheusExporter struct {
+	mutex    sync.Mutex
+	families map[string]*promFamily
+}
+
+var _ instrumentation.Meter = (*PrometheusExporter)(nil)
+var _ http.Handler = (*PrometheusExporter)(nil)
+
+// promFamily is a Prometheus metric family, i.e. the series of an instrument.
+type promFamily struct {
+	name    string
+	help    string
+	typ     string
+	buckets []float64
+	series  map[string]*promSeries
+}
+
+// promSeries is the state of an instrument for a set of attributes.
+type promSeries struct {
+	labels string
+
+	// The value of a counter or a gauge, the sum of the histogram values.
+	value float64
+
+	// The number of the histogram values in each bucket and in total.
+	bucketCounts []uint64
+	count        uint64
+}
+
+func NewPrometheusExporter() *PrometheusExporter {
+	return &PrometheusExporter{families: map[string]*promFamily{}}
+}
+
+func (e *PrometheusExporter) Counter(name, unit, description string) instrumentation.Counter {
+	return &promInstrument{exporter: e, family: e.family(promName(name, unit)+"_total", description, "counter", nil)}
+}
+
+func (e *PrometheusExporter) Histogram(name, unit, description string) instrumentation.Histogram {
+	buckets := secondsBuckets
+	if unit == "By" {
+		buckets = bytesBuckets
+	}
+	return &promInstrument{exporter: e, family: e.family(promName(name, unit), description, "histogram", buckets)}
+}
+
+func (e *PrometheusExporter) Gauge(name, unit, description string) instrumentation.Gauge {
+	return &promInstrument{exporter: e, family: e.family(promName(name, unit), description, "gauge", nil)}
+}
+
+// family returns the family with the name, creating it if necessary.
+func (e *PrometheusExporter) family(name, help, typ string, buckets []float64) *promFamily {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if f, ok := e.families[name]; ok {
+		return f
+	}
+	f := &promFamily{name: name, help: help, typ: typ, buckets: buckets, series: map[string]*promSeries{}}
+	e.families[name] = f
+	return f
+}
+
+// ServeHTTP writes the current state of the instruments.
+func (e *PrometheusExporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set(headerContentType, contentTypePrometheusText)
+	bw := bufio.NewWriter(w)
+	e.write(bw)
+	_ = bw.Flush()
+}
+
+func (e *PrometheusExporter) write(w *bufio.Writer) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	names := make([]string, 0, len(e.families))
+	for name := range e.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := e.families[name]
+		w.WriteString("# HELP " + f.name + " " + escapeHelp(f.help) + "\n")
+		w.WriteString("# TYPE " + f.name + " " + f.typ + "\n")
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			series := f.series[key]
+			if f.typ != "histogram" {
+				writeSample(w, f.name, series.labels, series.value)
+				continue
+			}
+			for i, bound := range f.buckets {
+				le := `le="` + formatFloat(bound) + `"`
+				writeSample(w, f.name+"_bucket", joinLabels(series.labels, le), float64(series.bucketCounts[i]))
+			}
+			writeSample(w, f.name+"_bucket", joinLabels(series.labels, `le="+Inf"`), float64(series.count))
+			writeSample(w, f.name+"_sum", series.labels, series.value)
+			writeSample(w, f.name+"_count", series.labels, float64(series.count))
+		}
+	}
+}
+
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// promInstrument is a counter, a gauge or a histogram of a PrometheusExporter.
+type promInstrument struct {
+	exporter *PrometheusExporter
+	family   *promFamily
+}
+
+func (i *promInstrument) Add(value int64, attrs ...instrumentation.Attribute) {
+	i.exporter.mutex.Lock()
+	defer i.exporter.mutex.Unlock()
+
+	i.series(attrs).value += float64(value)
+}
+
+func (i *promInstrument) Record(value float64, attrs ...instrumentation.Attribute) {
+	i.exporter.mutex.Lock()
+	defer i.exporter.mutex.Unlock()
+
+	series := i.series(attrs)
+	for j, bound := range i.family.buckets {
+		if value <= bound {
+			series.bucketCounts[j]++
+		}
+	}
+	series.count++
+	series.value += value
+}
+
+// series returns the series of the attributes, creating it if necessary.
+// Must be called with the mutex of the exporter locked.
+func (i *promInstrument) series(attrs []instrumentation.Attribute) *promSeries {
+	labels := promLabels(attrs)
+	series, ok := i.family.series[labels]
+	if !ok {
+		series = &promSeries{labels: labels, bucketCounts: make([]uint64, len(i.family.buckets))}
+		i.family.series[labels] = series
+	}
+	return series
+}
+
+// promName converts the name of the instrument to a Prometheus metric name.
+func promName(name, unit string) string {
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == ':' {
+			return r
+		}
+		return '_'
+	}, name)
+	switch unit {
+	case "s":
+		name += "_seconds"
+	case "By":
+		name += "_bytes"
+	}
+	return name
+}
+
+// promLabels formats the attributes as Prometheus labels sorted by the key.
+func promLabels(attrs []instrumentation.Attribute) string {
+	sorted := make([]instrumentation.Attribute, len(attrs))
+	copy(sorted, attrs)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+
+	labels := make([]string, len(sorted))
+	for i, attr := range sorted {
+		labels[i] = promName(attr.Key, "") + `="` + escapeLabelValue(attr.Value) + `"`
+	}
+	return strings.Join(labels, ",")
+}
+
+func joinLabels(labels, label string) string {
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
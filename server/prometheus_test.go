@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/open-telemetry/opamp-go/instrumentation"
+	sharedinternal "github.com/open-telemetry/opamp-go/internal"
+	"github.com/open-telemetry/opamp-go/internal/testhelpers"
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/server/types"
+)
+
+func TestPrometheusExporter(t *testing.T) {
+	exporter := NewPrometheusExporter()
+
+	counter := exporter.Counter("opamp.test.messages", "{message}", "The number of \"messages\".")
+	counter.Add(1, instrumentation.Attribute{Key: "transport", Value: "ws"})
+	counter.Add(2, instrumentation.Attribute{Key: "transport", Value: "ws"})
+	counter.Add(1, instrumentation.Attribute{Key: "transport", Value: `h"t\tp`})
+
+	gauge := exporter.Gauge("opamp.test.active", "{connection}", "Line one.\nLine two.")
+	gauge.Add(2)
+	gauge.Add(-1)
+
+	histogram := exporter.Histogram("opamp.test.duration", "s", "The duration.")
+	histogram.Record(0.02, instrumentation.Attribute{Key: "b", Value: "2"}, instrumentation.Attribute{Key: "a", Value: "1"})
+	histogram.Record(20, instrumentation.Attribute{Key: "a", Value: "1"}, instrumentation.Attribute{Key: "b", Value: "2"})
+
+	rec := httptest.NewRecorder()
+	exporter.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.EqualValues(t, contentTypePrometheusText, rec.Header().Get(headerContentType))
+
+	expected := `# HELP opamp_test_active Line one.\nLine two.
+# TYPE opamp_test_active gauge
+opamp_test_active 1
+# HELP opamp_test_duration_seconds The duration.
+# TYPE opamp_test_duration_seconds histogram
+opamp_test_duration_seconds_bucket{a="1",b="2",le="0.005"} 0
+opamp_test_duration_seconds_bucket{a="1",b="2",le="0.01"} 0
+opamp_test_duration_seconds_bucket{a="1",b="2",le="0.025"} 1
+opamp_test_duration_seconds_bucket{a="1",b="2",le="0.05"} 1
+opamp_test_duration_seconds_bucket{a="1",b="2",le="0.1"} 1
+opamp_test_duration_seconds_bucket{a="1",b="2",le="0.25"} 1
+opamp_test_duration_seconds_bucket{a="1",b="2",le="0.5"} 1
+opamp_test_duration_seconds_bucket{a="1",b="2",le="1"} 1
+opamp_test_duration_seconds_bucket{a="1",b="2",le="2.5"} 1
+opamp_test_duration_seconds_bucket{a="1",b="2",le="5"} 1
+opamp_test_duration_seconds_bucket{a="1",b="2",le="10"} 1
+opamp_test_duration_seconds_bucket{a="1",b="2",le="+Inf"} 2
+opamp_test_duration_seconds_sum{a="1",b="2"} 20.02
+opamp_test_duration_seconds_count{a="1",b="2"} 2
+# HELP opamp_test_messages_total The number of "messages".
+# TYPE opamp_test_messages_total counter
+opamp_test_messages_total{transport="h\"t\\tp"} 1
+opamp_test_messages_total{transport="ws"} 3
+`
+	assert.EqualValues(t, expected, rec.Body.String())
+}
+
+func TestServerMetricsPath(t *testing.T) {
+	callbacks := CallbacksStruct{
+		OnMessageFunc: func(conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			return &protobufs.ServerToAgent{}
+		},
+	}
+	settings := &StartSettings{Settings: Settings{Callbacks: callbacks}, MetricsPath: "/metrics"}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	// Send one valid and one malformed message.
+	b, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: "12345678"})
+	require.NoError(t, err)
+	for _, body := range [][]byte{b, {0xff}} {
+		resp, err := http.Post("http://"+settings.ListenEndpoint+settings.ListenPath, contentTypeProtobuf, bytes.NewReader(body))
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	// The metrics are served next to the OpAMP path.
+	resp, err := http.Get("http://" + settings.ListenEndpoint + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(body), "opamp_server_http_requests_total 2\n")
+	assert.Contains(t, string(body), `opamp_server_messages_received_total{transport="http"} 1`+"\n")
+	assert.Contains(t, string(body), `opamp_server_messages_sent_total{transport="http"} 1`+"\n")
+	assert.Contains(t, string(body), `opamp_server_messages_rejected_total{reason="malformed"} 1`+"\n")
+	assert.Contains(t, string(body), `opamp_server_callback_duration_seconds_count{callback="OnMessage"} 1`+"\n")
+	assert.Contains(t, string(body), `opamp_server_connections_active{transport="http"} 0`+"\n")
+	assert.Contains(t, string(body), "# TYPE opamp_server_connections_rejected_total counter\n")
+}
+
+func TestServerMetricsPathSettings(t *testing.T) {
+	// The metrics cannot be served on the OpAMP path.
+	srv := New(&sharedinternal.NopLogger{})
+	err := srv.Start(StartSettings{
+		ListenEndpoint: testhelpers.GetAvailableLocalAddress(),
+		MetricsPath:    defaultOpAMPPath,
+	})
+	assert.ErrorIs(t, err, errMetricsPathConflict)
+
+	// The metrics are open unless MetricsAuthenticator is set, regardless of the
+	// Authenticator of the Agents.
+	agentAuthenticator := NewBearerAuthenticator(map[string]string{"agent-secret": "agent"})
+	settings := &StartSettings{
+		Settings:    Settings{Authenticator: agentAuthenticator},
+		MetricsPath: "/metrics",
+	}
+	srv = startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	get := func(settings *StartSettings, token string) int {
+		req, err := http.NewRequest("GET", "http://"+settings.ListenEndpoint+"/metrics", nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set(headerAuthorization, "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.EqualValues(t, http.StatusOK, get(settings, ""))
+
+	// The metrics are protected by the MetricsAuthenticator.
+	settings = &StartSettings{
+		Settings:             Settings{Authenticator: agentAuthenticator},
+		MetricsPath:          "/metrics",
+		MetricsAuthenticator: NewBearerAuthenticator(map[string]string{"secret": "scraper"}),
+	}
+	srv = startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	assert.EqualValues(t, http.StatusUnauthorized, get(settings, ""))
+	assert.EqualValues(t, http.StatusUnauthorized, get(settings, "invalid"))
+	assert.EqualValues(t, http.StatusUnauthorized, get(settings, "agent-secret"))
+	assert.EqualValues(t, http.StatusOK, get(settings, "secret"))
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/open-telemetry/opamp-go/internal"
+)
+
+const (
+	headerForwarded     = "Forwarded"
+	headerXForwardedFor = "X-Forwarded-For"
+)
+
+// trustedProxies is a list of the network ranges of the proxies and load balancers
+// that are trusted to report the address of the Agent in the request headers.
+type trustedProxies []*net.IPNet
+
+// parseTrustedProxies parses a list of IP addresses and CIDR ranges.
+func parseTrustedProxies(entries []string) (trustedProxies, error) {
+	var proxies trustedProxies
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy range %q: %w", entry, err)
+		}
+		proxies = append(proxies, ipNet)
+	}
+	return proxies, nil
+}
+
+func (p trustedProxies) contains(ip net.IP) bool {
+	for _, ipNet := range p {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAddr returns the address of the Agent that sent the request. If the peer
+// of the network connection is a trusted proxy the address is taken from the
+// Forwarded header or, if there is none, from the X-Forwarded-For header. The
+// hops listed in the header are examined from the nearest to the farthest and the
+// first hop that is not a trusted proxy is the Agent.
+func (p trustedProxies) remoteAddr(req *http.Request) net.Addr {
+	addr := peerAddr(req)
+	if len(p) == 0 {
+		return addr
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || !p.contains(tcpAddr.IP) {
+		return addr
+	}
+
+	hops := forwardedFor(req.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseHostPort(hops[i])
+		if hop == nil {
+			// Malformed or obfuscated address, can't go further.
+			break
+		}
+		addr = hop
+		if !p.contains(hop.IP) {
+			break
+		}
+	}
+	return addr
+}
+
+// peerAddr returns the address of the peer of the network connection that the
+// request was received from.
+func peerAddr(req *http.Request) net.Addr {
+	if conn := connFromRequest(req); conn != nil {
+		// The request is received by the http.Server created by Start().
+		return conn.RemoteAddr()
+	}
+
+	// The request is received by an http.Server created by the user, see Attach().
+	if addr := parseHostPort(req.RemoteAddr); addr != nil {
+		return addr
+	}
+	return stringAddr(req.RemoteAddr)
+}
+
+// forwardedFor returns the addresses of the hops the request passed through, from
+// the farthest to the nearest, as reported by Forwarded or X-Forwarded-For header.
+func forwardedFor(header http.Header) []string {
+	var hops []string
+	if values := header.Values(headerForwarded); len(values) > 0 {
+		for _, value := range values {
+			for _, element := range strings.Split(value, ",") {
+				for _, pair := range strings.Split(element, ";") {
+					key, value, found := internal.Cut(strings.TrimSpace(pair), "=")
+					if found && strings.EqualFold(key, "for") {
+						hops = append(hops, strings.Trim(value, `"`))
+					}
+				}
+			}
+		}
+		return hops
+	}
+
+	for _, value := range header.Values(headerXForwardedFor) {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// parseHostPort parses an IP address with an optional port, e.g. "192.0.2.1",
+// "192.0.2.1:4711", "2001:db8::1" or "[2001:db8::1]:4711". Returns nil if it is
+// not a valid address.
+func parseHostPort(s string) *net.TCPAddr {
+	if ip := net.ParseIP(strings.Trim(s, "[]")); ip != nil {
+		return &net.TCPAddr{IP: ip}
+	}
+
+	host, portStr, err := net.SplitHostPort(s)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	port, err := strconv.Atoi(portStr)
+	if ip == nil || err != nil {
+		return nil
+	}
+	return &net.TCPAddr{IP: ip, Port: port}
+}
+
+// stringAddr is a net.Addr of unknown network.
+type stringAddr string
+
+func (a stringAddr) Network() string { return "unknown" }
+func (a stringAddr) String() string  { return string(a) }
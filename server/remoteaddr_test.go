@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteAddr(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::1"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		expected   string
+	}{
+		{
+			name:       "no proxy",
+			remoteAddr: "198.51.100.1:1234",
+			expected:   "198.51.100.1:1234",
+		},
+		{
+			name:       "untrusted peer",
+			remoteAddr: "198.51.100.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"203.0.113.1"}},
+			expected:   "198.51.100.1:1234",
+		},
+		{
+			name:       "trusted peer",
+			remoteAddr: "10.1.2.3:1234",
+			header:     http.Header{"X-Forwarded-For": {"203.0.113.1"}},
+			expected:   "203.0.113.1:0",
+		},
+		{
+			name:       "chain of trusted proxies",
+			remoteAddr: "10.1.2.3:1234",
+			header:     http.Header{"X-Forwarded-For": {"203.0.113.9, 203.0.113.1", "192.0.2.1"}},
+			expected:   "203.0.113.1:0",
+		},
+		{
+			name:       "all hops trusted",
+			remoteAddr: "10.1.2.3:1234",
+			header:     http.Header{"X-Forwarded-For": {"10.0.0.1"}},
+			expected:   "10.0.0.1:0",
+		},
+		{
+			name:       "forwarded header takes precedence",
+			remoteAddr: "[2001:db8::1]:1234",
+			header: http.Header{
+				"Forwarded":       {`for="[2001:db8::2]:4711";proto=https, for=192.0.2.1`},
+				"X-Forwarded-For": {"203.0.113.1"},
+			},
+			expected: "[2001:db8::2]:4711",
+		},
+		{
+			name:       "obfuscated address",
+			remoteAddr: "10.1.2.3:1234",
+			header:     http.Header{"Forwarded": {"for=_hidden, for=10.0.0.1"}},
+			expected:   "10.0.0.1:0",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := &http.Request{RemoteAddr: test.remoteAddr, Header: test.header}
+			assert.Equal(t, test.expected, proxies.remoteAddr(req).String())
+		})
+	}
+}
+
+func TestParseTrustedProxiesInvalid(t *testing.T) {
+	_, err := parseTrustedProxies([]string{"not an address"})
+	assert.Error(t, err)
+
+	_, err = parseTrustedProxies([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+}
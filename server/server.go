@@ -4,19 +4,159 @@ import (
 	"context"
 	"crypto/tls"
 	"net/http"
+	"time"
 
+	"github.com/open-telemetry/opamp-go/instrumentation"
+	"github.com/open-telemetry/opamp-go/protobufs"
 	"github.com/open-telemetry/opamp-go/server/types"
+	"github.com/open-telemetry/opamp-go/tracecontext"
 )
 
 type Settings struct {
 	// Callbacks that the Server will call after successful Attach/Start.
 	Callbacks types.Callbacks
+
+	// Meter optionally receives the telemetry of the Server, see the
+	// instrumentation package. nil means the telemetry is discarded.
+	Meter instrumentation.Meter
+
+	// StartSpan is optionally called to start a span around the processing of
+	// every message passed to Callbacks.OnMessage. The parent is the trace context
+	// sent by the Agent, see Connection.TraceContext, and the context of the span
+	// is based on the context of the connection and is available to OnMessage via
+	// Connection.Context. The span ends when the response is written, including
+	// the responses sent later using Connection.Send. If the context of the span
+	// carries a trace context, see tracecontext.ContextWith, it is sent to the
+	// Agent in the headers of the plain HTTP response. WebSocket messages carry no
+	// headers.
+	StartSpan tracecontext.StartSpanFunc
+
+	// Interceptors optionally intercept the calls of the Callbacks, e.g. to add
+	// logging or metrics. The first interceptor is the outermost one, see
+	// ChainCallbacks.
+	Interceptors []Interceptor
+
+	// Authenticator is an optional Authenticator of the incoming connections.
+	// Unauthenticated connections are rejected with 401 or 403 status before
+	// Callbacks.OnConnecting is called. The principal of the authenticated Agent
+	// is available via Connection.Principal and in the context of the request
+	// passed to OnConnecting, see types.PrincipalFromContext.
+	Authenticator Authenticator
+
+	// InstanceUidBinding optionally restricts the instance UIDs the Agents may use,
+	// e.g. to the instance UIDs registered to the authenticated principal. Messages
+	// that violate the binding are not passed to Callbacks.OnMessage.
+	// nil means any instance UID is accepted.
+	InstanceUidBinding *InstanceUidBinding
+
+	// OnDuplicateInstanceUid is an optional func called when an Agent uses the
+	// instance UID of another live WebSocket connection. Its result defines whether
+	// the message of the duplicate is accepted, rejected or the duplicate is
+	// re-identified. Plain HTTP requests are checked against the live WebSocket
+	// connections only. nil means duplicates are allowed.
+	OnDuplicateInstanceUid DuplicateInstanceUidFunc
+
+	// Validation optionally enables validation of the messages received from the
+	// Agents against the rules of the OpAMP specification and the application
+	// rules. Invalid messages are responded with ServerErrorResponse of BadRequest
+	// type describing the problem and are not passed to Callbacks.OnMessage.
+	// nil means the messages are not validated.
+	Validation *Validation
+
+	// TrustedProxies is an optional list of IP addresses and CIDR ranges of the
+	// reverse proxies and load balancers in front of the Server, e.g. "10.0.0.0/8".
+	// If a request comes from a trusted proxy, Connection.RemoteAddr returns the
+	// address of the Agent reported by the proxy in the Forwarded or
+	// X-Forwarded-For header. The headers are ignored for other requests.
+	TrustedProxies []string
+
+	// MaxLongPollTimeout is the maximum duration a plain HTTP long-poll request is
+	// held while there is nothing to send to the Agent. Agents may request shorter
+	// timeouts. 0 means the default of 60 seconds. Long-poll requests are not
+	// passed to the Callbacks, use Server.Enqueue to send messages to the Agent.
+	MaxLongPollTimeout time.Duration
+
+	// SendQueueSize is the maximum number of messages waiting to be written to
+	// a WebSocket connection. Connection.Send returns types.ErrSendQueueFull when
+	// the queue is full. 0 means the default of 16.
+	SendQueueSize int
+
+	// WriteTimeout is the maximum duration of writing a message to a WebSocket
+	// connection. 0 means the default of 10 seconds.
+	WriteTimeout time.Duration
+
+	// MaxWriteFailures is the number of consecutive failed writes to a WebSocket
+	// connection after which the connection is closed. 0 means the default of 3.
+	MaxWriteFailures int
+
+	// MaxConcurrentMessageHandlers limits the number of concurrent OnMessage calls
+	// across all WebSocket connections. If positive, the messages received from
+	// WebSocket connections are processed by a pool of that many workers, so that
+	// slow OnMessage calls do not block reading from the connections. The messages
+	// of the same connection are still processed one by one, in order.
+	// 0 means OnMessage is called by the goroutine reading from the connection.
+	MaxConcurrentMessageHandlers int
+
+	// ReceiveQueueSize is the maximum number of received messages of a WebSocket
+	// connection waiting to be processed by the worker pool. Reading from the
+	// connection pauses while the queue is full. 0 means the default of 16.
+	// Ignored if MaxConcurrentMessageHandlers is 0.
+	ReceiveQueueSize int
+
+	// AsyncResponseTimeout is the maximum duration a plain HTTP request is held
+	// waiting for the response to be sent using Connection.Send() if OnMessage
+	// returned nil. When the timeout elapses an empty response is sent.
+	// 0 means the default of 30 seconds.
+	AsyncResponseTimeout time.Duration
+
+	// OutboxTTL is the maximum duration a message enqueued using Enqueue waits
+	// for delivery to the Agent. Messages that are not delivered in time are
+	// dropped. 0 means the default of 10 minutes.
+	OutboxTTL time.Duration
+
+	// MaxConnections is the maximum number of concurrent connections, counting
+	// WebSocket connections and plain HTTP requests being processed. Connections
+	// over the limit are rejected with 503 status and Retry-After header.
+	// 0 means unlimited.
+	MaxConnections int
+
+	// MaxConnectionsPerIP is the maximum number of concurrent connections from
+	// the same IP address, see Connection.RemoteAddr. Connections over the limit
+	// are rejected with 429 status and Retry-After header. 0 means unlimited.
+	MaxConnectionsPerIP int
+
+	// RetryAfter is the duration the rejected Agents are asked to wait before
+	// connecting again. 0 means the default of 30 seconds.
+	RetryAfter time.Duration
+
+	// MessageRateLimit is the maximum sustained rate of messages per second
+	// received over a WebSocket connection. Messages over the limit are not passed
+	// to OnMessage, the Agent receives ServerErrorResponse of Unavailable type with
+	// RetryInfo instead. 0 means unlimited.
+	MessageRateLimit float64
+
+	// MessageBurst is the number of messages that may be received over a
+	// WebSocket connection at once, before MessageRateLimit applies.
+	// 0 means MessageRateLimit rounded up.
+	MessageBurst int
+
+	// MaxWSMessageSize is the maximum size in bytes of a message received over
+	// WebSocket. The connection is closed with 1009 (message too big) close code if
+	// the Agent sends a larger message. 0 means the default of 4 MiB.
+	MaxWSMessageSize int64
+
+	// MaxHTTPMessageSize is the maximum size in bytes of the body of a plain HTTP
+	// request. Larger requests are responded with ServerErrorResponse of BadRequest
+	// type. 0 means the default of 4 MiB.
+	MaxHTTPMessageSize int64
 }
 
 type StartSettings struct {
 	Settings
 
-	// ListenEndpoint specifies the endpoint to listen on, e.g. "127.0.0.1:4320"
+	// ListenEndpoint specifies the endpoint to listen on, e.g. "127.0.0.1:4320".
+	// Use "unix://" prefix to listen on a Unix domain socket, e.g.
+	// "unix:///run/opamp.sock".
 	ListenEndpoint string
 
 	// ListenPath specifies the URL path on which to accept the OpAMP connections
@@ -25,6 +165,21 @@ type StartSettings struct {
 
 	// Server's TLS configuration.
 	TLSConfig *tls.Config
+
+	// MetricsPath optionally specifies the URL path on which to serve the
+	// telemetry of the Server in the Prometheus text exposition format, e.g.
+	// "/metrics", see PrometheusExporter. The telemetry is reported to
+	// Settings.Meter too, if set. The requests are authenticated by
+	// MetricsAuthenticator. Must differ from ListenPath. Empty string means the
+	// telemetry is not served.
+	MetricsPath string
+
+	// MetricsAuthenticator is an optional Authenticator of the requests to
+	// MetricsPath, e.g. of the Prometheus scrapers. Unauthenticated requests are
+	// rejected with 401 or 403 status. Settings.Authenticator is not used for these
+	// requests. nil means the telemetry is served to anyone who can reach the
+	// Server.
+	MetricsAuthenticator Authenticator
 }
 
 type HTTPHandlerFunc func(http.ResponseWriter, *http.Request)
@@ -40,6 +195,8 @@ type OpAMPServer interface {
 	//   mux.HandleFunc("/opamp", handler)
 	//   httpSrv := &http.Server{Handler:mux,Addr:"127.0.0.1:4320"}
 	//   httpSrv.ListenAndServe()
+	// The handler may be wrapped by net/http middlewares and the http.Server may
+	// be behind TLS-terminating load balancers, see Settings.TrustedProxies.
 	Attach(settings Settings) (HTTPHandlerFunc, error)
 
 	// Start an OpAMP Server and begin accepting connections. Starts its own http.Server
@@ -47,6 +204,22 @@ type OpAMPServer interface {
 	// accept connections.
 	Start(settings StartSettings) error
 
+	// Enqueue queues the message for delivery to the Agent with the specified
+	// instance UID regardless of the transport the Agent uses. If the Agent is
+	// connected via WebSocket the message is sent immediately. If the Agent uses
+	// plain HTTP the message is sent in response to the held long-poll request, if
+	// any, otherwise it is merged into the response returned by OnMessage for the
+	// next request of the Agent. Messages enqueued for the same Agent before they
+	// are delivered are merged, the fields set in later messages replace the same
+	// fields of earlier messages. Messages not delivered within
+	// Settings.OutboxTTL are dropped.
+	// Returns an error if sending over WebSocket fails, the message remains queued
+	// in that case.
+	Enqueue(instanceUid string, message *protobufs.ServerToAgent) error
+
+	// Stats returns the counters of the messages rejected by the Server.
+	Stats() Stats
+
 	// Stop accepting new connections and close all current connections. This should
 	// block until all connections are closed.
 	Stop(ctx context.Context) error
@@ -4,50 +4,128 @@ import (
 	"context"
 	"errors"
 	"io"
+	"mime"
 	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/open-telemetry/opamp-go/client/types"
+	"github.com/open-telemetry/opamp-go/instrumentation"
 	"github.com/open-telemetry/opamp-go/internal"
 	"github.com/open-telemetry/opamp-go/protobufs"
+	servertypes "github.com/open-telemetry/opamp-go/server/types"
+	"github.com/open-telemetry/opamp-go/tracecontext"
 )
 
 var (
-	errAlreadyStarted = errors.New("already started")
+	errAlreadyStarted      = errors.New("already started")
+	errInstanceUidMissing  = errors.New("instance UID is not specified")
+	errMetricsPathConflict = errors.New("metrics path is the same as the OpAMP path")
 )
 
 const defaultOpAMPPath = "/v1/opamp"
+const unixEndpointPrefix = "unix://"
 const headerContentType = "Content-Type"
 const contentTypeProtobuf = "application/x-protobuf"
+const defaultMaxLongPollTimeout = 60 * time.Second
+const defaultAsyncResponseTimeout = 30 * time.Second
+const defaultOutboxTTL = 10 * time.Minute
+const defaultReceiveQueueSize = 16
+const defaultMaxMessageSize = 4 << 20
 
 type server struct {
-	logger   types.Logger
+	logger   *internal.Logger
 	settings Settings
 
 	// Upgrader to use to upgrade HTTP to WebSocket.
 	wsUpgrader websocket.Upgrader
 
+	// The proxies trusted to report the address of the Agents.
+	trustedProxies trustedProxies
+
 	// The listening HTTP Server after successful Start() call. Nil if Start()
 	// is not called or was not successful.
 	httpServer *http.Server
+
+	// Messages enqueued for delivery to the Agents.
+	outbox *outbox
+
+	// Limits the number of concurrent OnMessage calls. nil if OnMessage is called
+	// by the read loop of the WebSocket connection.
+	workers chan struct{}
+
+	// Enforces Settings.InstanceUidBinding, nil if not set.
+	uidBinder *uidBinder
+
+	// The instance UIDs of the live connections, nil if duplicates are not detected.
+	instances *liveInstances
+
+	// Limits the number of concurrent connections, nil if unlimited.
+	admission *admission
+
+	// Counters of the rejected messages.
+	counters *counters
+
+	// Instruments that report the telemetry of the Server.
+	metrics *metrics
+
+	// Closed when Stop() is called to release the held long-poll requests.
+	stopping     chan struct{}
+	stoppingOnce sync.Once
 }
 
 var _ OpAMPServer = (*server)(nil)
 
 func New(logger types.Logger) *server {
-	if logger == nil {
-		logger = &internal.NopLogger{}
+	return &server{
+		logger:   internal.NewLogger(logger),
+		outbox:   newOutbox(),
+		counters: &counters{},
+		metrics:  newMetrics(nil),
+		stopping: make(chan struct{}),
 	}
-
-	return &server{logger: logger}
 }
 
 func (s *server) Attach(settings Settings) (HTTPHandlerFunc, error) {
+	trustedProxies, err := parseTrustedProxies(settings.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	var binder *uidBinder
+	if settings.InstanceUidBinding != nil {
+		binder, err = newUidBinder(*settings.InstanceUidBinding)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	s.settings = settings
+	s.metrics = newMetrics(settings.Meter)
+	if len(settings.Interceptors) > 0 {
+		s.settings.Callbacks = ChainCallbacks(settings.Callbacks, settings.Interceptors...)
+	}
 	s.wsUpgrader = websocket.Upgrader{}
+	s.trustedProxies = trustedProxies
+	s.uidBinder = binder
+	s.outbox.setTTL(settings.OutboxTTL)
+	s.workers = nil
+	if settings.MaxConcurrentMessageHandlers > 0 {
+		s.workers = make(chan struct{}, settings.MaxConcurrentMessageHandlers)
+	}
+	s.instances = nil
+	if settings.OnDuplicateInstanceUid != nil {
+		s.instances = newLiveInstances()
+	}
+	s.admission = nil
+	if settings.MaxConnections > 0 || settings.MaxConnectionsPerIP > 0 {
+		s.admission = newAdmission(settings.MaxConnections, settings.MaxConnectionsPerIP)
+	}
 	return s.httpHandler, nil
 }
 
@@ -56,6 +134,20 @@ func (s *server) Start(settings StartSettings) error {
 		return errAlreadyStarted
 	}
 
+	path := settings.ListenPath
+	if path == "" {
+		path = defaultOpAMPPath
+	}
+	if settings.MetricsPath == path {
+		return errMetricsPathConflict
+	}
+
+	var exporter *PrometheusExporter
+	if settings.MetricsPath != "" {
+		exporter = NewPrometheusExporter()
+		settings.Meter = newTeeMeter(settings.Meter, exporter)
+	}
+
 	_, err := s.Attach(settings.Settings)
 	if err != nil {
 		return err
@@ -63,13 +155,10 @@ func (s *server) Start(settings StartSettings) error {
 
 	// Prepare handling OpAMP incoming HTTP requests on the requests URL path.
 	mux := http.NewServeMux()
-
-	path := settings.ListenPath
-	if path == "" {
-		path = defaultOpAMPPath
-	}
-
 	mux.HandleFunc(path, s.httpHandler)
+	if exporter != nil {
+		mux.Handle(settings.MetricsPath, s.metricsHandler(exporter, settings.MetricsAuthenticator))
+	}
 
 	hs := &http.Server{
 		Handler:     mux,
@@ -102,9 +191,29 @@ func (s *server) Start(settings StartSettings) error {
 	return err
 }
 
+// metricsHandler returns the handler that serves the telemetry using the
+// exporter. Authenticates the requests using the authenticator, if not nil.
+func (s *server) metricsHandler(exporter http.Handler, authenticator Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if authenticator != nil {
+			if _, err := authenticator.Authenticate(req); err != nil {
+				s.writeAuthError(w, err)
+				return
+			}
+		}
+		exporter.ServeHTTP(w, req)
+	}
+}
+
 func (s *server) startHttpServer(listenAddr string, serveFunc func(l net.Listener) error) error {
-	// If the listen address is not specified use the default.
-	ln, err := net.Listen("tcp", listenAddr)
+	// Listen on a Unix domain socket if requested, otherwise on a TCP address.
+	network := "tcp"
+	if strings.HasPrefix(listenAddr, unixEndpointPrefix) {
+		network = "unix"
+		listenAddr = strings.TrimPrefix(listenAddr, unixEndpointPrefix)
+	}
+
+	ln, err := net.Listen(network, listenAddr)
 	if err != nil {
 		return err
 	}
@@ -116,14 +225,35 @@ func (s *server) startHttpServer(listenAddr string, serveFunc func(l net.Listene
 		// ErrServerClosed is expected after successful Stop(), so we won't log that
 		// particular error.
 		if err != nil && err != http.ErrServerClosed {
-			s.logger.Errorf("Error running HTTP Server: %v", err)
+			s.logger.Error("Error running HTTP Server", types.F("error", err))
 		}
 	}()
 
 	return nil
 }
 
+func (s *server) Enqueue(instanceUid string, message *protobufs.ServerToAgent) error {
+	if instanceUid == "" {
+		return errInstanceUidMissing
+	}
+	return s.outbox.enqueue(instanceUid, message)
+}
+
+func (s *server) Stats() Stats {
+	return s.counters.stats()
+}
+
+// countRejected counts the message rejected for the reason.
+func (s *server) countRejected(counter *uint64, reason string) {
+	atomic.AddUint64(counter, 1)
+	s.metrics.messagesRejected.Add(1, instrumentation.Attribute{Key: "reason", Value: reason})
+}
+
 func (s *server) Stop(ctx context.Context) error {
+	// Respond to the held long-poll requests, otherwise shutting down the
+	// http.Server would wait until they time out.
+	s.stoppingOnce.Do(func() { close(s.stopping) })
+
 	if s.httpServer != nil {
 		defer func() { s.httpServer = nil }()
 		// This stops accepting new connections. TODO: close existing
@@ -134,9 +264,39 @@ func (s *server) Stop(ctx context.Context) error {
 }
 
 func (s *server) httpHandler(w http.ResponseWriter, req *http.Request) {
+	remoteAddr := s.trustedProxies.remoteAddr(req)
+	release, ok := s.admit(w, remoteAddr)
+	if !ok {
+		// The load is shed, the Agent will retry later.
+		return
+	}
+	// Release the connection when done unless it is handed over to the WebSocket
+	// connection handler.
+	handedOver := false
+	defer func() {
+		if !handedOver {
+			release()
+		}
+	}()
+
+	req, principal, ok := s.authenticate(w, req)
+	if !ok {
+		// The error response is already written.
+		return
+	}
+
+	// Let OnConnecting and the connection know the trace context of the Agent.
+	traceContext := tracecontext.Extract(req.Header)
+	if traceContext.IsValid() {
+		req = req.WithContext(tracecontext.ContextWith(req.Context(), traceContext))
+	}
+
 	if s.settings.Callbacks != nil {
+		start := time.Now()
 		resp := s.settings.Callbacks.OnConnecting(req)
+		s.metrics.timeCallback(start, attrCallbackOnConnecting)
 		if !resp.Accept {
+			s.metrics.rejectConnection(reasonRefused)
 			// HTTP connection is not accepted. Set the response headers.
 			for k, v := range resp.HTTPResponseHeader {
 				w.Header().Set(k, v)
@@ -149,33 +309,120 @@ func (s *server) httpHandler(w http.ResponseWriter, req *http.Request) {
 
 	// HTTP connection is accepted. Check if it is a plain HTTP request.
 
-	if req.Header.Get(headerContentType) == contentTypeProtobuf {
+	if isProtobufRequest(req) {
 		// Yes, a plain HTTP request.
-		s.handlePlainHTTPRequest(req, w)
+		s.handlePlainHTTPRequest(req, w, principal, traceContext)
 		return
 	}
 
 	// No, it is a WebSocket. Upgrade it.
-	conn, err := s.wsUpgrader.Upgrade(w, req, nil)
+	conn, err := s.wsUpgrader.Upgrade(hijackableWriter(w), req, nil)
 	if err != nil {
-		s.logger.Errorf("Cannot upgrade HTTP connection to WebSocket: %v", err)
+		s.logger.Warn("Cannot upgrade HTTP connection to WebSocket", types.F("error", err))
 		return
 	}
 
 	// Return from this func to reduce memory usage.
 	// Handle the connection on a separate goroutine.
-	go s.handleWSConnection(conn)
+	handedOver = true
+	go func() {
+		defer release()
+		s.handleWSConnection(req.Context(), conn, remoteAddr, principal, traceContext)
+	}()
+}
+
+// admit admits the connection from the address if the connection limits allow.
+// Returns a func that must be called when the connection is closed. Writes the
+// response and returns false if the connection is not admitted.
+func (s *server) admit(w http.ResponseWriter, remoteAddr net.Addr) (func(), bool) {
+	if s.admission == nil {
+		return func() {}, true
+	}
+
+	if statusCode := s.admission.acquire(remoteAddr); statusCode != 0 {
+		retryAfter := s.settings.RetryAfter
+		if retryAfter <= 0 {
+			retryAfter = defaultRetryAfter
+		}
+		s.logger.Debug("Connection rejected by the connection limits", types.F("remote_addr", remoteAddr), types.F("status", statusCode))
+		s.metrics.rejectConnection(reasonConnectionLimit)
+		writeRetryAfter(w, statusCode, retryAfter)
+		return nil, false
+	}
+	return func() { s.admission.release(remoteAddr) }, true
 }
 
-func (s *server) handleWSConnection(wsConn *websocket.Conn) {
-	agentConn := wsConnection{wsConn: wsConn}
+// isProtobufRequest returns true if the request body is a Protobuf message,
+// i.e. the request is a plain HTTP request and not a WebSocket upgrade request.
+func isProtobufRequest(req *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get(headerContentType))
+	return err == nil && mediaType == contentTypeProtobuf
+}
+
+// hijackableWriter returns the http.ResponseWriter that can be hijacked to upgrade
+// the connection to WebSocket. Middlewares often wrap the http.ResponseWriter
+// with a type that does not implement http.Hijacker. If such wrapper has
+// Unwrap() method, like the ones supported by http.ResponseController, the
+// wrapped http.ResponseWriter is used.
+func hijackableWriter(w http.ResponseWriter) http.ResponseWriter {
+	for current := w; ; {
+		if _, ok := current.(http.Hijacker); ok {
+			return current
+		}
+		wrapper, ok := current.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			// Let the upgrader report the error.
+			return w
+		}
+		current = wrapper.Unwrap()
+	}
+}
+
+func (s *server) handleWSConnection(
+	reqCtx context.Context,
+	wsConn *websocket.Conn,
+	remoteAddr net.Addr,
+	principal *servertypes.Principal,
+	traceContext tracecontext.TraceContext,
+) {
+	// The connection outlives the upgraded request, keep the values of the
+	// context of the request only.
+	ctx, cancel := context.WithCancel(detachedContext{parent: reqCtx})
+	defer cancel()
+
+	agentConn := newWSConnection(ctx, wsConn, remoteAddr, principal, traceContext, s.settings, s.metrics)
+	s.metrics.connectionsActive.Add(1, attrTransportWS)
+	defer s.metrics.connectionsActive.Add(-1, attrTransportWS)
+
+	// Messages received from the Agent, if processed by the worker pool.
+	var received chan *protobufs.AgentToServer
+	var processingDone chan struct{}
 
 	defer func() {
+		// Stop writing to the connection.
+		agentConn.close()
+
+		if received != nil {
+			// Wait for the received messages to be processed.
+			close(received)
+			<-processingDone
+		}
+
+		// End the span of the message that is not responded.
+		agentConn.message.responded()
+
+		if agentConn.instanceUid != "" {
+			s.outbox.unregister(agentConn.instanceUid, agentConn)
+			if s.instances != nil {
+				s.instances.release(agentConn.instanceUid, agentConn)
+			}
+		}
+
 		// Close the connection when all is done.
 		defer func() {
 			err := wsConn.Close()
 			if err != nil {
-				s.logger.Errorf("error closing the WebSocket connection: %v", err)
+				s.logger.Error("Cannot close the WebSocket connection", types.F("error", err))
 			}
 		}()
 
@@ -188,21 +435,54 @@ func (s *server) handleWSConnection(wsConn *websocket.Conn) {
 		s.settings.Callbacks.OnConnected(agentConn)
 	}
 
+	if s.workers != nil {
+		// Process the messages using the worker pool so that slow OnMessage calls
+		// do not block reading.
+		queueSize := s.settings.ReceiveQueueSize
+		if queueSize <= 0 {
+			queueSize = defaultReceiveQueueSize
+		}
+		received = make(chan *protobufs.AgentToServer, queueSize)
+		processingDone = make(chan struct{})
+		go s.processWSMessages(agentConn, received, processingDone)
+	}
+
+	// Limits the rate of the messages passed to OnMessage, nil if unlimited.
+	var limiter *tokenBucket
+	if s.settings.MessageRateLimit > 0 {
+		limiter = newTokenBucket(s.settings.MessageRateLimit, s.settings.MessageBurst)
+	}
+
+	maxMessageSize := s.settings.MaxWSMessageSize
+	if maxMessageSize <= 0 {
+		maxMessageSize = defaultMaxMessageSize
+	}
+	wsConn.SetReadLimit(maxMessageSize)
+
 	// Loop until fail to read from the WebSocket connection.
 	for {
 		// Block until the next message can be read.
 		mt, bytes, err := wsConn.ReadMessage()
 		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				// The close frame is already sent by the WebSocket library.
+				s.countRejected(&s.counters.rejectedTooLarge, reasonTooLarge)
+				s.logger.Debug(
+					"Message is too large, closing the connection",
+					types.F("remote_addr", agentConn.remoteAddr), types.F("max_size", maxMessageSize),
+				)
+				break
+			}
 			if !websocket.IsUnexpectedCloseError(err) {
-				s.logger.Errorf("Cannot read a message from WebSocket: %v", err)
+				s.logger.Error("Cannot read a message from WebSocket", types.F("error", err))
 				break
 			}
 			// This is a normal closing of the WebSocket connection.
-			s.logger.Debugf("Agent disconnected: %v", err)
+			s.logger.Debug("Agent disconnected", types.F("error", err))
 			break
 		}
 		if mt != websocket.BinaryMessage {
-			s.logger.Errorf("Received unexpected message type from WebSocket: %v", mt)
+			s.logger.Warn("Received unexpected message type from WebSocket", types.F("message_type", mt))
 			continue
 		}
 
@@ -210,27 +490,239 @@ func (s *server) handleWSConnection(wsConn *websocket.Conn) {
 		var request protobufs.AgentToServer
 		err = proto.Unmarshal(bytes, &request)
 		if err != nil {
-			s.logger.Errorf("Cannot decode message from WebSocket: %v", err)
+			s.countRejected(&s.counters.rejectedMalformed, reasonMalformed)
+			s.logger.Warn("Cannot decode message from WebSocket", types.F("error", err))
+			err = agentConn.send(context.Background(), badRequestResponse("", "cannot decode message"))
+			if err != nil {
+				s.logger.Error("Cannot send message to WebSocket", types.F("error", err))
+			}
 			continue
 		}
+		s.metrics.messagesReceived.Add(1, attrTransportWS)
+		internal.RecordFieldSizes(s.metrics.messageFieldSize, &request, attrDirectionReceived)
+
+		if limiter != nil {
+			if ok, retryAfter := limiter.take(time.Now()); !ok {
+				// Too many messages, ask the Agent to retry later.
+				s.countRejected(&s.counters.rejectedRateLimited, reasonRateLimited)
+				s.logger.Debug("Message rejected by the rate limit", types.F("remote_addr", agentConn.remoteAddr))
+				err = agentConn.send(context.Background(), unavailableResponse(request.InstanceUid, retryAfter))
+				if err != nil {
+					s.logger.Error("Cannot send message to WebSocket", types.F("error", err))
+				}
+				continue
+			}
+		}
 
-		if s.settings.Callbacks != nil {
-			response := s.settings.Callbacks.OnMessage(agentConn, &request)
-			if response.InstanceUid == "" {
-				response.InstanceUid = request.InstanceUid
+		if received != nil {
+			received <- &request
+		} else {
+			s.onWSMessage(agentConn, &request)
+		}
+	}
+}
+
+// processWSMessages processes the messages received from the connection in the
+// order they are received, using the worker pool. Closes done when received is
+// closed and all messages are processed.
+func (s *server) processWSMessages(
+	conn *wsConnection, received <-chan *protobufs.AgentToServer, done chan<- struct{},
+) {
+	defer close(done)
+	for request := range received {
+		s.workers <- struct{}{}
+		s.onWSMessage(conn, request)
+		<-s.workers
+	}
+}
+
+// onWSMessage processes a message received from the WebSocket connection and
+// sends the response, if any.
+func (s *server) onWSMessage(conn *wsConnection, request *protobufs.AgentToServer) {
+	select {
+	case <-conn.closed:
+		// The connection was dropped, e.g. because of an instance UID violation.
+		return
+	default:
+	}
+
+	if request.Capabilities != 0 {
+		conn.capabilities = request.Capabilities
+	}
+	if s.settings.Validation != nil {
+		if err := s.settings.Validation.validate(conn, request, conn.capabilities); err != nil {
+			s.countRejected(&s.counters.rejectedInvalid, reasonInvalid)
+			s.logger.Debug("Invalid message", types.F("remote_addr", conn.remoteAddr), types.F("error", err))
+			if sendErr := conn.send(context.Background(), badRequestResponse(request.InstanceUid, err.Error())); sendErr != nil {
+				s.logger.Error("Cannot send message to WebSocket", types.F("error", sendErr))
 			}
-			err = agentConn.Send(context.Background(), response)
-			if err != nil {
-				s.logger.Errorf("Cannot send message to WebSocket: %v", err)
+			return
+		}
+	}
+
+	if s.uidBinder != nil {
+		reason := s.uidBinder.check(conn.principal, conn.instanceUid, conn.assignedUid, request.InstanceUid)
+		if reason != "" {
+			s.rejectWSInstanceUid(conn, request, reason)
+			return
+		}
+	}
+
+	if request.InstanceUid != conn.instanceUid && request.InstanceUid != "" {
+		if s.instances != nil && !s.claimWSInstanceUid(conn, request.InstanceUid) {
+			return
+		}
+
+		// Deliver the messages enqueued for the Agent over this connection from
+		// now on.
+		if conn.instanceUid != "" {
+			s.outbox.unregister(conn.instanceUid, conn)
+		}
+		conn.instanceUid = request.InstanceUid
+		s.outbox.register(conn.instanceUid, conn)
+	}
+
+	if s.settings.Callbacks == nil {
+		return
+	}
+
+	// The response carries no headers, the trace context of the span is not sent.
+	// The span ends when the response is written, either below or, if OnMessage
+	// returns nil, when the application sends it using wsConnection.Send.
+	spanContext, endSpan := s.startSpan(conn.ctx, conn)
+	conn.message.start(spanContext)
+	conn.message.respondLater(endSpan)
+
+	start := time.Now()
+	response := s.settings.Callbacks.OnMessage(conn, request)
+	s.metrics.timeCallback(start, attrCallbackOnMessage)
+	// Send the messages enqueued in the meantime together with the response.
+	response = mergeResponse(s.outbox.take(conn.instanceUid), response)
+	if response == nil {
+		// No response now. The application may respond later using conn.Send(),
+		// the span ends then.
+		return
+	}
+	if response.InstanceUid == "" {
+		response.InstanceUid = request.InstanceUid
+	}
+	if newUid := response.GetAgentIdentification().GetNewInstanceUid(); newUid != "" {
+		conn.assignedUid = newUid
+	}
+	if err := conn.send(context.Background(), response); err != nil {
+		s.logger.Error("Cannot send message to WebSocket", types.F("error", err))
+	}
+	conn.message.responded()
+}
+
+// rejectWSInstanceUid responds to the message that violates the instance UID
+// binding. The connection is closed unless the Agent is re-identified.
+func (s *server) rejectWSInstanceUid(conn *wsConnection, request *protobufs.AgentToServer, reason string) {
+	s.logger.Debug("Instance UID rejected", types.F("instance_uid", request.InstanceUid), types.F("reason", reason))
+
+	response := s.uidBinder.violationResponse(conn.principal, request.InstanceUid, reason)
+	newUid := response.GetAgentIdentification().GetNewInstanceUid()
+	if newUid != "" {
+		conn.assignedUid = newUid
+	}
+	if err := conn.send(context.Background(), response); err != nil {
+		s.logger.Error("Cannot send message to WebSocket", types.F("error", err))
+	}
+	if newUid == "" {
+		s.dropWSConnection(conn, reason)
+	}
+}
+
+// claimWSInstanceUid records that the connection uses the instance UID and applies
+// Settings.OnDuplicateInstanceUid if another connection uses it. Returns false
+// if the message must not be processed.
+func (s *server) claimWSInstanceUid(conn *wsConnection, instanceUid string) bool {
+	if existing := s.instances.claim(instanceUid, conn); existing != nil {
+		const reason = "instance UID is used by another connection"
+
+		switch s.settings.OnDuplicateInstanceUid(existing, conn, instanceUid) {
+		case RejectDuplicateInstanceUid:
+			s.logger.Debug("Duplicate instance UID rejected", types.F("instance_uid", instanceUid))
+			if err := conn.send(context.Background(), badRequestResponse(instanceUid, reason)); err != nil {
+				s.logger.Error("Cannot send message to WebSocket", types.F("error", err))
+			}
+			s.dropWSConnection(conn, reason)
+			return false
+
+		case ReIdentifyDuplicateInstanceUid:
+			response := badRequestResponse(instanceUid, reason)
+			conn.assignedUid = s.reIdentifyDuplicate(conn.principal, response)
+			s.logger.Debug(
+				"Duplicate instance UID re-identified",
+				types.F("instance_uid", instanceUid), types.F("new_instance_uid", conn.assignedUid),
+			)
+			if err := conn.send(context.Background(), response); err != nil {
+				s.logger.Error("Cannot send message to WebSocket", types.F("error", err))
 			}
+			return false
 		}
+
+		// The newest connection takes over the instance UID.
+		s.instances.replace(instanceUid, conn)
 	}
+
+	if conn.instanceUid != "" {
+		s.instances.release(conn.instanceUid, conn)
+	}
+	return true
 }
 
-func (s *server) handlePlainHTTPRequest(req *http.Request, w http.ResponseWriter) {
-	bytes, err := io.ReadAll(req.Body)
+// reIdentifyDuplicate instructs the duplicate Agent to use a new instance UID.
+// Returns the new instance UID.
+func (s *server) reIdentifyDuplicate(principal *servertypes.Principal, response *protobufs.ServerToAgent) string {
+	newUid := reIdentify(response)
+	if s.uidBinder != nil {
+		s.uidBinder.bind(principal, newUid)
+	}
+	return newUid
+}
+
+// startSpan starts the span around the OnMessage call for the message received
+// from the connection. Returns the context of the span and the func that ends
+// the span.
+func (s *server) startSpan(ctx context.Context, conn servertypes.Connection) (context.Context, func()) {
+	if s.settings.StartSpan == nil {
+		return ctx, func() {}
+	}
+	return s.settings.StartSpan(ctx, "opamp.server.message", conn.TraceContext())
+}
+
+// dropWSConnection closes the WebSocket connection because of a policy violation.
+func (s *server) dropWSConnection(conn *wsConnection, reason string) {
+	closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	_ = conn.wsConn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(conn.writeTimeout))
+	_ = conn.wsConn.Close()
+	conn.close()
+}
+
+func (s *server) handlePlainHTTPRequest(
+	req *http.Request, w http.ResponseWriter, principal *servertypes.Principal, traceContext tracecontext.TraceContext,
+) {
+	s.metrics.httpRequests.Add(1)
+	s.metrics.connectionsActive.Add(1, attrTransportHTTP)
+	defer s.metrics.connectionsActive.Add(-1, attrTransportHTTP)
+
+	maxMessageSize := s.settings.MaxHTTPMessageSize
+	if maxMessageSize <= 0 {
+		maxMessageSize = defaultMaxMessageSize
+	}
+	if req.ContentLength > maxMessageSize {
+		s.rejectTooLargeHTTPRequest(w, maxMessageSize)
+		return
+	}
+
+	bytes, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxMessageSize))
 	if err != nil {
-		s.logger.Debugf("Cannot read HTTP body: %v", err)
+		if int64(len(bytes)) >= maxMessageSize {
+			s.rejectTooLargeHTTPRequest(w, maxMessageSize)
+			return
+		}
+		s.logger.Debug("Cannot read HTTP body", types.F("error", err))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -239,20 +731,67 @@ func (s *server) handlePlainHTTPRequest(req *http.Request, w http.ResponseWriter
 	var request protobufs.AgentToServer
 	err = proto.Unmarshal(bytes, &request)
 	if err != nil {
-		s.logger.Debugf("Cannot decode message from HTTP Body: %v", err)
+		s.countRejected(&s.counters.rejectedMalformed, reasonMalformed)
+		s.logger.Debug("Cannot decode message from HTTP body", types.F("error", err))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-
-	agentConn := httpConnection{
-		conn: connFromRequest(req),
-	}
+	s.metrics.messagesReceived.Add(1, attrTransportHTTP)
+	internal.RecordFieldSizes(s.metrics.messageFieldSize, &request, attrDirectionReceived)
 
 	if s.settings.Callbacks == nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	agentConn := newHTTPConnection(req.Context(), s.trustedProxies.remoteAddr(req), principal, traceContext)
+
+	if s.settings.Validation != nil {
+		if err := s.settings.Validation.validate(agentConn, &request, request.Capabilities); err != nil {
+			s.countRejected(&s.counters.rejectedInvalid, reasonInvalid)
+			s.logger.Debug("Invalid message", types.F("remote_addr", agentConn.remoteAddr), types.F("error", err))
+			s.writeHTTPResponse(w, badRequestResponse(request.InstanceUid, err.Error()))
+			return
+		}
+	}
+
+	if s.uidBinder != nil {
+		reason := s.uidBinder.check(principal, "", "", request.InstanceUid)
+		if reason != "" {
+			s.logger.Debug("Instance UID rejected", types.F("instance_uid", request.InstanceUid), types.F("reason", reason))
+			s.writeHTTPResponse(w, s.uidBinder.violationResponse(principal, request.InstanceUid, reason))
+			return
+		}
+	}
+
+	if s.instances != nil && request.InstanceUid != "" {
+		if existing := s.instances.lookup(request.InstanceUid); existing != nil {
+			const reason = "instance UID is used by another connection"
+
+			switch s.settings.OnDuplicateInstanceUid(existing, agentConn, request.InstanceUid) {
+			case RejectDuplicateInstanceUid:
+				s.logger.Debug("Duplicate instance UID rejected", types.F("instance_uid", request.InstanceUid))
+				s.writeHTTPResponse(w, badRequestResponse(request.InstanceUid, reason))
+				return
+
+			case ReIdentifyDuplicateInstanceUid:
+				response := badRequestResponse(request.InstanceUid, reason)
+				newUid := s.reIdentifyDuplicate(principal, response)
+				s.logger.Debug(
+					"Duplicate instance UID re-identified",
+					types.F("instance_uid", request.InstanceUid), types.F("new_instance_uid", newUid),
+				)
+				s.writeHTTPResponse(w, response)
+				return
+			}
+		}
+	}
+
+	if longPollTimeout := internal.ExtractLongPollTimeoutHeader(req); longPollTimeout > 0 {
+		s.handleLongPollRequest(req, w, agentConn, request.InstanceUid, longPollTimeout)
+		return
+	}
+
 	s.settings.Callbacks.OnConnected(agentConn)
 
 	defer func() {
@@ -262,16 +801,85 @@ func (s *server) handlePlainHTTPRequest(req *http.Request, w http.ResponseWriter
 		// via this agentConn.
 		s.settings.Callbacks.OnConnectionClose(agentConn)
 	}()
+	defer close(agentConn.responded)
+
+	// The span ends when the response is written, including the held requests.
+	spanContext, endSpan := s.startSpan(req.Context(), agentConn)
+	defer endSpan()
+	agentConn.message.start(spanContext)
 
+	start := time.Now()
 	response := s.settings.Callbacks.OnMessage(agentConn, &request)
+	s.metrics.timeCallback(start, attrCallbackOnMessage)
+	close(agentConn.handled)
+
+	// Send the trace context of the span, if any, to the Agent.
+	if spanTraceContext := tracecontext.FromContext(spanContext); spanTraceContext != traceContext {
+		tracecontext.Inject(w.Header(), spanTraceContext)
+	}
+
+	// Send the messages enqueued for the Agent together with the response.
+	response = mergeResponse(s.outbox.take(request.InstanceUid), response)
+
+	switch {
+	case response == nil:
+		// No response now. Hold the request until the application responds using
+		// agentConn.Send().
+		timeout := s.settings.AsyncResponseTimeout
+		if timeout <= 0 {
+			timeout = defaultAsyncResponseTimeout
+		}
+		response = s.waitResponse(req, agentConn, request.InstanceUid, &protobufs.ServerToAgent{}, timeout)
+	}
 
 	// Set the InstanceUid if it is not set by the callback.
 	if response.InstanceUid == "" {
 		response.InstanceUid = request.InstanceUid
 	}
 
+	s.writeHTTPResponse(w, response)
+}
+
+// handleLongPollRequest holds the long-poll request until a message is enqueued
+// for the Agent or the timeout elapses. The long-poll request carries the
+// instance UID only and is not a status report, so it is not passed to the
+// Callbacks.
+func (s *server) handleLongPollRequest(
+	req *http.Request, w http.ResponseWriter, agentConn *httpConnection, instanceUid string, timeout time.Duration,
+) {
+	if instanceUid == "" {
+		s.writeHTTPResponse(w, badRequestResponse("", errInstanceUidMissing.Error()))
+		return
+	}
+
+	maxTimeout := s.settings.MaxLongPollTimeout
+	if maxTimeout <= 0 {
+		maxTimeout = defaultMaxLongPollTimeout
+	}
+	if timeout > maxTimeout {
+		timeout = maxTimeout
+	}
+
+	// Let the outbox send the enqueued messages via agentConn.send().
+	close(agentConn.handled)
+	defer close(agentConn.responded)
+
+	response := s.waitResponse(req, agentConn, instanceUid, &protobufs.ServerToAgent{}, timeout)
+	if response.InstanceUid == "" {
+		response.InstanceUid = instanceUid
+	}
+	s.writeHTTPResponse(w, response)
+}
+
+func (s *server) rejectTooLargeHTTPRequest(w http.ResponseWriter, maxMessageSize int64) {
+	s.countRejected(&s.counters.rejectedTooLarge, reasonTooLarge)
+	s.logger.Debug("HTTP request body is too large", types.F("max_size", maxMessageSize))
+	s.writeHTTPResponse(w, badRequestResponse("", "message is too large"))
+}
+
+func (s *server) writeHTTPResponse(w http.ResponseWriter, response *protobufs.ServerToAgent) {
 	// Marshal the response.
-	bytes, err = proto.Marshal(response)
+	bytes, err := proto.Marshal(response)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -282,6 +890,43 @@ func (s *server) handlePlainHTTPRequest(req *http.Request, w http.ResponseWriter
 	_, err = w.Write(bytes)
 
 	if err != nil {
-		s.logger.Debugf("Cannot send HTTP response: %v", err)
+		s.logger.Debug("Cannot send HTTP response", types.F("error", err))
+		return
+	}
+	s.metrics.messagesSent.Add(1, attrTransportHTTP)
+	internal.RecordFieldSizes(s.metrics.messageFieldSize, response, attrDirectionSent)
+}
+
+// waitResponse holds the request until a message is sent using conn.Send() or
+// enqueued for the Agent, or until the timeout elapses. Returns the message to
+// send as the response, which is the specified response if nothing was sent.
+func (s *server) waitResponse(
+	req *http.Request,
+	conn *httpConnection,
+	instanceUid string,
+	response *protobufs.ServerToAgent,
+	timeout time.Duration,
+) *protobufs.ServerToAgent {
+	if instanceUid != "" {
+		// Let the outbox send the messages enqueued while the request is held.
+		s.outbox.register(instanceUid, conn)
+		defer s.outbox.unregister(instanceUid, conn)
+
+		// A message may have been enqueued after we checked the outbox.
+		if queued := s.outbox.take(instanceUid); queued != nil {
+			return mergeResponse(queued, response)
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case msg := <-conn.response:
+		return msg
+	case <-timer.C:
+	case <-req.Context().Done():
+	case <-s.stopping:
 	}
+	return response
 }
@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -295,3 +299,447 @@ func TestServerAttachAcceptConnection(t *testing.T) {
 	conn.Close()
 	eventually(t, func() bool { return atomic.LoadInt32(&connectionCloseCalled) == 1 })
 }
+
+// wrappedResponseWriter is a http.ResponseWriter wrapper typically used by
+// middlewares. It does not implement http.Hijacker.
+type wrappedResponseWriter struct {
+	w http.ResponseWriter
+}
+
+func (w *wrappedResponseWriter) Header() http.Header         { return w.w.Header() }
+func (w *wrappedResponseWriter) Write(b []byte) (int, error) { return w.w.Write(b) }
+func (w *wrappedResponseWriter) WriteHeader(statusCode int)  { w.w.WriteHeader(statusCode) }
+func (w *wrappedResponseWriter) Unwrap() http.ResponseWriter { return w.w }
+
+func TestServerAttachWithMiddleware(t *testing.T) {
+	var remoteAddrs []string
+	var remoteAddrsMutex sync.Mutex
+	callbacks := CallbacksStruct{
+		OnConnectedFunc: func(conn types.Connection) {
+			remoteAddrsMutex.Lock()
+			defer remoteAddrsMutex.Unlock()
+			remoteAddrs = append(remoteAddrs, conn.RemoteAddr().String())
+		},
+	}
+
+	// Attach OpAMP Server to an HTTP Server created separately, behind a middleware
+	// that wraps the http.ResponseWriter and a trusted proxy.
+	srv := New(&sharedinternal.NopLogger{})
+	handlerFunc, err := srv.Attach(Settings{Callbacks: callbacks, TrustedProxies: []string{"127.0.0.1"}})
+	require.NoError(t, err)
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerFunc(&wrappedResponseWriter{w: w}, r)
+	}))
+	defer hs.Close()
+	header := http.Header{"X-Forwarded-For": {"203.0.113.1"}}
+
+	// Send a plain HTTP request.
+	b, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: "12345678"})
+	require.NoError(t, err)
+	req, err := http.NewRequest("POST", hs.URL, bytes.NewReader(b))
+	require.NoError(t, err)
+	req.Header = header.Clone()
+	req.Header.Set(headerContentType, contentTypeProtobuf+"; proto=opamp.proto.AgentToServer")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+
+	// Connect using WebSocket client.
+	conn, resp, err := websocket.DefaultDialer.Dial("ws://"+hs.Listener.Addr().String(), header)
+	require.NoError(t, err)
+	assert.EqualValues(t, 101, resp.StatusCode)
+	conn.Close()
+
+	// Verify that the address of the Agent is reported by both connections.
+	eventually(t, func() bool {
+		remoteAddrsMutex.Lock()
+		defer remoteAddrsMutex.Unlock()
+		return len(remoteAddrs) == 2
+	})
+	assert.EqualValues(t, []string{"203.0.113.1:0", "203.0.113.1:0"}, remoteAddrs)
+}
+
+func TestServerUnixSocket(t *testing.T) {
+	var rcvMsg atomic.Value
+	callbacks := CallbacksStruct{
+		OnMessageFunc: func(conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			rcvMsg.Store(message)
+			return &protobufs.ServerToAgent{InstanceUid: message.InstanceUid}
+		},
+	}
+
+	// Start a Server listening on a Unix domain socket.
+	socketPath := filepath.Join(t.TempDir(), "opamp.sock")
+	settings := &StartSettings{
+		Settings:       Settings{Callbacks: callbacks},
+		ListenEndpoint: "unix://" + socketPath,
+	}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	// Send a message to the Server over the socket.
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+	sendMsg := protobufs.AgentToServer{InstanceUid: "12345678"}
+	b, err := proto.Marshal(&sendMsg)
+	require.NoError(t, err)
+	resp, err := client.Post("http://localhost"+settings.ListenPath, contentTypeProtobuf, bytes.NewReader(b))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// Verify that the message is received and responded to.
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, proto.Equal(rcvMsg.Load().(proto.Message), &sendMsg))
+}
+
+func TestServerLongPollPlainHTTP(t *testing.T) {
+	var callbackCalls int32
+	callbacks := CallbacksStruct{
+		OnConnectingFunc: func(request *http.Request) types.ConnectionResponse {
+			return types.ConnectionResponse{Accept: true}
+		},
+		OnConnectedFunc: func(conn types.Connection) {
+			atomic.AddInt32(&callbackCalls, 1)
+		},
+		OnMessageFunc: func(conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			atomic.AddInt32(&callbackCalls, 1)
+			return &protobufs.ServerToAgent{InstanceUid: message.InstanceUid}
+		},
+	}
+
+	// Start a Server.
+	settings := &StartSettings{Settings: Settings{Callbacks: callbacks}}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	postLongPoll := func(instanceUid string, timeout time.Duration) (*protobufs.ServerToAgent, error) {
+		b, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: instanceUid})
+		require.NoError(t, err)
+		req, err := http.NewRequest("POST", "http://"+settings.ListenEndpoint+settings.ListenPath, bytes.NewReader(b))
+		require.NoError(t, err)
+		req.Header.Set(headerContentType, contentTypeProtobuf)
+		sharedinternal.SetLongPollTimeoutHeader(req.Header, timeout)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		b, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		var response protobufs.ServerToAgent
+		err = proto.Unmarshal(b, &response)
+		return &response, err
+	}
+
+	// Send a long-poll request. The Server must hold it until a message is enqueued.
+	var rcvResponse atomic.Value
+	go func() {
+		response, err := postLongPoll("12345678", time.Minute)
+		assert.NoError(t, err)
+		rcvResponse.Store(response)
+	}()
+	eventually(t, func() bool { return isHeld(srv, "12345678") })
+	assert.Nil(t, rcvResponse.Load())
+
+	sendMsg := &protobufs.ServerToAgent{
+		InstanceUid:  "12345678",
+		Capabilities: protobufs.ServerCapabilities_AcceptsStatus,
+	}
+	require.NoError(t, srv.Enqueue("12345678", sendMsg))
+	eventually(t, func() bool { return rcvResponse.Load() != nil })
+	assert.True(t, proto.Equal(sendMsg, rcvResponse.Load().(*protobufs.ServerToAgent)))
+
+	// Send a long-poll request and let it time out.
+	start := time.Now()
+	response, err := postLongPoll("12345678", time.Second)
+	require.NoError(t, err)
+	assert.EqualValues(t, "12345678", response.InstanceUid)
+	assert.Zero(t, response.Capabilities)
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+
+	// A long-poll request must identify the Agent.
+	response, err = postLongPoll("", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, response.ErrorResponse)
+	assert.EqualValues(t, protobufs.ServerErrorResponse_BadRequest, response.ErrorResponse.GetType())
+
+	// Long-poll requests are not status reports and are not passed to the callbacks.
+	assert.EqualValues(t, 0, atomic.LoadInt32(&callbackCalls))
+}
+
+// isHeld returns true if a long-poll request or a WebSocket connection of the
+// Agent is registered in the outbox of the Server.
+func isHeld(srv *server, instanceUid string) bool {
+	srv.outbox.mutex.Lock()
+	defer srv.outbox.mutex.Unlock()
+	return srv.outbox.conns[instanceUid] != nil
+}
+
+func TestServerEnqueue(t *testing.T) {
+	callbacks := CallbacksStruct{
+		OnConnectingFunc: func(request *http.Request) types.ConnectionResponse {
+			return types.ConnectionResponse{Accept: true}
+		},
+		OnMessageFunc: func(conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			return &protobufs.ServerToAgent{
+				InstanceUid:  message.InstanceUid,
+				Capabilities: protobufs.ServerCapabilities_AcceptsStatus,
+			}
+		},
+	}
+
+	// Start a Server.
+	settings := &StartSettings{Settings: Settings{Callbacks: callbacks}}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	remoteConfig := &protobufs.AgentRemoteConfig{ConfigHash: []byte{1, 2, 3}}
+	assert.ErrorIs(t, srv.Enqueue("", &protobufs.ServerToAgent{}), errInstanceUidMissing)
+
+	postMessage := func(instanceUid string, longPollTimeout time.Duration) *protobufs.ServerToAgent {
+		b, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: instanceUid})
+		require.NoError(t, err)
+		req, err := http.NewRequest("POST", "http://"+settings.ListenEndpoint+settings.ListenPath, bytes.NewReader(b))
+		require.NoError(t, err)
+		req.Header.Set(headerContentType, contentTypeProtobuf)
+		if longPollTimeout > 0 {
+			sharedinternal.SetLongPollTimeoutHeader(req.Header, longPollTimeout)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err = io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		var response protobufs.ServerToAgent
+		require.NoError(t, proto.Unmarshal(b, &response))
+		return &response
+	}
+
+	// Plain HTTP Agent receives the enqueued message merged with the response on
+	// its next request.
+	require.NoError(t, srv.Enqueue("http", &protobufs.ServerToAgent{RemoteConfig: remoteConfig}))
+	response := postMessage("http", 0)
+	assert.EqualValues(t, protobufs.ServerCapabilities_AcceptsStatus, response.Capabilities)
+	assert.True(t, proto.Equal(remoteConfig, response.RemoteConfig))
+
+	// The message is delivered once only.
+	response = postMessage("http", 0)
+	assert.Nil(t, response.RemoteConfig)
+
+	// Long-polling Agent receives the enqueued message in response to the held request.
+	var longPollResponse atomic.Value
+	go func() {
+		longPollResponse.Store(postMessage("longpoll", time.Minute))
+	}()
+	eventually(t, func() bool { return isHeld(srv, "longpoll") })
+	assert.Nil(t, longPollResponse.Load())
+	require.NoError(t, srv.Enqueue("longpoll", &protobufs.ServerToAgent{RemoteConfig: remoteConfig}))
+	eventually(t, func() bool { return longPollResponse.Load() != nil })
+	assert.True(t, proto.Equal(remoteConfig, longPollResponse.Load().(*protobufs.ServerToAgent).RemoteConfig))
+
+	// WebSocket Agent receives the enqueued message immediately.
+	conn, _, err := dialClient(settings)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	b, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: "ws"})
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, b))
+	_, b, err = conn.ReadMessage()
+	require.NoError(t, err)
+
+	require.NoError(t, srv.Enqueue("ws", &protobufs.ServerToAgent{RemoteConfig: remoteConfig}))
+	_, b, err = conn.ReadMessage()
+	require.NoError(t, err)
+	var wsResponse protobufs.ServerToAgent
+	require.NoError(t, proto.Unmarshal(b, &wsResponse))
+	assert.True(t, proto.Equal(remoteConfig, wsResponse.RemoteConfig))
+}
+
+func TestServerSendConcurrently(t *testing.T) {
+	var srvConn atomic.Value
+	var closed int32
+	callbacks := CallbacksStruct{
+		OnConnectingFunc: func(request *http.Request) types.ConnectionResponse {
+			return types.ConnectionResponse{Accept: true}
+		},
+		OnConnectedFunc: func(conn types.Connection) {
+			srvConn.Store(conn)
+		},
+		OnConnectionCloseFunc: func(conn types.Connection) {
+			atomic.StoreInt32(&closed, 1)
+		},
+	}
+
+	// Start a Server.
+	settings := &StartSettings{Settings: Settings{Callbacks: callbacks}}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	// Connect to the Server.
+	conn, _, err := dialClient(settings)
+	require.NoError(t, err)
+	eventually(t, func() bool { return srvConn.Load() != nil })
+
+	// Send messages from multiple goroutines.
+	const count = 10
+	for i := 0; i < count; i++ {
+		go func() {
+			err := srvConn.Load().(types.Connection).Send(
+				context.Background(), &protobufs.ServerToAgent{InstanceUid: "12345678"},
+			)
+			assert.NoError(t, err)
+		}()
+	}
+
+	// Verify that all messages are received intact.
+	for i := 0; i < count; i++ {
+		_, b, err := conn.ReadMessage()
+		require.NoError(t, err)
+		var response protobufs.ServerToAgent
+		require.NoError(t, proto.Unmarshal(b, &response))
+		assert.EqualValues(t, "12345678", response.InstanceUid)
+	}
+
+	// Close the connection from client side. Send must fail after that.
+	conn.Close()
+	eventually(t, func() bool { return atomic.LoadInt32(&closed) == 1 })
+	err = srvConn.Load().(types.Connection).Send(context.Background(), &protobufs.ServerToAgent{})
+	assert.ErrorIs(t, err, types.ErrConnectionClosed)
+}
+
+func TestServerAsyncResponse(t *testing.T) {
+	callbacks := CallbacksStruct{
+		OnConnectingFunc: func(request *http.Request) types.ConnectionResponse {
+			return types.ConnectionResponse{Accept: true}
+		},
+		OnMessageFunc: func(conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			// Respond later from a different goroutine.
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				err := conn.Send(context.Background(), &protobufs.ServerToAgent{
+					InstanceUid:  message.InstanceUid,
+					Capabilities: protobufs.ServerCapabilities_AcceptsStatus,
+				})
+				assert.NoError(t, err)
+			}()
+			return nil
+		},
+	}
+
+	// Start a Server that processes the messages using a worker pool.
+	settings := &StartSettings{
+		Settings: Settings{Callbacks: callbacks, MaxConcurrentMessageHandlers: 2},
+	}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	b, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: "12345678"})
+	require.NoError(t, err)
+
+	// Verify the response is received over WebSocket.
+	conn, _, err := dialClient(settings)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, b))
+	_, respBytes, err := conn.ReadMessage()
+	require.NoError(t, err)
+	var response protobufs.ServerToAgent
+	require.NoError(t, proto.Unmarshal(respBytes, &response))
+	assert.EqualValues(t, protobufs.ServerCapabilities_AcceptsStatus, response.Capabilities)
+
+	// Verify the response is received over plain HTTP.
+	resp, err := http.Post("http://"+settings.ListenEndpoint+settings.ListenPath, contentTypeProtobuf, bytes.NewReader(b))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	respBytes, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	response = protobufs.ServerToAgent{}
+	require.NoError(t, proto.Unmarshal(respBytes, &response))
+	assert.EqualValues(t, "12345678", response.InstanceUid)
+	assert.EqualValues(t, protobufs.ServerCapabilities_AcceptsStatus, response.Capabilities)
+}
+
+func TestServerSendInOnMessagePlainHTTP(t *testing.T) {
+	var sendErr atomic.Value
+	callbacks := CallbacksStruct{
+		OnMessageFunc: func(conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			// The request handler cannot accept the message before OnMessage returns.
+			sendErr.Store(conn.Send(context.Background(), &protobufs.ServerToAgent{}))
+			return &protobufs.ServerToAgent{Capabilities: protobufs.ServerCapabilities_AcceptsStatus}
+		},
+	}
+	settings := &StartSettings{Settings: Settings{Callbacks: callbacks}}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	b, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: "12345678"})
+	require.NoError(t, err)
+	resp, err := http.Post("http://"+settings.ListenEndpoint+settings.ListenPath, contentTypeProtobuf, bytes.NewReader(b))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	var response protobufs.ServerToAgent
+	require.NoError(t, proto.Unmarshal(b, &response))
+	assert.EqualValues(t, protobufs.ServerCapabilities_AcceptsStatus, response.Capabilities)
+	assert.ErrorIs(t, sendErr.Load().(error), errHTTPRequestNotHeld)
+}
+
+func TestServerMessageSizeLimit(t *testing.T) {
+	var rcvCount int64
+	callbacks := CallbacksStruct{
+		OnMessageFunc: func(conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			atomic.AddInt64(&rcvCount, 1)
+			return &protobufs.ServerToAgent{}
+		},
+	}
+	settings := &StartSettings{
+		Settings: Settings{Callbacks: callbacks, MaxWSMessageSize: 100, MaxHTTPMessageSize: 100},
+	}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	large, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: strings.Repeat("x", 200)})
+	require.NoError(t, err)
+
+	// A large plain HTTP request is rejected.
+	resp, err := http.Post("http://"+settings.ListenEndpoint+settings.ListenPath, contentTypeProtobuf, bytes.NewReader(large))
+	require.NoError(t, err)
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	var response protobufs.ServerToAgent
+	require.NoError(t, proto.Unmarshal(b, &response))
+	assert.EqualValues(t, protobufs.ServerErrorResponse_BadRequest, response.ErrorResponse.GetType())
+
+	// A malformed WebSocket message is rejected.
+	conn, _, err := dialClient(settings)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte{0xff}))
+	_, b, err = conn.ReadMessage()
+	require.NoError(t, err)
+	require.NoError(t, proto.Unmarshal(b, &response))
+	assert.EqualValues(t, protobufs.ServerErrorResponse_BadRequest, response.ErrorResponse.GetType())
+
+	// A large WebSocket message closes the connection.
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, large))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig))
+
+	assert.EqualValues(t, 0, atomic.LoadInt64(&rcvCount))
+	eventually(t, func() bool {
+		return srv.Stats() == Stats{RejectedTooLarge: 2, RejectedMalformed: 1}
+	})
+}
@@ -0,0 +1,33 @@
+package server
+
+import "sync/atomic"
+
+// Stats are the counters of the messages rejected by the Server, see
+// OpAMPServer.Stats.
+type Stats struct {
+	// The number of messages rejected because they exceed the maximum message size.
+	RejectedTooLarge uint64
+	// The number of messages rejected because they can't be decoded.
+	RejectedMalformed uint64
+	// The number of messages rejected by the message rate limit.
+	RejectedRateLimited uint64
+	// The number of messages rejected by Settings.Validation.
+	RejectedInvalid uint64
+}
+
+// counters are the counters behind Stats, updated atomically.
+type counters struct {
+	rejectedTooLarge    uint64
+	rejectedMalformed   uint64
+	rejectedRateLimited uint64
+	rejectedInvalid     uint64
+}
+
+func (c *counters) stats() Stats {
+	return Stats{
+		RejectedTooLarge:    atomic.LoadUint64(&c.rejectedTooLarge),
+		RejectedMalformed:   atomic.LoadUint64(&c.rejectedMalformed),
+		RejectedRateLimited: atomic.LoadUint64(&c.rejectedRateLimited),
+		RejectedInvalid:     atomic.LoadUint64(&c.rejectedInvalid),
+	}
+}
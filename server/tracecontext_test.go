@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/server/types"
+	"github.com/open-telemetry/opamp-go/tracecontext"
+)
+
+func TestServerTraceContext(t *testing.T) {
+	agentTraceContext := tracecontext.TraceContext{
+		TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		TraceState:  "congo=t61rcWkgMzE",
+	}
+	spanTraceContext := tracecontext.TraceContext{
+		TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-b7ad6b7169203331-01",
+	}
+
+	var connecting, connection, parent, messageTraceContext atomic.Value
+	var spansEnded int64
+	callbacks := CallbacksStruct{
+		OnConnectingFunc: func(request *http.Request) types.ConnectionResponse {
+			connecting.Store(tracecontext.FromContext(request.Context()))
+			return types.ConnectionResponse{Accept: true}
+		},
+		OnMessageFunc: func(conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			connection.Store(conn.TraceContext())
+			messageTraceContext.Store(tracecontext.FromContext(conn.Context()))
+			return &protobufs.ServerToAgent{}
+		},
+	}
+	startSpan := func(ctx context.Context, name string, p tracecontext.TraceContext) (context.Context, func()) {
+		parent.Store(p)
+		return tracecontext.ContextWith(ctx, spanTraceContext), func() { atomic.AddInt64(&spansEnded, 1) }
+	}
+	settings := &StartSettings{Settings: Settings{Callbacks: callbacks, StartSpan: startSpan}}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	// A plain HTTP request exchanges the trace context in the headers.
+	b, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: "12345678"})
+	require.NoError(t, err)
+	req, err := http.NewRequest("POST", "http://"+settings.ListenEndpoint+settings.ListenPath, bytes.NewReader(b))
+	require.NoError(t, err)
+	req.Header.Set(headerContentType, contentTypeProtobuf)
+	tracecontext.Inject(req.Header, agentTraceContext)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.EqualValues(t, agentTraceContext, connecting.Load())
+	assert.EqualValues(t, agentTraceContext, connection.Load())
+	assert.EqualValues(t, agentTraceContext, parent.Load())
+	assert.EqualValues(t, spanTraceContext, messageTraceContext.Load())
+	assert.EqualValues(t, spanTraceContext, tracecontext.Extract(resp.Header))
+	eventually(t, func() bool { return atomic.LoadInt64(&spansEnded) == 1 })
+
+	// A WebSocket connection has the trace context of the dial.
+	header := http.Header{}
+	tracecontext.Inject(header, agentTraceContext)
+	wsConn, _, err := websocket.DefaultDialer.Dial("ws://"+settings.ListenEndpoint+settings.ListenPath, header)
+	require.NoError(t, err)
+	defer wsConn.Close()
+	connection.Store(tracecontext.TraceContext{})
+	parent.Store(tracecontext.TraceContext{})
+	messageTraceContext.Store(tracecontext.TraceContext{})
+	wsExchange(t, wsConn, "12345678")
+
+	assert.EqualValues(t, agentTraceContext, connection.Load())
+	assert.EqualValues(t, agentTraceContext, parent.Load())
+	assert.EqualValues(t, spanTraceContext, messageTraceContext.Load())
+	eventually(t, func() bool { return atomic.LoadInt64(&spansEnded) == 2 })
+
+	// A request without trace context has none.
+	resp, err = http.Post("http://"+settings.ListenEndpoint+settings.ListenPath, contentTypeProtobuf, bytes.NewReader(b))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.EqualValues(t, tracecontext.TraceContext{}, connecting.Load())
+	assert.EqualValues(t, tracecontext.TraceContext{}, connection.Load())
+}
+
+func TestServerSpanEndsAfterAsyncResponse(t *testing.T) {
+	conns := make(chan types.Connection, 1)
+	var spansEnded int64
+	callbacks := CallbacksStruct{
+		OnMessageFunc: func(conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			// Respond later.
+			conns <- conn
+			return nil
+		},
+	}
+	startSpan := func(ctx context.Context, name string, p tracecontext.TraceContext) (context.Context, func()) {
+		return ctx, func() { atomic.AddInt64(&spansEnded, 1) }
+	}
+	settings := &StartSettings{Settings: Settings{Callbacks: callbacks, StartSpan: startSpan}}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	// The span of a plain HTTP request ends when the held request is responded.
+	b, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: "12345678"})
+	require.NoError(t, err)
+	responded := make(chan struct{})
+	go func() {
+		defer close(responded)
+		resp, err := http.Post("http://"+settings.ListenEndpoint+settings.ListenPath, contentTypeProtobuf, bytes.NewReader(b))
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+	}()
+	conn := <-conns
+	assert.EqualValues(t, 0, atomic.LoadInt64(&spansEnded))
+	require.NoError(t, conn.Send(context.Background(), &protobufs.ServerToAgent{}))
+	<-responded
+	eventually(t, func() bool { return atomic.LoadInt64(&spansEnded) == 1 })
+
+	// The span of a WebSocket message ends when the response is sent by the
+	// application, the messages sent by the Server in the meantime are not the
+	// response.
+	wsConn, _, err := dialClient(settings)
+	require.NoError(t, err)
+	defer wsConn.Close()
+	require.NoError(t, wsConn.WriteMessage(websocket.BinaryMessage, b))
+	conn = <-conns
+	assert.Nil(t, conn.Context().Err())
+	require.NoError(t, srv.Enqueue("12345678", &protobufs.ServerToAgent{RemoteConfig: &protobufs.AgentRemoteConfig{}}))
+	_, _, err = wsConn.ReadMessage()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt64(&spansEnded))
+	require.NoError(t, conn.Send(context.Background(), &protobufs.ServerToAgent{}))
+	assert.EqualValues(t, 2, atomic.LoadInt64(&spansEnded))
+
+	// The span of a message that is never responded ends when the connection is
+	// closed, the context of the connection is cancelled then.
+	require.NoError(t, wsConn.WriteMessage(websocket.BinaryMessage, b))
+	conn = <-conns
+	require.NoError(t, wsConn.Close())
+	eventually(t, func() bool { return atomic.LoadInt64(&spansEnded) == 3 })
+	eventually(t, func() bool { return conn.Context().Err() != nil })
+}
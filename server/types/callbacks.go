@@ -34,10 +34,17 @@ type Callbacks interface {
 	OnConnected(conn Connection)
 
 	// OnMessage is called when a message is received from the connection. Can happen
-	// only after OnConnected(). Must return a ServerToAgent message that will be sent
-	// as a response to the Agent.
+	// only after OnConnected(). Returns a ServerToAgent message that will be sent
+	// as a response to the Agent. Returning nil means there is no response now and
+	// the response may be sent later from any goroutine using conn.Send(), e.g. after
+	// consulting a database. For plain HTTP requests the request is held until
+	// conn.Send() is called or the AsyncResponseTimeout of the Server elapses.
+	// conn.Send() of plain HTTP connections fails until OnMessage returns.
 	// For plain HTTP requests once OnMessage returns and the response is sent
 	// to the Agent the OnConnectionClose message will be called immediately.
+	// Long-poll requests of plain HTTP Agents carry no status and are not passed
+	// to OnMessage, they are held until a message is enqueued for the Agent using
+	// Server.Enqueue() or the long-poll timeout elapses.
 	OnMessage(conn Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent
 
 	// OnConnectionClose is called when the OpAMP connection is closed.
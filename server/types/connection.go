@@ -2,9 +2,24 @@ package types
 
 import (
 	"context"
+	"errors"
 	"net"
 
 	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/tracecontext"
+)
+
+var (
+	// ErrConnectionClosed is returned by Connection.Send if the connection is closed.
+	ErrConnectionClosed = errors.New("connection is closed")
+
+	// ErrSendQueueFull is returned by Connection.Send if too many messages are
+	// waiting to be written to the connection.
+	ErrSendQueueFull = errors.New("send queue is full")
+
+	// ErrSendTimeout is returned by Connection.Send if the message could not be
+	// written before the write deadline.
+	ErrSendTimeout = errors.New("send timed out")
 )
 
 // Connection represents one OpAMP connection.
@@ -13,10 +28,31 @@ type Connection interface {
 	// RemoteAddr returns the remote network address of the connection.
 	RemoteAddr() net.Addr
 
-	// Send a message. Should not be called concurrently for the same Connection instance.
-	// Can be called only for WebSocket connections. Will return an error for plain HTTP
-	// connections.
-	// Blocks until the message is sent.
-	// Should return as soon as possible if the ctx is cancelled.
+	// Principal returns the authenticated identity of the Agent or nil if the
+	// Server has no Authenticator configured.
+	Principal() *Principal
+
+	// TraceContext returns the W3C trace context sent by the Agent in the headers
+	// of the WebSocket dial or of the plain HTTP request. Returns the zero value
+	// if the Agent sent no valid trace context.
+	TraceContext() tracecontext.TraceContext
+
+	// Context returns the context of the message passed to the current or the
+	// last OnMessage call of the connection. The context carries the span started
+	// by Settings.StartSpan of the Server, if any, the Principal and the trace
+	// context of the Agent. Before the first OnMessage call it is the context of
+	// the connection. The context of a WebSocket connection is cancelled when the
+	// connection is closed.
+	Context() context.Context
+
+	// Send a message. Safe to call concurrently from any goroutine.
+	// Can be called only for WebSocket connections and for plain HTTP connections
+	// while the request is held after OnMessage returned, see OnMessage. For plain
+	// HTTP connections returns an error immediately if OnMessage did not return
+	// yet or if the request is already responded.
+	// Blocks until the message is sent. Returns ErrSendQueueFull, ErrSendTimeout
+	// or ErrConnectionClosed if the message cannot be sent.
+	// Returns as soon as possible if the ctx is cancelled. The deadline of the ctx,
+	// if any, is used as the write deadline.
 	Send(ctx context.Context, message *protobufs.ServerToAgent) error
 }
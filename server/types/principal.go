@@ -0,0 +1,34 @@
+package types
+
+import "context"
+
+// Principal is the authenticated identity of the Agent, see server.Authenticator.
+type Principal struct {
+	// Name identifies the Agent, e.g. the user name, the subject of the token or
+	// the common name of the client certificate.
+	Name string
+
+	// Method is the authentication method, e.g. "bearer", "basic" or "mtls".
+	Method string
+
+	// Attributes are optional additional properties of the principal, e.g. the
+	// claims of the token.
+	Attributes map[string]string
+}
+
+type principalContextKeyType struct{}
+
+var principalContextKey = principalContextKeyType{}
+
+// ContextWithPrincipal returns a copy of ctx that carries the principal.
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey, principal)
+}
+
+// PrincipalFromContext returns the principal carried by ctx or nil if there is none.
+// The Server sets the principal in the context of the request passed to
+// Callbacks.OnConnecting.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalContextKey).(*Principal)
+	return principal
+}
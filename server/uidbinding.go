@@ -0,0 +1,200 @@
+package server
+
+import (
+	"container/list"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/server/types"
+)
+
+// InstanceUidViolationAction defines what the Server does when an Agent uses an
+// instance UID it is not allowed to use.
+type InstanceUidViolationAction int
+
+const (
+	// RejectInstanceUid responds with ServerErrorResponse of BadRequest type
+	// without calling OnMessage. WebSocket connections are closed after that.
+	RejectInstanceUid InstanceUidViolationAction = iota
+
+	// ReIdentifyInstanceUid responds with ServerErrorResponse of BadRequest type and
+	// AgentIdentification with a newly generated instance UID without calling
+	// OnMessage. With BindOnFirstUse the new instance UID is bound to the principal.
+	// Can't be combined with Verify, Attach and Start return an error in that case
+	// since the generated instance UID would not be accepted by Verify either.
+	ReIdentifyInstanceUid
+)
+
+const defaultMaxBindings = 100000
+
+var errReIdentifyWithVerify = errors.New("InstanceUidBinding.Verify can't be combined with ReIdentifyInstanceUid")
+
+// InstanceUidBinding defines how the Server verifies that the Agents use the
+// instance UIDs they are allowed to use, see Settings.InstanceUidBinding.
+type InstanceUidBinding struct {
+	// StableOnConnection requires that all messages received over a WebSocket
+	// connection use the same instance UID, unless the Server instructed the Agent
+	// to use a new instance UID via AgentIdentification.
+	StableOnConnection bool
+
+	// Verify is an optional func that returns true if the Agent authenticated as
+	// the principal may use the instance UID, e.g. if the instance UID is registered
+	// to the principal. principal is nil if the Server has no Authenticator.
+	// See also VerifyPrincipalName.
+	Verify func(principal *types.Principal, instanceUid string) bool
+
+	// BindOnFirstUse, if true, binds the instance UID to the principal that used it
+	// first. Using the instance UID with a different principal is a violation.
+	// The bindings are kept in memory for the lifetime of the Server, up to
+	// MaxBindings.
+	BindOnFirstUse bool
+
+	// MaxBindings is the maximum number of bindings kept in memory with
+	// BindOnFirstUse. When the limit is reached the least recently used binding is
+	// forgotten and its instance UID can be bound to any principal again.
+	// 0 means the default of 100000.
+	MaxBindings int
+
+	// OnViolation defines what the Server does when the instance UID can't be used.
+	OnViolation InstanceUidViolationAction
+}
+
+// VerifyPrincipalName can be used as InstanceUidBinding.Verify to require that
+// the instance UID is equal to the name of the authenticated principal, e.g. the
+// common name of the client certificate.
+func VerifyPrincipalName(principal *types.Principal, instanceUid string) bool {
+	return principal != nil && principal.Name == instanceUid
+}
+
+// uidBinder enforces the InstanceUidBinding. It is safe to call methods of this
+// struct concurrently.
+type uidBinder struct {
+	settings InstanceUidBinding
+
+	// Bindings by instance UID, see InstanceUidBinding.BindOnFirstUse. The
+	// elements of boundOrder are *binding values, the most recently used first.
+	bound      map[string]*list.Element
+	boundOrder *list.List
+	maxBound   int
+	boundMutex sync.Mutex
+}
+
+// binding is the principal name the instance UID is bound to.
+type binding struct {
+	instanceUid   string
+	principalName string
+}
+
+func newUidBinder(settings InstanceUidBinding) (*uidBinder, error) {
+	if settings.Verify != nil && settings.OnViolation == ReIdentifyInstanceUid {
+		return nil, errReIdentifyWithVerify
+	}
+	maxBound := settings.MaxBindings
+	if maxBound <= 0 {
+		maxBound = defaultMaxBindings
+	}
+	return &uidBinder{
+		settings:   settings,
+		bound:      map[string]*list.Element{},
+		boundOrder: list.New(),
+		maxBound:   maxBound,
+	}, nil
+}
+
+// check returns the reason why the Agent authenticated as the principal can't use
+// the instance UID or empty string if it can. currentUid is the instance UID used
+// so far on the connection and assignedUid is the instance UID the Agent was
+// instructed to use via AgentIdentification, both are empty for plain HTTP.
+func (b *uidBinder) check(principal *types.Principal, currentUid, assignedUid, instanceUid string) string {
+	if b.settings.StableOnConnection && currentUid != "" &&
+		instanceUid != currentUid && instanceUid != assignedUid {
+		return "instance UID must not change during the connection"
+	}
+
+	if b.settings.Verify != nil && !b.settings.Verify(principal, instanceUid) {
+		return "instance UID is not registered to the authenticated Agent"
+	}
+
+	if b.settings.BindOnFirstUse {
+		name := principalName(principal)
+
+		b.boundMutex.Lock()
+		defer b.boundMutex.Unlock()
+		if elem, ok := b.bound[instanceUid]; ok && elem.Value.(*binding).principalName != name {
+			return "instance UID is bound to a different Agent"
+		}
+		b.put(instanceUid, name)
+	}
+	return ""
+}
+
+// violationResponse returns the response to send to the Agent that used the
+// instance UID it is not allowed to use. If the Agent is re-identified the
+// response carries the new instance UID.
+func (b *uidBinder) violationResponse(
+	principal *types.Principal, instanceUid string, reason string,
+) *protobufs.ServerToAgent {
+	response := badRequestResponse(instanceUid, reason)
+	if b.settings.OnViolation == ReIdentifyInstanceUid {
+		b.bind(principal, reIdentify(response))
+	}
+	return response
+}
+
+// bind binds the instance UID to the principal if BindOnFirstUse is set.
+func (b *uidBinder) bind(principal *types.Principal, instanceUid string) {
+	if !b.settings.BindOnFirstUse {
+		return
+	}
+	b.boundMutex.Lock()
+	defer b.boundMutex.Unlock()
+	b.put(instanceUid, principalName(principal))
+}
+
+// put binds the instance UID to the principal name and forgets the least recently
+// used binding if there are too many. Must be called with boundMutex held.
+func (b *uidBinder) put(instanceUid string, name string) {
+	if elem, ok := b.bound[instanceUid]; ok {
+		elem.Value.(*binding).principalName = name
+		b.boundOrder.MoveToFront(elem)
+		return
+	}
+	b.bound[instanceUid] = b.boundOrder.PushFront(&binding{instanceUid: instanceUid, principalName: name})
+	if b.boundOrder.Len() > b.maxBound {
+		oldest := b.boundOrder.Back()
+		b.boundOrder.Remove(oldest)
+		delete(b.bound, oldest.Value.(*binding).instanceUid)
+	}
+}
+
+// badRequestResponse returns the response that rejects the message of the Agent.
+func badRequestResponse(instanceUid string, reason string) *protobufs.ServerToAgent {
+	return &protobufs.ServerToAgent{
+		InstanceUid: instanceUid,
+		ErrorResponse: &protobufs.ServerErrorResponse{
+			Type:         protobufs.ServerErrorResponse_BadRequest,
+			ErrorMessage: reason,
+		},
+	}
+}
+
+// reIdentify instructs the Agent to use a newly generated instance UID and to
+// report its description using the new instance UID. Returns the new instance UID.
+func reIdentify(response *protobufs.ServerToAgent) string {
+	newUid := ulid.MustNew(ulid.Timestamp(time.Now()), rand.Reader).String()
+	response.AgentIdentification = &protobufs.AgentIdentification{NewInstanceUid: newUid}
+	response.Flags |= protobufs.ServerToAgent_ReportAgentDescription
+	return newUid
+}
+
+func principalName(principal *types.Principal) string {
+	if principal == nil {
+		return ""
+	}
+	return principal.Name
+}
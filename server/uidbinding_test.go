@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/server/types"
+)
+
+func TestUidBinderBindOnFirstUse(t *testing.T) {
+	b, err := newUidBinder(InstanceUidBinding{BindOnFirstUse: true})
+	require.NoError(t, err)
+	agent1 := &types.Principal{Name: "agent1"}
+	agent2 := &types.Principal{Name: "agent2"}
+
+	assert.Empty(t, b.check(agent1, "", "", "uid1"))
+	assert.Empty(t, b.check(agent1, "", "", "uid1"))
+	assert.NotEmpty(t, b.check(agent2, "", "", "uid1"))
+	assert.Empty(t, b.check(agent2, "", "", "uid2"))
+}
+
+func TestUidBinderMaxBindings(t *testing.T) {
+	b, err := newUidBinder(InstanceUidBinding{BindOnFirstUse: true, MaxBindings: 2})
+	require.NoError(t, err)
+	agent1 := &types.Principal{Name: "agent1"}
+	agent2 := &types.Principal{Name: "agent2"}
+
+	assert.Empty(t, b.check(agent1, "", "", "uid1"))
+	assert.Empty(t, b.check(agent1, "", "", "uid2"))
+	assert.Empty(t, b.check(agent1, "", "", "uid1"))
+
+	// uid2 is the least recently used binding and is forgotten.
+	assert.Empty(t, b.check(agent1, "", "", "uid3"))
+	assert.Len(t, b.bound, 2)
+	assert.Empty(t, b.check(agent2, "", "", "uid2"))
+	assert.NotEmpty(t, b.check(agent2, "", "", "uid3"))
+}
+
+func TestUidBinderStableOnConnection(t *testing.T) {
+	b, err := newUidBinder(InstanceUidBinding{StableOnConnection: true})
+	require.NoError(t, err)
+
+	assert.Empty(t, b.check(nil, "", "", "uid1"))
+	assert.Empty(t, b.check(nil, "uid1", "", "uid1"))
+	assert.NotEmpty(t, b.check(nil, "uid1", "", "uid2"))
+	assert.Empty(t, b.check(nil, "uid1", "uid2", "uid2"))
+}
+
+func TestUidBinderReIdentify(t *testing.T) {
+	b, err := newUidBinder(InstanceUidBinding{BindOnFirstUse: true, OnViolation: ReIdentifyInstanceUid})
+	require.NoError(t, err)
+	agent1 := &types.Principal{Name: "agent1"}
+
+	response := b.violationResponse(agent1, "uid1", "reason")
+	assert.EqualValues(t, "uid1", response.InstanceUid)
+	assert.EqualValues(t, protobufs.ServerErrorResponse_BadRequest, response.ErrorResponse.Type)
+	newUid := response.AgentIdentification.NewInstanceUid
+	require.NotEmpty(t, newUid)
+
+	// The new instance UID is bound to the principal.
+	assert.Empty(t, b.check(agent1, "", "", newUid))
+	assert.NotEmpty(t, b.check(&types.Principal{Name: "agent2"}, "", "", newUid))
+}
+
+func TestUidBinderReIdentifyWithVerify(t *testing.T) {
+	binding := &InstanceUidBinding{Verify: VerifyPrincipalName, OnViolation: ReIdentifyInstanceUid}
+
+	// The generated instance UID would be rejected by Verify again.
+	_, err := New(nil).Attach(Settings{InstanceUidBinding: binding})
+	assert.ErrorIs(t, err, errReIdentifyWithVerify)
+}
+
+func TestServerInstanceUidBindingWS(t *testing.T) {
+	var rcvCount int64
+	callbacks := CallbacksStruct{
+		OnMessageFunc: func(conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			atomic.AddInt64(&rcvCount, 1)
+			return &protobufs.ServerToAgent{}
+		},
+	}
+	binding := &InstanceUidBinding{
+		StableOnConnection: true,
+		Verify: func(principal *types.Principal, instanceUid string) bool {
+			return instanceUid != "forbidden"
+		},
+	}
+	settings := &StartSettings{Settings: Settings{Callbacks: callbacks, InstanceUidBinding: binding}}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	conn, _, err := dialClient(settings)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	send := func(instanceUid string) *protobufs.ServerToAgent {
+		b, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: instanceUid})
+		require.NoError(t, err)
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, b))
+
+		_, b, err = conn.ReadMessage()
+		require.NoError(t, err)
+		var response protobufs.ServerToAgent
+		require.NoError(t, proto.Unmarshal(b, &response))
+		return &response
+	}
+
+	response := send("uid1")
+	assert.Nil(t, response.ErrorResponse)
+	assert.EqualValues(t, 1, atomic.LoadInt64(&rcvCount))
+
+	// Changing the instance UID is rejected and the connection is closed.
+	response = send("uid2")
+	assert.EqualValues(t, "uid2", response.InstanceUid)
+	assert.EqualValues(t, protobufs.ServerErrorResponse_BadRequest, response.ErrorResponse.Type)
+	assert.EqualValues(t, 1, atomic.LoadInt64(&rcvCount))
+
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation))
+
+	// Instance UIDs not accepted by Verify are rejected on a new connection too.
+	conn, _, err = dialClient(settings)
+	require.NoError(t, err)
+	defer conn.Close()
+	response = send("forbidden")
+	assert.EqualValues(t, protobufs.ServerErrorResponse_BadRequest, response.ErrorResponse.Type)
+	assert.EqualValues(t, 1, atomic.LoadInt64(&rcvCount))
+}
+
+func TestServerInstanceUidBindingReIdentifyWS(t *testing.T) {
+	var rcvCount int64
+	callbacks := CallbacksStruct{
+		OnMessageFunc: func(conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			atomic.AddInt64(&rcvCount, 1)
+			return &protobufs.ServerToAgent{}
+		},
+	}
+	binding := &InstanceUidBinding{
+		StableOnConnection: true,
+		BindOnFirstUse:     true,
+		OnViolation:        ReIdentifyInstanceUid,
+	}
+	settings := &StartSettings{Settings: Settings{Callbacks: callbacks, InstanceUidBinding: binding}}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	conn, _, err := dialClient(settings)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	send := func(instanceUid string) *protobufs.ServerToAgent {
+		b, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: instanceUid})
+		require.NoError(t, err)
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, b))
+
+		_, b, err = conn.ReadMessage()
+		require.NoError(t, err)
+		var response protobufs.ServerToAgent
+		require.NoError(t, proto.Unmarshal(b, &response))
+		return &response
+	}
+
+	send("uid1")
+	response := send("uid2")
+	assert.EqualValues(t, protobufs.ServerErrorResponse_BadRequest, response.ErrorResponse.Type)
+	newUid := response.AgentIdentification.GetNewInstanceUid()
+	require.NotEmpty(t, newUid)
+
+	// The connection stays open and the Agent may switch to the new instance UID.
+	response = send(newUid)
+	assert.Nil(t, response.ErrorResponse)
+	assert.EqualValues(t, newUid, response.InstanceUid)
+	assert.EqualValues(t, 2, atomic.LoadInt64(&rcvCount))
+
+	// The Agent keeps using the new instance UID without being re-identified again.
+	response = send(newUid)
+	assert.Nil(t, response.ErrorResponse)
+	assert.Nil(t, response.AgentIdentification)
+	assert.EqualValues(t, 3, atomic.LoadInt64(&rcvCount))
+}
+
+func TestServerInstanceUidBindingPlainHTTP(t *testing.T) {
+	var rcvCount int64
+	callbacks := CallbacksStruct{
+		OnMessageFunc: func(conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			atomic.AddInt64(&rcvCount, 1)
+			return &protobufs.ServerToAgent{}
+		},
+	}
+	binding := &InstanceUidBinding{Verify: VerifyPrincipalName}
+	authenticator := NewBearerAuthenticator(map[string]string{"secret": "agent1"})
+	settings := &StartSettings{
+		Settings: Settings{Callbacks: callbacks, Authenticator: authenticator, InstanceUidBinding: binding},
+	}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	post := func(instanceUid string) *protobufs.ServerToAgent {
+		b, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: instanceUid})
+		require.NoError(t, err)
+		req, err := http.NewRequest("POST", "http://"+settings.ListenEndpoint+settings.ListenPath, bytes.NewReader(b))
+		require.NoError(t, err)
+		req.Header.Set(headerContentType, contentTypeProtobuf)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+
+		b, err = io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		var response protobufs.ServerToAgent
+		require.NoError(t, proto.Unmarshal(b, &response))
+		return &response
+	}
+
+	response := post("agent1")
+	assert.Nil(t, response.ErrorResponse)
+	assert.EqualValues(t, 1, atomic.LoadInt64(&rcvCount))
+
+	response = post("agent2")
+	assert.EqualValues(t, "agent2", response.InstanceUid)
+	assert.EqualValues(t, protobufs.ServerErrorResponse_BadRequest, response.ErrorResponse.Type)
+	assert.EqualValues(t, 1, atomic.LoadInt64(&rcvCount))
+}
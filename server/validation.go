@@ -0,0 +1,133 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/server/types"
+)
+
+// ValidationRule checks the message received from the Agent and returns an error
+// describing the problem if the message is not valid. capabilities are the
+// capabilities the Agent declared in this message or, for WebSocket connections,
+// in an earlier message on the connection. capabilities are 0 if not known.
+type ValidationRule func(
+	conn types.Connection, message *protobufs.AgentToServer, capabilities protobufs.AgentCapabilities,
+) error
+
+// Validation defines how the Server validates the messages received from the
+// Agents, see Settings.Validation.
+type Validation struct {
+	// Rules are the application rules, checked after the rules of the OpAMP
+	// specification.
+	Rules []ValidationRule
+}
+
+// specRules are the rules of the OpAMP specification. Messages that carry only
+// the hashes of the unchanged fields are valid.
+var specRules = []ValidationRule{
+	validateInstanceUid,
+	validateEffectiveConfig,
+	validateRemoteConfigStatus,
+	validatePackageStatuses,
+}
+
+func validateInstanceUid(_ types.Connection, message *protobufs.AgentToServer, _ protobufs.AgentCapabilities) error {
+	if message.InstanceUid == "" {
+		return errors.New("instance_uid is not set")
+	}
+	return nil
+}
+
+func validateEffectiveConfig(
+	_ types.Connection, message *protobufs.AgentToServer, capabilities protobufs.AgentCapabilities,
+) error {
+	if message.EffectiveConfig == nil {
+		return nil
+	}
+	if err := requireCapability(capabilities, protobufs.AgentCapabilities_ReportsEffectiveConfig, "effective_config"); err != nil {
+		return err
+	}
+	if len(message.EffectiveConfig.Hash) == 0 {
+		return errors.New("effective_config.hash is not set")
+	}
+	return nil
+}
+
+func validateRemoteConfigStatus(
+	_ types.Connection, message *protobufs.AgentToServer, capabilities protobufs.AgentCapabilities,
+) error {
+	status := message.RemoteConfigStatus
+	if status == nil {
+		return nil
+	}
+	// The Agents report the initial UNSET status even if they do not accept
+	// remote config.
+	if status.Status != protobufs.RemoteConfigStatus_UNSET || len(status.LastRemoteConfigHash) != 0 {
+		err := requireCapability(capabilities, protobufs.AgentCapabilities_AcceptsRemoteConfig, "remote_config_status")
+		if err != nil {
+			return err
+		}
+	}
+	if len(status.Hash) == 0 {
+		return errors.New("remote_config_status.hash is not set")
+	}
+	if status.Status != protobufs.RemoteConfigStatus_UNSET && len(status.LastRemoteConfigHash) == 0 {
+		return errors.New("remote_config_status.last_remote_config_hash is not set")
+	}
+	if status.Status != protobufs.RemoteConfigStatus_FAILED && status.ErrorMessage != "" {
+		return errors.New("remote_config_status.error_message is set but status is not FAILED")
+	}
+	return nil
+}
+
+func validatePackageStatuses(
+	_ types.Connection, message *protobufs.AgentToServer, capabilities protobufs.AgentCapabilities,
+) error {
+	statuses := message.PackageStatuses
+	if statuses == nil {
+		return nil
+	}
+	if err := requireCapability(capabilities, protobufs.AgentCapabilities_ReportsPackageStatuses, "package_statuses"); err != nil {
+		return err
+	}
+	if len(statuses.Hash) == 0 {
+		return errors.New("package_statuses.hash is not set")
+	}
+	if len(statuses.Packages) > 0 && len(statuses.ServerProvidedAllPackagesHash) == 0 {
+		return errors.New("package_statuses.server_provided_all_packages_hash is not set")
+	}
+	for name, status := range statuses.Packages {
+		if status.GetName() != name {
+			return fmt.Errorf("package_statuses.packages key %q does not match the package name %q", name, status.GetName())
+		}
+	}
+	return nil
+}
+
+// requireCapability returns an error if the capabilities are known and do not
+// include the capability required to report the field.
+func requireCapability(
+	capabilities protobufs.AgentCapabilities, capability protobufs.AgentCapabilities, field string,
+) error {
+	if capabilities != 0 && capabilities&capability == 0 {
+		return fmt.Errorf("%s is reported without %s capability", field, capability)
+	}
+	return nil
+}
+
+// validate checks the message against the rules of the OpAMP specification and
+// the application rules. Returns the first error.
+func (v *Validation) validate(
+	conn types.Connection, message *protobufs.AgentToServer, capabilities protobufs.AgentCapabilities,
+) error {
+	for _, rules := range [][]ValidationRule{specRules, v.Rules} {
+		for _, rule := range rules {
+			if err := rule(conn, message, capabilities); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
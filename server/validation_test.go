@@ -0,0 +1,210 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"github.com/open-telemetry/opamp-go/server/types"
+)
+
+func TestValidationSpecRules(t *testing.T) {
+	hash := []byte{1}
+	allCapabilities := protobufs.AgentCapabilities(0xffff)
+
+	tests := []struct {
+		name         string
+		message      *protobufs.AgentToServer
+		capabilities protobufs.AgentCapabilities
+		valid        bool
+	}{
+		{
+			name:    "minimal",
+			message: &protobufs.AgentToServer{InstanceUid: "uid"},
+			valid:   true,
+		},
+		{
+			name:    "no instance uid",
+			message: &protobufs.AgentToServer{},
+		},
+		{
+			name: "compressed",
+			message: &protobufs.AgentToServer{
+				InstanceUid:        "uid",
+				EffectiveConfig:    &protobufs.EffectiveConfig{Hash: hash},
+				RemoteConfigStatus: &protobufs.RemoteConfigStatus{Hash: hash},
+				PackageStatuses:    &protobufs.PackageStatuses{Hash: hash},
+			},
+			capabilities: allCapabilities,
+			valid:        true,
+		},
+		{
+			name: "effective config without hash",
+			message: &protobufs.AgentToServer{
+				InstanceUid:     "uid",
+				EffectiveConfig: &protobufs.EffectiveConfig{},
+			},
+		},
+		{
+			name: "effective config without capability",
+			message: &protobufs.AgentToServer{
+				InstanceUid:     "uid",
+				EffectiveConfig: &protobufs.EffectiveConfig{Hash: hash},
+			},
+			capabilities: protobufs.AgentCapabilities_ReportsStatus,
+		},
+		{
+			name: "initial remote config status without capability",
+			message: &protobufs.AgentToServer{
+				InstanceUid:        "uid",
+				RemoteConfigStatus: &protobufs.RemoteConfigStatus{Hash: hash},
+			},
+			capabilities: protobufs.AgentCapabilities_ReportsPackageStatuses,
+			valid:        true,
+		},
+		{
+			name: "remote config status without capability",
+			message: &protobufs.AgentToServer{
+				InstanceUid: "uid",
+				RemoteConfigStatus: &protobufs.RemoteConfigStatus{
+					Hash:                 hash,
+					LastRemoteConfigHash: hash,
+					Status:               protobufs.RemoteConfigStatus_APPLIED,
+				},
+			},
+			capabilities: protobufs.AgentCapabilities_ReportsPackageStatuses,
+		},
+		{
+			name: "remote config status without last remote config hash",
+			message: &protobufs.AgentToServer{
+				InstanceUid: "uid",
+				RemoteConfigStatus: &protobufs.RemoteConfigStatus{
+					Hash:   hash,
+					Status: protobufs.RemoteConfigStatus_APPLIED,
+				},
+			},
+		},
+		{
+			name: "remote config status with error message",
+			message: &protobufs.AgentToServer{
+				InstanceUid: "uid",
+				RemoteConfigStatus: &protobufs.RemoteConfigStatus{
+					Hash:                 hash,
+					LastRemoteConfigHash: hash,
+					Status:               protobufs.RemoteConfigStatus_APPLIED,
+					ErrorMessage:         "error",
+				},
+			},
+		},
+		{
+			name: "package statuses without server provided all packages hash",
+			message: &protobufs.AgentToServer{
+				InstanceUid: "uid",
+				PackageStatuses: &protobufs.PackageStatuses{
+					Hash:     hash,
+					Packages: map[string]*protobufs.PackageStatus{"pkg": {Name: "pkg"}},
+				},
+			},
+		},
+		{
+			name: "package status name mismatch",
+			message: &protobufs.AgentToServer{
+				InstanceUid: "uid",
+				PackageStatuses: &protobufs.PackageStatuses{
+					Hash:                          hash,
+					Packages:                      map[string]*protobufs.PackageStatus{"pkg": {Name: "other"}},
+					ServerProvidedAllPackagesHash: hash,
+				},
+			},
+		},
+	}
+
+	v := &Validation{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := v.validate(nil, test.message, test.capabilities)
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestServerValidation(t *testing.T) {
+	var rcvCount int64
+	callbacks := CallbacksStruct{
+		OnMessageFunc: func(conn types.Connection, message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+			atomic.AddInt64(&rcvCount, 1)
+			return &protobufs.ServerToAgent{}
+		},
+	}
+	validation := &Validation{
+		Rules: []ValidationRule{
+			func(_ types.Connection, message *protobufs.AgentToServer, _ protobufs.AgentCapabilities) error {
+				if message.InstanceUid == "banned" {
+					return errors.New("instance is banned")
+				}
+				return nil
+			},
+		},
+	}
+	settings := &StartSettings{Settings: Settings{Callbacks: callbacks, Validation: validation}}
+	srv := startServer(t, settings)
+	defer srv.Stop(context.Background())
+
+	conn, _, err := dialClient(settings)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	send := func(message *protobufs.AgentToServer) *protobufs.ServerToAgent {
+		b, err := proto.Marshal(message)
+		require.NoError(t, err)
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, b))
+
+		_, b, err = conn.ReadMessage()
+		require.NoError(t, err)
+		var response protobufs.ServerToAgent
+		require.NoError(t, proto.Unmarshal(b, &response))
+		return &response
+	}
+
+	// The capabilities declared earlier on the connection apply to later messages.
+	response := send(&protobufs.AgentToServer{
+		InstanceUid:  "uid",
+		Capabilities: protobufs.AgentCapabilities_ReportsStatus,
+	})
+	assert.Nil(t, response.ErrorResponse)
+	response = send(&protobufs.AgentToServer{
+		InstanceUid:     "uid",
+		EffectiveConfig: &protobufs.EffectiveConfig{Hash: []byte{1}},
+	})
+	assert.EqualValues(t, protobufs.ServerErrorResponse_BadRequest, response.ErrorResponse.GetType())
+	assert.Contains(t, response.ErrorResponse.GetErrorMessage(), "effective_config")
+	assert.EqualValues(t, 1, atomic.LoadInt64(&rcvCount))
+
+	// The application rules are applied to plain HTTP requests too.
+	b, err := proto.Marshal(&protobufs.AgentToServer{InstanceUid: "banned"})
+	require.NoError(t, err)
+	resp, err := http.Post("http://"+settings.ListenEndpoint+settings.ListenPath, contentTypeProtobuf, bytes.NewReader(b))
+	require.NoError(t, err)
+	b, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.NoError(t, proto.Unmarshal(b, response))
+	assert.EqualValues(t, protobufs.ServerErrorResponse_BadRequest, response.ErrorResponse.GetType())
+	assert.EqualValues(t, "instance is banned", response.ErrorResponse.GetErrorMessage())
+	assert.EqualValues(t, 1, atomic.LoadInt64(&rcvCount))
+	assert.EqualValues(t, 2, srv.Stats().RejectedInvalid)
+}
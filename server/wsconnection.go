@@ -2,30 +2,243 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"google.golang.org/protobuf/proto"
 
+	"github.com/open-telemetry/opamp-go/internal"
 	"github.com/open-telemetry/opamp-go/protobufs"
 	"github.com/open-telemetry/opamp-go/server/types"
+	"github.com/open-telemetry/opamp-go/tracecontext"
+)
+
+const (
+	defaultSendQueueSize    = 16
+	defaultWriteTimeout     = 10 * time.Second
+	defaultMaxWriteFailures = 3
 )
 
 // wsConnection represents a persistent OpAMP connection over a WebSocket.
+// The messages are written to the WebSocket by a dedicated writer goroutine
+// in the order they are queued by Send().
 type wsConnection struct {
 	wsConn *websocket.Conn
+
+	// The address of the Agent, see trustedProxies.remoteAddr.
+	remoteAddr net.Addr
+
+	// The authenticated identity of the Agent, nil if there is no Authenticator.
+	principal *types.Principal
+
+	// The trace context sent by the Agent in the headers of the dial.
+	traceContext tracecontext.TraceContext
+
+	// The context of the connection, cancelled when the connection is closed.
+	ctx context.Context
+
+	// The context of the message being processed.
+	message *messageContext
+
+	// The instance UID of the Agent, known after the first message is received.
+	// Accessed only by the goroutine that processes the received messages.
+	instanceUid string
+
+	// The instance UID the Agent was instructed to use via AgentIdentification.
+	// Accessed only by the goroutine that processes the received messages.
+	assignedUid string
+
+	// The capabilities the Agent declared last on this connection.
+	// Accessed only by the goroutine that processes the received messages.
+	capabilities protobufs.AgentCapabilities
+
+	// Messages waiting to be written by the writer goroutine.
+	queue chan *wsWriteRequest
+
+	// Closed when the connection is closed.
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	writeTimeout     time.Duration
+	maxWriteFailures int
+
+	// Instruments that report the telemetry of the Server.
+	metrics *metrics
+}
+
+// wsWriteRequest is a message queued for writing.
+type wsWriteRequest struct {
+	ctx    context.Context
+	data   []byte
+	result chan error
 }
 
 var _ types.Connection = (*wsConnection)(nil)
 
-func (c wsConnection) RemoteAddr() net.Addr {
-	return c.wsConn.RemoteAddr()
+func newWSConnection(
+	ctx context.Context,
+	wsConn *websocket.Conn,
+	remoteAddr net.Addr,
+	principal *types.Principal,
+	traceContext tracecontext.TraceContext,
+	settings Settings,
+	metrics *metrics,
+) *wsConnection {
+	c := &wsConnection{
+		wsConn:           wsConn,
+		remoteAddr:       remoteAddr,
+		principal:        principal,
+		traceContext:     traceContext,
+		ctx:              ctx,
+		message:          newMessageContext(ctx),
+		closed:           make(chan struct{}),
+		writeTimeout:     settings.WriteTimeout,
+		maxWriteFailures: settings.MaxWriteFailures,
+		metrics:          metrics,
+	}
+
+	queueSize := settings.SendQueueSize
+	if queueSize <= 0 {
+		queueSize = defaultSendQueueSize
+	}
+	c.queue = make(chan *wsWriteRequest, queueSize)
+	if c.writeTimeout <= 0 {
+		c.writeTimeout = defaultWriteTimeout
+	}
+	if c.maxWriteFailures <= 0 {
+		c.maxWriteFailures = defaultMaxWriteFailures
+	}
+
+	go c.writeLoop()
+	return c
+}
+
+func (c *wsConnection) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *wsConnection) Principal() *types.Principal {
+	return c.principal
+}
+
+func (c *wsConnection) TraceContext() tracecontext.TraceContext {
+	return c.traceContext
 }
 
-func (c wsConnection) Send(_ context.Context, message *protobufs.ServerToAgent) error {
+func (c *wsConnection) Context() context.Context {
+	return c.message.get()
+}
+
+// Send is called by the application to send a message, see send. The message is
+// the response to the message for which OnMessage returned nil, if any, so the
+// span of that message ends once the message is written.
+func (c *wsConnection) Send(ctx context.Context, message *protobufs.ServerToAgent) error {
+	err := c.send(ctx, message)
+	if err == nil {
+		c.message.responded()
+	}
+	return err
+}
+
+// send queues the message for writing and waits until it is written.
+// Returns types.ErrSendQueueFull if too many messages are already waiting to
+// be written, types.ErrConnectionClosed if the connection is closed and
+// types.ErrSendTimeout if writing does not complete before the write timeout.
+// If ctx is cancelled send returns ctx.Err() and the message is not written
+// unless writing has already started. Unlike Send, does not end the span of
+// the message whose response is sent asynchronously, since the messages sent by
+// the Server itself, e.g. the enqueued messages, are not that response.
+func (c *wsConnection) send(ctx context.Context, message *protobufs.ServerToAgent) error {
 	bytes, err := proto.Marshal(message)
 	if err != nil {
 		return err
 	}
-	return c.wsConn.WriteMessage(websocket.BinaryMessage, bytes)
+
+	req := &wsWriteRequest{ctx: ctx, data: bytes, result: make(chan error, 1)}
+
+	select {
+	case <-c.closed:
+		return types.ErrConnectionClosed
+	default:
+	}
+
+	select {
+	case c.queue <- req:
+	default:
+		return types.ErrSendQueueFull
+	}
+
+	select {
+	case err := <-req.result:
+		if err == nil {
+			c.metrics.messagesSent.Add(1, attrTransportWS)
+			internal.RecordFieldSizes(c.metrics.messageFieldSize, message, attrDirectionSent)
+		}
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.closed:
+		return types.ErrConnectionClosed
+	}
+}
+
+// writeLoop writes the queued messages until the connection is closed. Closes
+// the connection after maxWriteFailures consecutive failed writes.
+func (c *wsConnection) writeLoop() {
+	failures := 0
+	for {
+		select {
+		case req := <-c.queue:
+			if err := req.ctx.Err(); err != nil {
+				// The sender is not waiting anymore.
+				req.result <- err
+				continue
+			}
+
+			err := c.write(req)
+			req.result <- err
+			if err == nil {
+				failures = 0
+				continue
+			}
+
+			failures++
+			if failures >= c.maxWriteFailures {
+				// The Agent is not able to receive messages, drop the connection.
+				_ = c.wsConn.Close()
+				c.close()
+				return
+			}
+
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *wsConnection) write(req *wsWriteRequest) error {
+	deadline := time.Now().Add(c.writeTimeout)
+	if ctxDeadline, ok := req.ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := c.wsConn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+
+	err := c.wsConn.WriteMessage(websocket.BinaryMessage, req.data)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: %v", types.ErrSendTimeout, err)
+	}
+	return err
+}
+
+// close marks the connection closed. Pending and future Send() calls return
+// types.ErrConnectionClosed. Does not close the underlying WebSocket.
+func (c *wsConnection) close() {
+	c.closeOnce.Do(func() { close(c.closed) })
 }
@@ -0,0 +1,123 @@
+// Package tracecontext propagates the W3C Trace Context
+// (https://www.w3.org/TR/trace-context/) across the OpAMP exchanges and
+// defines the hook to start spans around the processing of the OpAMP messages.
+// The package has no dependencies, an adapter can bridge it to any tracing SDK.
+//
+// The client sends the trace context in the traceparent and tracestate headers
+// of the WebSocket dial and of every plain HTTP request. The server makes the
+// received trace context available to the callbacks and sends the trace
+// context of its span back in the headers of the plain HTTP responses.
+// WebSocket messages carry no headers, so the trace context of a WebSocket
+// connection is the one of the dial.
+package tracecontext
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+const (
+	HeaderTraceParent = "traceparent"
+	HeaderTraceState  = "tracestate"
+)
+
+// TraceContext is the value of the traceparent and tracestate headers.
+// The zero value means there is no trace context.
+type TraceContext struct {
+	// TraceParent identifies the parent span, e.g.
+	// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+	TraceParent string
+
+	// TraceState carries the vendor-specific trace data, e.g. "congo=t61rcWkgMzE".
+	TraceState string
+}
+
+// IsValid returns true if TraceParent is well-formed.
+func (tc TraceContext) IsValid() bool {
+	tp := tc.TraceParent
+	if len(tp) < 55 || tp[2] != '-' || tp[35] != '-' || tp[52] != '-' {
+		return false
+	}
+	version, traceID, parentID, flags := tp[0:2], tp[3:35], tp[36:52], tp[53:55]
+	if !isHex(version) || version == "ff" || !isHex(flags) {
+		return false
+	}
+	if !isHex(traceID) || isZero(traceID) || !isHex(parentID) || isZero(parentID) {
+		return false
+	}
+	if version == "00" {
+		return len(tp) == 55
+	}
+	// Future versions may append fields.
+	return len(tp) == 55 || tp[55] == '-'
+}
+
+// Inject sets the headers of the trace context. Does nothing if the trace
+// context is not valid.
+func Inject(header http.Header, tc TraceContext) {
+	if !tc.IsValid() {
+		return
+	}
+	header.Set(HeaderTraceParent, tc.TraceParent)
+	if tc.TraceState != "" {
+		header.Set(HeaderTraceState, tc.TraceState)
+	} else {
+		header.Del(HeaderTraceState)
+	}
+}
+
+// Extract returns the trace context carried by the headers. Returns the zero
+// value if the headers carry no valid trace context.
+func Extract(header http.Header) TraceContext {
+	tc := TraceContext{
+		TraceParent: strings.TrimSpace(header.Get(HeaderTraceParent)),
+		TraceState:  strings.Join(header.Values(HeaderTraceState), ","),
+	}
+	if !tc.IsValid() {
+		return TraceContext{}
+	}
+	return tc
+}
+
+type contextKeyType struct{}
+
+var contextKey = contextKeyType{}
+
+// ContextWith returns a copy of ctx that carries the trace context.
+func ContextWith(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, contextKey, tc)
+}
+
+// FromContext returns the trace context carried by ctx or the zero value if
+// there is none. The server sets the received trace context in the context of
+// the request passed to the OnConnecting callback.
+func FromContext(ctx context.Context) TraceContext {
+	tc, _ := ctx.Value(contextKey).(TraceContext)
+	return tc
+}
+
+// ProviderFunc returns the trace context to send with an outgoing request.
+// ctx is the context of the request.
+type ProviderFunc func(ctx context.Context) TraceContext
+
+// StartSpanFunc starts a span named name around the processing of a message.
+// parent is the trace context received from the peer, the zero value if there
+// is none. Returns ctx carrying the span and the func that ends the span.
+// To let the OpAMP client or server propagate the trace context of the span
+// the returned ctx must carry it, see ContextWith.
+type StartSpanFunc func(ctx context.Context, name string, parent TraceContext) (context.Context, func())
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func isZero(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
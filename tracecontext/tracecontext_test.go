@@ -0,0 +1,61 @@
+package tracecontext
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestIsValid(t *testing.T) {
+	tests := []struct {
+		traceParent string
+		valid       bool
+	}{
+		{testTraceParent, true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true},
+		{"", false},
+		{testTraceParent + "-", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0x", false},
+		{"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01x", false},
+	}
+	for _, test := range tests {
+		assert.EqualValues(t, test.valid, TraceContext{TraceParent: test.traceParent}.IsValid(), test.traceParent)
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	header := http.Header{}
+	Inject(header, TraceContext{TraceParent: "invalid", TraceState: "a=1"})
+	assert.Empty(t, header)
+	assert.EqualValues(t, TraceContext{}, Extract(header))
+
+	tc := TraceContext{TraceParent: testTraceParent, TraceState: "a=1"}
+	Inject(header, tc)
+	assert.EqualValues(t, testTraceParent, header.Get("Traceparent"))
+	assert.EqualValues(t, "a=1", header.Get("Tracestate"))
+	assert.EqualValues(t, tc, Extract(header))
+
+	// Multiple tracestate headers are combined.
+	header.Add(HeaderTraceState, "b=2")
+	assert.EqualValues(t, "a=1,b=2", Extract(header).TraceState)
+
+	// The tracestate of a previous trace context is removed.
+	Inject(header, TraceContext{TraceParent: testTraceParent})
+	assert.Empty(t, header.Values(HeaderTraceState))
+}
+
+func TestContext(t *testing.T) {
+	assert.EqualValues(t, TraceContext{}, FromContext(context.Background()))
+
+	tc := TraceContext{TraceParent: testTraceParent}
+	assert.EqualValues(t, tc, FromContext(ContextWith(context.Background(), tc)))
+}